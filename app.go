@@ -39,12 +39,51 @@ func main() {
 	// 获取一级m3u8 http://127.0.0.1:4000/hls/1.m3u8
 	mux.HandleFunc("/hls/", routers.GetM3U8)
 
+	// 获取二级m3u8 http://127.0.0.1:4000/hls_sub/1.m3u8
+	mux.HandleFunc("/hls_sub/", routers.GetSubM3U8)
+
 	// 获取视频 http://127.0.0.1:4000/video/1_0.ts
 	mux.HandleFunc("/video/", routers.GetVideoStream)
 
+	// 获取源媒体文件，支持 Range 请求 http://127.0.0.1:4000/media/1.ts
+	mux.HandleFunc("/media/", routers.GetMediaFile)
+
+	// 获取I帧m3u8 http://127.0.0.1:4000/hls_iframe/1.m3u8
+	mux.HandleFunc("/hls_iframe/", routers.GetIFrameM3U8)
+
+	// 获取I帧初始化分片 http://127.0.0.1:4000/iframe/1_init.ts
+	mux.HandleFunc("/iframe/", routers.GetIFrameInit)
+
+	// 获取字幕m3u8 http://127.0.0.1:4000/hls_subtitle/1_0.m3u8
+	mux.HandleFunc("/hls_subtitle/", routers.GetSubtitleM3U8)
+
+	// 获取字幕分片 http://127.0.0.1:4000/subtitle/1_0_0.vtt
+	mux.HandleFunc("/subtitle/", routers.GetSubtitle)
+
+	// 获取音频m3u8 http://127.0.0.1:4000/hls_audio/1_0.m3u8
+	mux.HandleFunc("/hls_audio/", routers.GetAudioM3U8)
+
+	// 获取音频分片 http://127.0.0.1:4000/audio/1_0_0.ts
+	mux.HandleFunc("/audio/", routers.GetAudioStream)
+
+	// 获取fMP4分片 http://127.0.0.1:4000/fmp4/1_init.mp4 http://127.0.0.1:4000/fmp4/1_0.m4s
+	mux.HandleFunc("/fmp4/", routers.GetFmp4Stream)
+
+	// 获取fMP4音频分片 http://127.0.0.1:4000/fmp4_audio/1_0_init.mp4 http://127.0.0.1:4000/fmp4_audio/1_0_0.m4s
+	mux.HandleFunc("/fmp4_audio/", routers.GetFmp4AudioStream)
+
+	// 获取分片密钥 http://127.0.0.1:4000/key/1_0.key
+	mux.HandleFunc("/key/", routers.GetKey)
+
 	// 主动创建ts索引 http://127.0.0.1:4000/create_index/1.ts
 	mux.HandleFunc("/api/create_index/", routers.CreateIndex)
 
+	// 查询媒体文件信息 http://127.0.0.1:4000/api/get_media_info/1.ts
+	mux.HandleFunc("/api/get_media_info/", routers.GetMediaInfo)
+
+	// 查询媒体文件损坏情况 http://127.0.0.1:4000/api/get_corruption_report/1.ts
+	mux.HandleFunc("/api/get_corruption_report/", routers.GetCorruptionReport)
+
 	// 查询索引进度
 	mux.HandleFunc("/api/get_process_info", routers.GetProcessInfo)
 
@@ -9,6 +9,9 @@ const ErrorCodeGetIndexFailed = 1
 // ErrorCodeGetStreamFailed 错误码视频流获取失败
 const ErrorCodeGetStreamFailed = 2
 
+// ErrorCodeGetKeyFailed 错误码密钥获取失败
+const ErrorCodeGetKeyFailed = 3
+
 // Error 异常
 type Error struct {
 	ErrCode int
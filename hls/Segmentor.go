@@ -2,6 +2,7 @@ package hls
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -12,10 +13,20 @@ import (
 
 // VideoInfo 视频文件信息
 type VideoInfo struct {
-	Sequence    int     // 序号
-	StartOffset uint64  // 开始偏移量（字节）
-	Size        uint64  // 大小（字节）
-	Duration    float64 // 时长
+	Sequence      int            // 序号
+	StartOffset   uint64         // 开始偏移量（字节）
+	Size          uint64         // 大小（字节）
+	Duration      float64        // 时长(秒)，为下一分片开始时间与本分片开始时间之差
+	StartTime     int64          // 开始时间，单位为 ts.TimeScale，从媒体开始时计时
+	EndTime       int64          // 结束时间，单位为 ts.TimeScale
+	Discontinuity bool           // 是否从不连续点开始
+	ConvertToTs   bool           // 是否需要转换为188字节的ts包输出
+	Filter        *ts.TsFilter   // 按PID过滤后输出，nil 表示不过滤
+	PkgSize       int            // 源文件包大小
+	Psi           *ts.ProgramPsi // 分片开始处插入的pat、pmt，nil 表示不插入
+	Key           []byte         // AES-128 密钥，nil 表示不加密
+	IV            []byte         // AES-128 初始向量
+	SampleAes     *ts.SampleAes  // SAMPLE-AES 加密参数，nil 表示不以 SAMPLE-AES 加密
 }
 
 // GetVideoList 计算视频列表
@@ -31,21 +42,40 @@ func GetVideoList(mediaFileIndex *ts.MediaFileIndex, targetDuration float64) []V
 	file.Size = 0
 	file.Duration = 0
 
-	// 开始分片ts文件路径处理
+	// 索引中存在关键帧时，新分片只能从关键帧开始，保证每个分片可独立解码
+	var keyFrameAligned bool = false
 	var i int
+	for i = 0; i < len(mediaFileIndex.TimesArray); i++ {
+		if mediaFileIndex.TimesArray[i].IsKeyFrame {
+			keyFrameAligned = true
+			break
+		}
+	}
+
+	// 开始分片ts文件路径处理
 	for i = 0; i < len(mediaFileIndex.TimesArray); i++ {
 
 		// 预计添加了这个时间片后的时长
-		sliceDuration := mediaFileIndex.TimesArray[i].MaxTime - mediaFileIndex.TimesArray[i].MinTime
-		nextDuration := file.Duration + float64(sliceDuration)
+		sliceDuration := float64(mediaFileIndex.TimesArray[i].MaxTime-mediaFileIndex.TimesArray[i].MinTime) / float64(ts.TimeScale)
+		nextDuration := file.Duration + sliceDuration
 
 		// 累加大小
 		file.Size = mediaFileIndex.TimesArray[i].StartOffset - file.StartOffset
 
-		// 添加后超过最大限制
-		if nextDuration > targetDuration {
+		// 第一个文件的开始时间
+		if i == 0 {
+			file.StartTime = mediaFileIndex.TimesArray[i].MinTime
+		}
+
+		// 添加后超过最大限制，且当前片可作为新文件的开始
+		// 不连续点总是作为新文件的开始，包含SCTE-35切点的片可作为新文件的开始时也开始新文件
+		canSplit := !keyFrameAligned || mediaFileIndex.TimesArray[i].IsKeyFrame
+		isDiscontinuity := mediaFileIndex.TimesArray[i].Discontinuity && i > 0
+		isSplice := i > 0 && hasSplicePoint(mediaFileIndex, i)
+		if (nextDuration > targetDuration && canSplit) || isDiscontinuity || (isSplice && canSplit) {
 
-			// 插入旧文件
+			// 插入旧文件，时长按结束时间计算，避免累加误差
+			file.Duration = getSegmentDuration(&file)
 			videoList = append(videoList, file)
 
 			// 文件数增加
@@ -55,26 +85,77 @@ func GetVideoList(mediaFileIndex *ts.MediaFileIndex, targetDuration float64) []V
 			file.Sequence = curSeq
 			file.Size = 0
 			file.StartOffset = mediaFileIndex.TimesArray[i].StartOffset
-			file.Duration = float64(sliceDuration)
+			file.Duration = sliceDuration
+			file.StartTime = mediaFileIndex.TimesArray[i].MinTime
+			file.Discontinuity = isDiscontinuity
 
 		} else {
 
 			// 累加时长
-			file.Duration += float64(sliceDuration)
+			file.Duration += sliceDuration
 		}
+		file.EndTime = mediaFileIndex.TimesArray[i].MaxTime
 	}
 
 	// 插入最后的一片
 	// 格式化时长
 	file.Size = mediaFileIndex.VideoSize - file.StartOffset
+	file.Duration = getSegmentDuration(&file)
 	videoList = append(videoList, file)
 
 	Log.Debug("GetVideoList, list size: " + fmt.Sprint(len(videoList)))
 	return videoList
 }
 
+// getSegmentDuration 分片时长(秒)，分片结束时间即下一分片第一帧的显示时间
+func getSegmentDuration(file *VideoInfo) float64 {
+	return float64(file.EndTime-file.StartTime) / float64(ts.TimeScale)
+}
+
+// formatDuration 格式化 EXTINF 中的时长，保留三位小数
+func formatDuration(duration float64) string {
+	return strconv.FormatFloat(duration, 'f', 3, 64)
+}
+
+// roundDuration 按 EXTINF 中输出的时长四舍五入为整数秒
+func roundDuration(duration float64) int {
+	return int(math.Floor(math.Round(duration*1000)/1000 + 0.5))
+}
+
+// getTargetDuration 计算 EXT-X-TARGETDURATION，为分片时长四舍五入后的最大值，至少为1
+// 关键帧间隔大于 m3u8.target_duration 时分片会超过配置的时长，不能直接使用配置值
+func getTargetDuration(videoList []VideoInfo) int {
+
+	var targetDuration int = 1
+	for _, file := range videoList {
+		if duration := roundDuration(file.Duration); duration > targetDuration {
+			targetDuration = duration
+		}
+	}
+	return targetDuration
+}
+
+// hasSplicePoint 第 index 个时间片是否为SCTE-35切点所在的分片开始位置
+// 离开节目的切点向前取整到所在的时间片，返回节目的切点向后取整到下一个时间片，保证广告内容完整
+func hasSplicePoint(mediaFileIndex *ts.MediaFileIndex, index int) bool {
+
+	slice := &mediaFileIndex.TimesArray[index]
+	previous := &mediaFileIndex.TimesArray[index-1]
+	for _, point := range mediaFileIndex.SplicePoints {
+		if point.Type == ts.SpliceTypeOut && point.Time >= slice.MinTime && point.Time < slice.MaxTime {
+			return true
+		}
+		if point.Type == ts.SpliceTypeIn && point.Time > previous.MinTime && point.Time <= slice.MinTime {
+			return true
+		}
+	}
+	return false
+}
+
 // GetVideoStream 获取视频流
-func GetVideoStream(videoFileURI string) (*VideoInfo, string, error) {
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetVideoStream(videoFileURI string, programNumber uint16) (*VideoInfo, string, error) {
 
 	Log.Debug("GetVideoStream, videoFileURI:" + videoFileURI)
 
@@ -105,16 +186,16 @@ func GetVideoStream(videoFileURI string) (*VideoInfo, string, error) {
 	// 组名
 	groupName := videoFileURINoSuffix[0:strings.Index(videoFileURINoSuffix, "/")]
 
-	// 视频相对路径
-	mediaFileURI := videoFileURINoSuffix[strings.Index(videoFileURINoSuffix, "/")+1 : len(videoFileURINoSuffix)]
-
 	// 真实媒体文件路径
-	realMediaLocalPath = path.MediaFileFolders[groupName].LocalPath + mediaFileURI[0:strings.LastIndex(mediaFileURI, "_")] + ".ts"
+	baseFileURINoSuffix := videoFileURINoSuffix[0:strings.LastIndex(videoFileURINoSuffix, "_")]
+	realMediaLocalPath, err = ts.GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return nil, "", err
+	}
 	Log.Debug("GetVideoStream, realMediaLocalPath:" + realMediaLocalPath)
 
 	// 获取ts索引对象
-	baseFileURINoSuffix := videoFileURINoSuffix[0:strings.LastIndex(videoFileURINoSuffix, "_")]
-	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix)
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
 	if err != nil {
 		return nil, "", err
 	}
@@ -133,6 +214,23 @@ func GetVideoStream(videoFileURI string) (*VideoInfo, string, error) {
 
 		mid := (right + left) / 2
 		if videoList[mid].Sequence == sequence {
+
+			// M2TS/204字节包按分组配置转换为188字节的ts包
+			videoList[mid].ConvertToTs = mediaFileIndex.PkgSize != ts.TsPkgSize && path.MediaFileFolders[groupName].PkgMode == path.PkgModeStrip
+			videoList[mid].PkgSize = mediaFileIndex.PkgSize
+			videoList[mid].Psi = mediaFileIndex.GetProgramPsi()
+
+			// 音频轨道独立输出时，视频分片只包含视频
+			if HasAlternateAudio(mediaFileIndex) {
+				setSegmentFilter(&videoList[mid], mediaFileIndex, getVideoPIDs(mediaFileIndex), -1)
+			}
+
+			// 分组启用加密时的密钥
+			err = setSegmentEncryption(&videoList[mid], mediaFileIndex, baseFileURINoSuffix)
+			if err != nil {
+				return nil, "", err
+			}
+
 			Log.Debug("Seek video info:" + fmt.Sprint(videoList[mid]))
 			return &videoList[mid], realMediaLocalPath, nil
 		} else if videoList[mid].Sequence < sequence {
@@ -0,0 +1,270 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	errors "../errors"
+	path "../path"
+	ts "../ts"
+)
+
+// audioGroupID 一级m3u8中音频轨道的组ID
+const audioGroupID string = "audio"
+
+// HasAlternateAudio 是否以独立的音频轨道输出，存在视频及多个音频轨道时视频分片只包含视频
+func HasAlternateAudio(mediaFileIndex *ts.MediaFileIndex) bool {
+	return mediaFileIndex.VideoPID != 0 && len(mediaFileIndex.AudioTracks) > 1
+}
+
+// GetAudioM3U8 音频m3u8文件获取，请求路径为 {group_name}/xxx_{track}.m3u8
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetAudioM3U8(m3u8FileURI string, host string, programNumber uint16) (string, error) {
+
+	// 无后缀的文件路径
+	var fileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".m3u8"), ".M3U8")
+
+	// 音频轨道序号
+	baseFileURINoSuffix, track, err := splitSequence(fileURINoSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		Log.Error(err.Error())
+		return "", err
+	}
+
+	if !HasAlternateAudio(mediaFileIndex) || track >= len(mediaFileIndex.AudioTracks) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetAudioM3U8 failed, audio track not exist!")
+		return "", err
+	}
+
+	return createAudioM3u8(mediaFileIndex, baseFileURINoSuffix, track, host, programNumber), nil
+}
+
+// GetAudioStream 音频分片获取，请求路径为 {group_name}/xxx_{track}_{sequence}.ts
+// 音频分片与视频分片一一对应，按音频pes边界对齐，只包含pat、pmt及该音频流，开始处插入pat、pmt
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetAudioStream(audioFileURI string, programNumber uint16) (*VideoInfo, string, error) {
+
+	Log.Debug("GetAudioStream, audioFileURI:" + audioFileURI)
+
+	if strings.Index(audioFileURI, "/") < 0 {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetAudioStream failed, can't get group_name from url!")
+		return nil, "", err
+	}
+
+	// 无后缀的文件路径
+	var fileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(audioFileURI, ".ts"), ".TS")
+
+	// 分片序号
+	trackFileURINoSuffix, sequence, err := splitSequence(fileURINoSuffix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 音频轨道序号
+	baseFileURINoSuffix, track, err := splitSequence(trackFileURINoSuffix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 组名
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+
+	// 真实媒体文件路径
+	realMediaLocalPath, err := ts.GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !HasAlternateAudio(mediaFileIndex) || track >= len(mediaFileIndex.AudioTracks) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetAudioStream failed, audio track not exist!")
+		return nil, "", err
+	}
+
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+	if sequence >= len(videoList) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetAudioStream failed, can't get audio file!")
+		return nil, "", err
+	}
+
+	audioInfo := videoList[sequence]
+	audioInfo.ConvertToTs = mediaFileIndex.PkgSize != ts.TsPkgSize && path.MediaFileFolders[groupName].PkgMode == path.PkgModeStrip
+	audioInfo.PkgSize = mediaFileIndex.PkgSize
+
+	// 最后一个分片之外的音频pes从下一个分片开始输出
+	audioPID := mediaFileIndex.AudioTracks[track].PID
+	setSegmentFilter(&audioInfo, mediaFileIndex, []uint16{0, mediaFileIndex.PmtPID, audioPID}, int(audioPID))
+
+	// 分组启用加密时的密钥，与视频分片相同
+	err = setSegmentEncryption(&audioInfo, mediaFileIndex, baseFileURINoSuffix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	Log.Debug("Seek audio info:" + fmt.Sprint(audioInfo))
+	return &audioInfo, realMediaLocalPath, nil
+}
+
+// createAudioMedia 创建一级m3u8中的音频轨道，第一个音频轨道为默认音频
+// #EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="{GROUP_ID}",NAME="{LANGUAGE}",LANGUAGE="{LANGUAGE}",DEFAULT=YES,AUTOSELECT=YES,URI="http://host:port/hls_audio/{group_name}/xxx_{track}.m3u8"
+func createAudioMedia(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, groupID string, host string, programNumber uint16) string {
+
+	var resultStr = ""
+	for i, track := range mediaFileIndex.AudioTracks {
+		resultStr += "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"" + groupID + "\",NAME=\"" + getAudioName(mediaFileIndex.AudioTracks, i) + "\""
+		if track.Language != "" {
+			resultStr += ",LANGUAGE=\"" + track.Language + "\""
+		}
+		if i == 0 {
+			resultStr += ",DEFAULT=YES,AUTOSELECT=YES"
+		} else {
+			resultStr += ",DEFAULT=NO,AUTOSELECT=YES"
+		}
+		if track.AudioType == ts.AudioTypeVisualImpairedCommentary {
+			resultStr += ",CHARACTERISTICS=\"public.accessibility.describes-video\""
+		}
+		resultStr += ",URI=\"" + getMediaURL(host, "/hls_audio/", baseFileURINoSuffix, "_"+strconv.Itoa(i)+".m3u8") + getProgramQuery(programNumber) + "\"\n"
+	}
+
+	return resultStr
+}
+
+// createAudioM3u8 创建音频m3u8，分片与视频分片一一对应
+// #EXTM3U
+// #EXT-X-VERSION:4
+// #EXT-X-TARGETDURATION:{MAX_DURATION}
+// #EXT-X-MEDIA-SEQUENCE:0
+// #EXT-X-PLAYLIST-TYPE:VOD
+// #EXT-X-KEY:METHOD=AES-128,URI="http://host:port/key/{group_name}/xxx_{keyIndex}.key",IV=0x{SEQUENCE}
+// #EXTINF:6.006,
+// http://host:port/audio/{group_name}/xxx_{track}_{sequence}.ts
+// #EXT-X-ENDLIST
+// fMP4 分片时版本为7，分片之前输出初始化分片
+// #EXT-X-MAP:URI="http://host:port/fmp4_audio/{group_name}/xxx_{track}_init.mp4"
+// http://host:port/fmp4_audio/{group_name}/xxx_{track}_{sequence}.m4s
+// SAMPLE-AES 加密时版本为5
+func createAudioM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, track int, host string, programNumber uint16) string {
+
+	Log.Debug(">>> GetAudioM3u8 Start: " + baseFileURINoSuffix + ", track: " + fmt.Sprint(track))
+
+	// m3u8 文件内容
+	var resultStr = ""
+
+	var isFmp4 bool = UseFmp4(mediaFileIndex)
+	trackStr := strconv.Itoa(track)
+
+	// 获取文件列表
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+
+	resultStr += "#EXTM3U\n"
+	resultStr += createVersionTag(mediaFileIndex, baseFileURINoSuffix)
+	resultStr += "#EXT-X-TARGETDURATION:" + strconv.Itoa(getTargetDuration(videoList)) + "\n"
+	resultStr += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	resultStr += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+	if isFmp4 {
+		resultStr += "#EXT-X-MAP:URI=\"" + getMediaURL(host, "/fmp4_audio/", baseFileURINoSuffix, "_"+trackStr+"_init.mp4") + getProgramQuery(programNumber) + "\"\n"
+	}
+
+	var i int
+	for i = 0; i < len(videoList); i++ {
+
+		// #EXT-X-DISCONTINUITY
+		if videoList[i].Discontinuity {
+			resultStr += "#EXT-X-DISCONTINUITY\n"
+		}
+
+		// #EXT-X-KEY
+		resultStr += createKeyTag(mediaFileIndex, baseFileURINoSuffix, videoList[i].Sequence, host, programNumber)
+
+		// #EXTINF:6.006,
+		resultStr += "#EXTINF:" + formatDuration(videoList[i].Duration) + ",\n"
+
+		sequenceStr := strconv.FormatUint(uint64(videoList[i].Sequence), 10)
+		if isFmp4 {
+			resultStr += getMediaURL(host, "/fmp4_audio/", baseFileURINoSuffix, "_"+trackStr+"_"+sequenceStr+".m4s") + getProgramQuery(programNumber) + "\n"
+		} else {
+			resultStr += getMediaURL(host, "/audio/", baseFileURINoSuffix, "_"+trackStr+"_"+sequenceStr+".ts") + getProgramQuery(programNumber) + "\n"
+		}
+	}
+
+	// #EXT-X-ENDLIST
+	resultStr += "#EXT-X-ENDLIST"
+
+	Log.Debug("<<< GetAudioM3u8 End")
+	return resultStr
+}
+
+// getVideoPIDs 视频分片需要输出的PID，pat、pmt及视频流
+func getVideoPIDs(mediaFileIndex *ts.MediaFileIndex) []uint16 {
+	return []uint16{0, mediaFileIndex.PmtPID, mediaFileIndex.VideoPID}
+}
+
+// setSegmentFilter 设置分片按PID过滤输出，分片开始处插入只包含保留的流的pmt
+// 插入的pmt可用时源文件中的pmt不再输出，避免与插入的pmt不一致
+// PCR_PID 保持不变，PCR 所在的流(音频流、视频流或单独的PID)未保留时，只输出其中带PCR的适配域
+//
+//	PIDs 保留的PID，包含pat、pmt
+//	alignPID 按pes边界对齐的流PID，-1 表示不对齐
+func setSegmentFilter(videoInfo *VideoInfo, mediaFileIndex *ts.MediaFileIndex, PIDs []uint16, alignPID int) {
+
+	videoInfo.Psi = mediaFileIndex.GetFilteredProgramPsi(PIDs)
+
+	// 0x1FFF 表示节目不使用PCR
+	var pcrPID int = -1
+	if mediaFileIndex.PcrPID != 0x1FFF && !containsPID(PIDs, mediaFileIndex.PcrPID) {
+		pcrPID = int(mediaFileIndex.PcrPID)
+	}
+
+	filterPIDs := PIDs
+	if videoInfo.Psi != nil {
+		filterPIDs = make([]uint16, 0, len(PIDs))
+		for _, PID := range PIDs {
+			if PID != mediaFileIndex.PmtPID {
+				filterPIDs = append(filterPIDs, PID)
+			}
+		}
+	}
+
+	videoInfo.Filter = &ts.TsFilter{PIDs: filterPIDs, Size: videoInfo.Size, AlignPID: alignPID, PcrPID: pcrPID}
+}
+
+// containsPID PID列表中是否包含指定PID
+func containsPID(PIDs []uint16, PID uint16) bool {
+	for _, p := range PIDs {
+		if p == PID {
+			return true
+		}
+	}
+	return false
+}
+
+// getAudioName 音频轨道名称，语言相同的轨道追加序号，保证组内名称唯一
+func getAudioName(tracks []ts.AudioTrack, index int) string {
+
+	name := tracks[index].Language
+	if name == "" {
+		name = "Audio"
+	}
+
+	for i, track := range tracks {
+		if i != index && track.Language == tracks[index].Language {
+			return name + " " + strconv.Itoa(index+1)
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,34 @@
+package hls
+
+import (
+	ts "../ts"
+)
+
+// ByteRange 是否以字节范围(EXT-X-BYTERANGE)引用源文件，分片不再经过 /video/ 输出
+var ByteRange bool
+
+// UseByteRange 二级m3u8是否以字节范围引用源文件
+// 分片需要转换、过滤、插入pat及pmt之外的处理，或选择了非默认节目时，仍使用 /video/ 输出分片
+// 字节范围引用的分片不插入pat、pmt，以 EXT-X-MAP 引用只包含pat、pmt的初始化分片
+//
+//	programNumber 节目号，0 表示第一个节目
+func UseByteRange(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, programNumber uint16) bool {
+
+	if !ByteRange || programNumber != 0 || UseFmp4(mediaFileIndex) {
+		return false
+	}
+
+	// 音频轨道独立输出时视频分片需要过滤
+	if HasAlternateAudio(mediaFileIndex) {
+		return false
+	}
+
+	// 加密需要在输出时进行
+	if IsEncrypted(baseFileURINoSuffix) {
+		return false
+	}
+
+	// 播放器只能解析188字节的ts包，M2TS/204字节包即使分组的 packet_mode 为 passthrough 也需要转换
+	// 索引中不包含pat、pmt时无法生成初始化分片
+	return mediaFileIndex.PkgSize == ts.TsPkgSize && mediaFileIndex.GetProgramPsi() != nil
+}
@@ -0,0 +1,175 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+
+	ts "../ts"
+)
+
+// GetCodecs 计算 EXT-X-STREAM-INF 中的 CODECS，无法识别时返回空串
+func GetCodecs(mediaFileIndex *ts.MediaFileIndex) string {
+
+	var codecs []string = make([]string, 0)
+
+	// 存在视频流，但无法识别编码时不输出 CODECS，避免被误认为纯音频
+	if mediaFileIndex.VideoCodec.StreamType != 0 {
+		videoCodec := getVideoCodec(&mediaFileIndex.VideoCodec)
+		if videoCodec == "" {
+			return ""
+		}
+
+		// fMP4 分片的参数集位于样本中，样本描述为 avc3
+		if UseFmp4(mediaFileIndex) && mediaFileIndex.VideoCodec.StreamType == ts.StreamTypeH264 {
+			videoCodec = "avc3" + strings.TrimPrefix(videoCodec, "avc1")
+		}
+		codecs = append(codecs, videoCodec)
+	}
+
+	if audioCodec := getAudioCodec(&mediaFileIndex.AudioCodec); audioCodec != "" {
+		codecs = append(codecs, audioCodec)
+	}
+
+	// 音频轨道独立输出时，包含所有音频轨道的编码，与默认音频流类型相同的轨道使用相同的编码
+	if HasAlternateAudio(mediaFileIndex) {
+		for _, track := range mediaFileIndex.AudioTracks {
+			if track.StreamType == mediaFileIndex.AudioCodec.StreamType {
+				continue
+			}
+			audioCodec := getAudioCodec(&ts.AudioCodecInfo{StreamType: track.StreamType})
+			if audioCodec != "" && !containsCodec(codecs, audioCodec) {
+				codecs = append(codecs, audioCodec)
+			}
+		}
+	}
+
+	return strings.Join(codecs, ",")
+}
+
+// containsCodec 编码列表中是否已包含指定编码
+func containsCodec(codecs []string, codec string) bool {
+	for _, c := range codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// getAudioCodec 计算音频编码字符串
+func getAudioCodec(info *ts.AudioCodecInfo) string {
+
+	switch info.StreamType {
+	case ts.StreamTypeAAC:
+
+		// 未解析到 ADTS 帧头时按 AAC-LC 处理
+		if info.ObjectType == 0 {
+			return "mp4a.40.2"
+		}
+		return "mp4a.40." + fmt.Sprint(info.ObjectType)
+	case ts.StreamTypeMP3, ts.StreamTypeMP2:
+
+		// Layer I、II、III 分别为 mp4a.40.32、mp4a.40.33、mp4a.40.34，未解析到帧头时不输出
+		if info.Layer == 0 {
+			return ""
+		}
+		return "mp4a.40." + fmt.Sprint(31+info.Layer)
+	case ts.StreamTypeAC3:
+		return "ac-3"
+	}
+
+	return ""
+}
+
+// getVideoCodec 计算视频编码字符串
+func getVideoCodec(info *ts.VideoCodecInfo) string {
+
+	switch info.StreamType {
+	case ts.StreamTypeH264:
+		return getAvcCodec(info)
+	case ts.StreamTypeHEVC:
+		return getHevcCodec(info)
+	}
+
+	return ""
+}
+
+// getAvcCodec 计算H.264编码字符串(RFC 6381)，profile_idc、constraint_set 标志及 level_idc 的十六进制
+// 例如：avc1.64001f
+func getAvcCodec(info *ts.VideoCodecInfo) string {
+
+	// 未解析到序列参数集
+	if info.ProfileIDC == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("avc1.%02x%02x%02x", info.ProfileIDC, uint8(info.ConstraintFlags), info.LevelIDC)
+}
+
+// GetResolution 计算 EXT-X-STREAM-INF 中的 RESOLUTION，未知时返回空串
+func GetResolution(mediaFileIndex *ts.MediaFileIndex) string {
+
+	if mediaFileIndex.VideoCodec.Width == 0 || mediaFileIndex.VideoCodec.Height == 0 {
+		return ""
+	}
+
+	return fmt.Sprint(mediaFileIndex.VideoCodec.Width) + "x" + fmt.Sprint(mediaFileIndex.VideoCodec.Height)
+}
+
+// GetFrameRate 计算 EXT-X-STREAM-INF 中的 FRAME-RATE，保留三位小数，未知时返回空串
+func GetFrameRate(mediaFileIndex *ts.MediaFileIndex) string {
+
+	if mediaFileIndex.VideoCodec.FrameRate <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%.3f", mediaFileIndex.VideoCodec.FrameRate)
+}
+
+// getHevcCodec 计算HEVC编码字符串(ISO/IEC 14496-15 附录E)
+// 例如：hvc1.1.6.L93.B0
+func getHevcCodec(info *ts.VideoCodecInfo) string {
+
+	// 未解析到序列参数集，不带参数的 hvc1/hev1 不是合法的编码字符串
+	if info.ProfileIDC == 0 {
+		return ""
+	}
+
+	var codec string = HevcCodecTag + "."
+
+	// general_profile_space 为 1,2,3 时对应 A,B,C
+	if info.ProfileSpace > 0 {
+		codec += string(rune('A' + info.ProfileSpace - 1))
+	}
+	codec += fmt.Sprint(info.ProfileIDC)
+
+	// general_profile_compatibility_flags 按位反序后的十六进制
+	var reversedFlags uint32 = 0
+	var i uint
+	for i = 0; i < 32; i++ {
+		reversedFlags |= (info.CompatibilityFlags >> i & 0x1) << (31 - i)
+	}
+	codec += "." + fmt.Sprintf("%X", reversedFlags)
+
+	// general_tier_flag 为 0 时 L，为 1 时 H
+	if info.TierFlag == 0 {
+		codec += ".L"
+	} else {
+		codec += ".H"
+	}
+	codec += fmt.Sprint(info.LevelIDC)
+
+	// 约束标志按字节输出，省略末尾为0的字节
+	var constraintBytes []string = make([]string, 0)
+	for i = 0; i < 6; i++ {
+		constraintBytes = append(constraintBytes, fmt.Sprintf("%X", uint8(info.ConstraintFlags>>(40-8*i))))
+	}
+	for len(constraintBytes) > 0 && constraintBytes[len(constraintBytes)-1] == "0" {
+		constraintBytes = constraintBytes[0 : len(constraintBytes)-1]
+	}
+	if len(constraintBytes) > 0 {
+		codec += "." + strings.Join(constraintBytes, ".")
+	}
+
+	return codec
+}
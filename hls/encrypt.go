@@ -0,0 +1,289 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	errors "../errors"
+	path "../path"
+	ts "../ts"
+)
+
+// aesKeySize AES-128 密钥长度
+const aesKeySize int = 16
+
+// m3u8 中的加密方式
+const (
+	encryptMethodAes128    string = "AES-128"
+	encryptMethodSampleAes string = "SAMPLE-AES"
+)
+
+// keyMutex 密钥文件创建锁，保证同一密钥只生成一次
+var keyMutex sync.Mutex
+
+// IsEncrypted 分组是否启用分片加密
+func IsEncrypted(baseFileURINoSuffix string) bool {
+
+	if strings.Index(baseFileURINoSuffix, "/") < 0 {
+		return false
+	}
+
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+	encryption := path.MediaFileFolders[groupName].Encryption
+	return encryption == path.EncryptionAes128 || encryption == path.EncryptionSampleAes
+}
+
+// getEncryptMethod 媒体文件的加密方式，未加密时为空
+// SAMPLE-AES 只支持ts分片中的 H.264 视频及 AAC 音频，其他情况使用 AES-128 整片加密
+func getEncryptMethod(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string) string {
+
+	if !IsEncrypted(baseFileURINoSuffix) {
+		return ""
+	}
+
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+	if path.MediaFileFolders[groupName].Encryption == path.EncryptionSampleAes &&
+		!UseFmp4(mediaFileIndex) && isH264AacMedia(mediaFileIndex) && len(mediaFileIndex.PmtSection) > 0 {
+		return encryptMethodSampleAes
+	}
+	return encryptMethodAes128
+}
+
+// GetKey 密钥获取，请求路径为 {group_name}/xxx_{keyIndex}.key
+// 密钥在第一次请求时生成并保存到密钥目录，同一媒体文件的所有节目共用密钥
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetKey(keyFileURI string, programNumber uint16) ([]byte, error) {
+
+	Log.Debug("GetKey, keyFileURI:" + keyFileURI)
+
+	// 无后缀的文件路径
+	var fileURINoSuffix = strings.TrimSuffix(keyFileURI, ".key")
+
+	// 密钥序号
+	baseFileURINoSuffix, keyIndex, err := splitSequence(fileURINoSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncrypted(baseFileURINoSuffix) || strings.Contains(baseFileURINoSuffix, "..") {
+		err := errors.NewError(errors.ErrorCodeGetKeyFailed, "GetKey failed, encryption is not enabled!")
+		return nil, err
+	}
+
+	// 获取ts索引对象，只为存在的分片生成密钥
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+	if keyIndex > getKeyIndex(baseFileURINoSuffix, len(videoList)-1) {
+		err := errors.NewError(errors.ErrorCodeGetKeyFailed, "GetKey failed, key not exist!")
+		return nil, err
+	}
+
+	return getSegmentKey(baseFileURINoSuffix, keyIndex)
+}
+
+// getKeyIndex 分片使用的密钥序号，每 key_rotation 个分片更换一次密钥
+func getKeyIndex(baseFileURINoSuffix string, sequence int) int {
+
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+	keyRotation := path.MediaFileFolders[groupName].KeyRotation
+	if keyRotation <= 0 {
+		return 0
+	}
+	return sequence / keyRotation
+}
+
+// getSegmentIV 分片的初始向量，为128bit的分片序号，与未指定IV时播放器使用的媒体序号一致
+func getSegmentIV(sequence int) []byte {
+
+	var iv []byte = make([]byte, aes.BlockSize)
+	var i int
+	for i = 0; i < 8; i++ {
+		iv[aes.BlockSize-1-i] = byte(uint64(sequence) >> (8 * uint(i)))
+	}
+	return iv
+}
+
+// getSegmentEncryption 分片的密钥及初始向量，分组未启用加密时返回 nil
+func getSegmentEncryption(baseFileURINoSuffix string, sequence int) ([]byte, []byte, error) {
+
+	if !IsEncrypted(baseFileURINoSuffix) {
+		return nil, nil, nil
+	}
+
+	key, err := getSegmentKey(baseFileURINoSuffix, getKeyIndex(baseFileURINoSuffix, sequence))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, getSegmentIV(sequence), nil
+}
+
+// setSegmentEncryption 设置ts分片的加密信息，SAMPLE-AES 分片需要转换为188字节的ts包后加密
+func setSegmentEncryption(videoInfo *VideoInfo, mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string) error {
+
+	key, iv, err := getSegmentEncryption(baseFileURINoSuffix, videoInfo.Sequence)
+	if err != nil || key == nil {
+		return err
+	}
+
+	if getEncryptMethod(mediaFileIndex, baseFileURINoSuffix) != encryptMethodSampleAes {
+		videoInfo.Key = key
+		videoInfo.IV = iv
+		return nil
+	}
+
+	// 按PID过滤输出的分片使用插入的pmt
+	pmtSection := mediaFileIndex.PmtSection
+	if videoInfo.Psi != nil {
+		pmtSection = videoInfo.Psi.PmtSection
+	}
+
+	sampleAes := &ts.SampleAes{
+		Key:        key,
+		IV:         iv,
+		PmtPID:     mediaFileIndex.PmtPID,
+		PmtSection: pmtSection,
+		VideoPID:   mediaFileIndex.VideoPID,
+		AudioSetup: mediaFileIndex.AudioCodec.AudioSpecificConfig(),
+	}
+	for _, track := range mediaFileIndex.AudioTracks {
+		sampleAes.AudioPIDs = append(sampleAes.AudioPIDs, track.PID)
+	}
+
+	videoInfo.SampleAes = sampleAes
+	videoInfo.ConvertToTs = mediaFileIndex.PkgSize != ts.TsPkgSize
+	return nil
+}
+
+// getSegmentKey 读取密钥文件，不存在时随机生成
+// 密钥文件路径为 {key_file_folder}/{group_name}/xxx_{keyIndex}.key
+func getSegmentKey(baseFileURINoSuffix string, keyIndex int) ([]byte, error) {
+
+	keyFilePath := path.KeyFileFolder + baseFileURINoSuffix + "_" + strconv.Itoa(keyIndex) + ".key"
+
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+
+	key, err := os.ReadFile(keyFilePath)
+	if err == nil && len(key) == aesKeySize {
+		return key, nil
+	}
+
+	key = make([]byte, aesKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetKeyFailed, "Create key failed, "+err.Error())
+	}
+
+	// 先写入临时文件再重命名，避免读取到不完整的密钥
+	if err := os.MkdirAll(keyFilePath[0:strings.LastIndex(keyFilePath, "/")], 0700); err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetKeyFailed, "Create key folder failed, "+err.Error())
+	}
+	if err := os.WriteFile(keyFilePath+".tmp", key, 0600); err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetKeyFailed, "Write key file failed, "+err.Error())
+	}
+	if err := os.Rename(keyFilePath+".tmp", keyFilePath); err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetKeyFailed, "Write key file failed, "+err.Error())
+	}
+
+	Log.Info("Create key file: " + keyFilePath)
+	return key, nil
+}
+
+// createKeyTag 创建分片的加密信息，每个分片使用各自的IV，因此每个分片之前都输出
+// #EXT-X-KEY:METHOD=AES-128,URI="http://host:port/key/{group_name}/xxx_{keyIndex}.key",IV=0x{SEQUENCE}
+// #EXT-X-KEY:METHOD=SAMPLE-AES,URI="http://host:port/key/{group_name}/xxx_{keyIndex}.key",KEYFORMAT="identity",KEYFORMATVERSIONS="1",IV=0x{SEQUENCE}
+func createKeyTag(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, sequence int, host string, programNumber uint16) string {
+
+	method := getEncryptMethod(mediaFileIndex, baseFileURINoSuffix)
+	if method == "" {
+		return ""
+	}
+
+	keyIndexStr := strconv.Itoa(getKeyIndex(baseFileURINoSuffix, sequence))
+	var resultStr = "#EXT-X-KEY:METHOD=" + method + ",URI=\"" + getMediaURL(host, "/key/", baseFileURINoSuffix, "_"+keyIndexStr+".key") + getProgramQuery(programNumber) + "\""
+	if method == encryptMethodSampleAes {
+		resultStr += ",KEYFORMAT=\"identity\",KEYFORMATVERSIONS=\"1\""
+	}
+	return resultStr + ",IV=0x" + fmt.Sprintf("%032X", getSegmentIV(sequence)) + "\n"
+}
+
+// EncryptedSize 加密后的大小，PKCS7 填充总是追加 1~16 个字节
+func EncryptedSize(size uint64) uint64 {
+	return (size/aes.BlockSize + 1) * aes.BlockSize
+}
+
+// EncryptSegment AES-128 CBC 加密整个分片，使用 PKCS7 填充
+func EncryptSegment(data []byte, key []byte, iv []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	encrypter, err := NewSegmentEncrypter(&buf, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	encrypter.Write(data)
+	encrypter.Close()
+
+	return buf.Bytes(), nil
+}
+
+// SegmentEncrypter 分片流式加密，输出时按块加密，Close 时写入填充
+type SegmentEncrypter struct {
+	writer io.Writer
+	mode   cipher.BlockMode
+	remain []byte // 不足一个块的剩余数据
+}
+
+// NewSegmentEncrypter 创建分片流式加密
+func NewSegmentEncrypter(writer io.Writer, key []byte, iv []byte) (*SegmentEncrypter, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetStreamFailed, "Create encrypter failed, "+err.Error())
+	}
+
+	return &SegmentEncrypter{writer: writer, mode: cipher.NewCBCEncrypter(block, iv)}, nil
+}
+
+// Write 加密完整的块并输出，剩余数据留到下一次写入
+func (encrypter *SegmentEncrypter) Write(data []byte) (int, error) {
+
+	var buf []byte = append(encrypter.remain, data...)
+	var blockSize int = len(buf) / aes.BlockSize * aes.BlockSize
+
+	encrypter.mode.CryptBlocks(buf[0:blockSize], buf[0:blockSize])
+	encrypter.remain = append([]byte(nil), buf[blockSize:]...)
+
+	if _, err := encrypter.writer.Write(buf[0:blockSize]); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close 填充最后一个块并输出
+func (encrypter *SegmentEncrypter) Close() error {
+
+	var padding int = aes.BlockSize - len(encrypter.remain)
+	var buf []byte = encrypter.remain
+	var i int
+	for i = 0; i < padding; i++ {
+		buf = append(buf, byte(padding))
+	}
+
+	encrypter.mode.CryptBlocks(buf, buf)
+	encrypter.remain = nil
+
+	_, err := encrypter.writer.Write(buf)
+	return err
+}
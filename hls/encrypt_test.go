@@ -0,0 +1,84 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// TestSegmentEncrypterPadding 校验 PKCS7 填充：输出长度与 EncryptedSize 一致，分段写入与一次写入结果相同，解密后可还原明文
+func TestSegmentEncrypterPadding(t *testing.T) {
+
+	var key []byte = []byte("0123456789abcdef")
+	var iv []byte = getSegmentIV(7)
+
+	var cases = []struct {
+		name   string
+		size   int
+		chunks []int // 每次写入的长度，最后一次写入剩余数据
+	}{
+		{"empty", 0, nil},
+		{"one byte", 1, nil},
+		{"block minus one", 15, []int{7}},
+		{"one block", 16, []int{16}},
+		{"block plus one", 17, []int{1, 15}},
+		{"ts packets", 188 * 7, []int{188, 5, 188 * 3}},
+	}
+
+	for _, c := range cases {
+		var plain []byte = make([]byte, c.size)
+		var i int
+		for i = range plain {
+			plain[i] = byte(i * 31)
+		}
+		var origin []byte = append([]byte(nil), plain...)
+
+		var buf bytes.Buffer
+		encrypter, err := NewSegmentEncrypter(&buf, key, iv)
+		if err != nil {
+			t.Fatalf("%s: NewSegmentEncrypter: %v", c.name, err)
+		}
+		var pos int = 0
+		for _, n := range c.chunks {
+			if _, err := encrypter.Write(plain[pos : pos+n]); err != nil {
+				t.Fatalf("%s: Write: %v", c.name, err)
+			}
+			pos += n
+		}
+		encrypter.Write(plain[pos:])
+		if err := encrypter.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", c.name, err)
+		}
+		encrypted := buf.Bytes()
+
+		if !bytes.Equal(plain, origin) {
+			t.Errorf("%s: Write modified the input", c.name)
+		}
+		if uint64(len(encrypted)) != EncryptedSize(uint64(c.size)) {
+			t.Errorf("%s: encrypted length = %d, EncryptedSize = %d", c.name, len(encrypted), EncryptedSize(uint64(c.size)))
+			continue
+		}
+
+		whole, err := EncryptSegment(plain, key, iv)
+		if err != nil {
+			t.Fatalf("%s: EncryptSegment: %v", c.name, err)
+		}
+		if !bytes.Equal(encrypted, whole) {
+			t.Errorf("%s: chunked output differs from EncryptSegment", c.name)
+		}
+
+		// 解密后为明文加 PKCS7 填充，填充长度 1~16
+		block, _ := aes.NewCipher(key)
+		var decrypted []byte = make([]byte, len(encrypted))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+
+		padding := aes.BlockSize - c.size%aes.BlockSize
+		if !bytes.Equal(decrypted[:c.size], plain) {
+			t.Errorf("%s: decrypted data differs from plaintext", c.name)
+		}
+		if !bytes.Equal(decrypted[c.size:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			t.Errorf("%s: padding = % X, want %d bytes of 0x%02X", c.name, decrypted[c.size:], padding, padding)
+		}
+	}
+}
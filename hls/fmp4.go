@@ -0,0 +1,652 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	errors "../errors"
+	ts "../ts"
+)
+
+// 媒体分片格式
+const (
+	SegmentFormatTs   string = "ts"   // MPEG-TS 分片（默认）
+	SegmentFormatFmp4 string = "fmp4" // fMP4(CMAF) 分片
+)
+
+// SegmentFormat 媒体分片格式
+var SegmentFormat string
+
+// fmp4TimeOffset 媒体开始时间在 fMP4 时间轴上的位置，保证B帧及先于视频开始的音频解码时间不为负
+const fmp4TimeOffset int64 = 10 * ts.TimeScale
+
+// fmp4ExtraSize 超出分片范围后最多继续读取的字节数，用于读取跨越分片结尾的pes
+const fmp4ExtraSize uint64 = 4 * 1024 * 1024
+
+// aacFrameSamples 每个 AAC 帧的采样数
+const aacFrameSamples uint32 = 1024
+
+// fMP4 样本标志，sample_depends_on(2bit) 及 sample_is_non_sync_sample(1bit)
+const (
+	sampleFlagsKeyFrame    uint32 = 0x02000000 // 不依赖其他帧
+	sampleFlagsNonKeyFrame uint32 = 0x01010000 // 依赖其他帧，非同步帧
+)
+
+// fmp4Track fMP4 中的轨道
+type fmp4Track struct {
+	TrackID    uint32            // 轨道ID，从1开始
+	PID        uint16            // 对应的ts流PID
+	IsVideo    bool              // 是否为视频轨道
+	TimeScale  uint32            // 时间单位，视频为 90000，音频为采样率
+	Width      uint16            // 图像宽度
+	Height     uint16            // 图像高度
+	Sps        []byte            // H.264 序列参数集
+	Pps        []byte            // H.264 图像参数集
+	AudioCodec ts.AudioCodecInfo // 音频编码信息
+	Samples    []fmp4Sample      // 样本
+}
+
+// fmp4Sample fMP4 中的样本
+type fmp4Sample struct {
+	DecodeTime        int64  // 解码时间，单位为轨道时间单位
+	CompositionOffset int32  // 显示时间与解码时间之差
+	Duration          uint32 // 时长
+	IsKeyFrame        bool   // 是否为关键帧
+	Data              []byte // 样本数据
+}
+
+// UseFmp4 是否以 fMP4 分片输出，只支持 H.264 视频及 AAC 音频，其他编码仍使用ts分片
+func UseFmp4(mediaFileIndex *ts.MediaFileIndex) bool {
+
+	if SegmentFormat != SegmentFormatFmp4 {
+		return false
+	}
+	return isH264AacMedia(mediaFileIndex)
+}
+
+// isH264AacMedia 是否只包含 H.264 视频及 AAC 音频
+func isH264AacMedia(mediaFileIndex *ts.MediaFileIndex) bool {
+
+	if mediaFileIndex.VideoPID != 0 && mediaFileIndex.VideoCodec.StreamType != ts.StreamTypeH264 {
+		return false
+	}
+
+	for _, track := range mediaFileIndex.AudioTracks {
+		if track.StreamType != ts.StreamTypeAAC {
+			return false
+		}
+	}
+
+	return mediaFileIndex.VideoPID != 0 || len(mediaFileIndex.AudioTracks) > 0
+}
+
+// GetFmp4Segment fMP4 分片获取，请求路径为 {group_name}/xxx_init.mp4 或 {group_name}/xxx_{sequence}.m4s
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetFmp4Segment(fileURI string, programNumber uint16) ([]byte, error) {
+	return getFmp4Segment(fileURI, programNumber, false)
+}
+
+// GetFmp4AudioSegment fMP4 音频分片获取，请求路径为 {group_name}/xxx_{track}_init.mp4 或 {group_name}/xxx_{track}_{sequence}.m4s
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetFmp4AudioSegment(fileURI string, programNumber uint16) ([]byte, error) {
+	return getFmp4Segment(fileURI, programNumber, true)
+}
+
+// getFmp4Segment 将分片对应的ts字节范围转换为 fMP4 分片，初始化分片由第一个分片中的参数集及音频帧头生成
+//
+//	isAudio 是否为独立输出的音频轨道
+func getFmp4Segment(fileURI string, programNumber uint16, isAudio bool) ([]byte, error) {
+
+	Log.Debug("GetFmp4Segment, fileURI:" + fileURI)
+
+	// 无后缀的文件路径及分片序号
+	var isInit bool = strings.HasSuffix(fileURI, "_init.mp4")
+	var fileURINoSuffix string
+	var sequence int
+	var err error
+	if isInit {
+		fileURINoSuffix = strings.TrimSuffix(fileURI, "_init.mp4")
+	} else {
+		fileURINoSuffix, sequence, err = splitSequence(strings.TrimSuffix(fileURI, ".m4s"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 音频轨道序号
+	var baseFileURINoSuffix string = fileURINoSuffix
+	var track int = -1
+	if isAudio {
+		baseFileURINoSuffix, track, err = splitSequence(fileURINoSuffix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.Index(baseFileURINoSuffix, "/") < 0 {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetFmp4Segment failed, can't get group_name from url!")
+		return nil, err
+	}
+
+	// 真实媒体文件路径
+	realMediaLocalPath, err := ts.GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if !UseFmp4(mediaFileIndex) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetFmp4Segment failed, fMP4 is not available for this media!")
+		return nil, err
+	}
+
+	if isAudio && (!HasAlternateAudio(mediaFileIndex) || track >= len(mediaFileIndex.AudioTracks)) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetFmp4Segment failed, audio track not exist!")
+		return nil, err
+	}
+
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+	if sequence >= len(videoList) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetFmp4Segment failed, can't get segment file!")
+		return nil, err
+	}
+
+	// 解封装分片，初始化分片使用第一个分片
+	tracks := getFmp4Tracks(mediaFileIndex, track)
+	err = readFmp4Samples(mediaFileIndex, realMediaLocalPath, &videoList[sequence], tracks)
+	if err != nil {
+		return nil, err
+	}
+
+	if isInit {
+		return createFmp4Init(tracks)
+	}
+
+	// 初始化分片位于 EXT-X-KEY 之前，不加密
+	fragment := createFmp4Fragment(sequence, tracks)
+	key, iv, err := getSegmentEncryption(baseFileURINoSuffix, sequence)
+	if err != nil || key == nil {
+		return fragment, err
+	}
+	return EncryptSegment(fragment, key, iv)
+}
+
+// getFmp4Tracks 分片包含的轨道
+// 音频轨道独立输出时，主分片只包含视频，音频分片只包含该音频轨道
+//
+//	audioTrack 独立输出的音频轨道序号，-1 表示主分片
+func getFmp4Tracks(mediaFileIndex *ts.MediaFileIndex, audioTrack int) []*fmp4Track {
+
+	var tracks []*fmp4Track
+
+	if audioTrack < 0 && mediaFileIndex.VideoPID != 0 {
+		tracks = append(tracks, &fmp4Track{
+			PID:       mediaFileIndex.VideoPID,
+			IsVideo:   true,
+			TimeScale: uint32(ts.TimeScale),
+			Width:     mediaFileIndex.VideoCodec.Width,
+			Height:    mediaFileIndex.VideoCodec.Height,
+		})
+	}
+
+	if audioTrack >= 0 {
+		tracks = append(tracks, &fmp4Track{PID: mediaFileIndex.AudioTracks[audioTrack].PID})
+	} else if !HasAlternateAudio(mediaFileIndex) && len(mediaFileIndex.AudioTracks) > 0 {
+		tracks = append(tracks, &fmp4Track{PID: mediaFileIndex.AudioTracks[0].PID})
+	}
+
+	for i, track := range tracks {
+		track.TrackID = uint32(i + 1)
+	}
+
+	return tracks
+}
+
+// stopReader 停止标志置位后返回 io.EOF 的读取器
+type stopReader struct {
+	reader io.Reader
+	stop   bool
+}
+
+// Read 读取数据
+func (r *stopReader) Read(p []byte) (int, error) {
+	if r.stop {
+		return 0, io.EOF
+	}
+	return r.reader.Read(p)
+}
+
+// readFmp4Samples 解封装分片对应的ts字节范围，按轨道收集样本
+// 分片开始处插入pat、pmt，使解封装器可以识别各个流；只收集起始包位于分片范围内的pes，
+// 继续读取至各轨道在分片之后的第一个pes起始包，保证跨越分片结尾的pes完整
+func readFmp4Samples(mediaFileIndex *ts.MediaFileIndex, realMediaLocalPath string, videoInfo *VideoInfo, tracks []*fmp4Track) error {
+
+	file, err := os.Open(realMediaLocalPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileStat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 分片第一个包，M2TS 插入的包使用其时间戳
+	var firstPkg []byte = make([]byte, mediaFileIndex.PkgSize)
+	n, _ := file.ReadAt(firstPkg, int64(videoInfo.StartOffset))
+	psiPackets := ts.CreatePsiPackets(mediaFileIndex.GetProgramPsi(), firstPkg[0:n], mediaFileIndex.PkgSize)
+
+	var readSize int64 = fileStat.Size() - int64(videoInfo.StartOffset)
+	if readSize > int64(videoInfo.Size+fmp4ExtraSize) {
+		readSize = int64(videoInfo.Size + fmp4ExtraSize)
+	}
+
+	// 分片范围在输入数据中的位置
+	var startOffset uint64 = uint64(len(psiPackets))
+	var endOffset uint64 = startOffset + videoInfo.Size
+
+	var pesMap map[uint16][]*ts.Pes = make(map[uint16][]*ts.Pes)
+	var finished map[uint16]bool = make(map[uint16]bool)
+	for _, track := range tracks {
+		pesMap[track.PID] = make([]*ts.Pes, 0)
+	}
+
+	reader := &stopReader{reader: io.NewSectionReader(file, int64(videoInfo.StartOffset), readSize)}
+	d := ts.NewDemuxer(io.MultiReader(bytes.NewReader(psiPackets), reader))
+	d.SelectProgram(mediaFileIndex.ProgramNumber)
+
+	// 所有轨道在分片之后都出现了pes起始包时停止读取
+	d.OnPacket = func(pkt *ts.Packet) {
+		if _, ok := pesMap[pkt.PID]; ok && pkt.Offset >= endOffset && pkt.PayloadUnitStartIndicator == 1 {
+			finished[pkt.PID] = true
+			reader.stop = len(finished) == len(pesMap)
+		}
+	}
+	d.OnPes = func(pes *ts.Pes) {
+		if _, ok := pesMap[pes.PID]; ok && pes.PkgOffset >= startOffset && pes.PkgOffset < endOffset && pes.PtsDtsFlags&0x2 != 0 {
+			pesMap[pes.PID] = append(pesMap[pes.PID], pes)
+		}
+	}
+
+	err = d.Run()
+	if err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		if track.IsVideo {
+			addVideoSamples(mediaFileIndex, track, pesMap[track.PID])
+		} else {
+			addAudioSamples(mediaFileIndex, track, pesMap[track.PID])
+		}
+	}
+
+	return nil
+}
+
+// getFmp4Time 转换为 fMP4 时间轴上的时间，单位为 ts.TimeScale，处理时间戳回绕
+func getFmp4Time(mediaFileIndex *ts.MediaFileIndex, pts int64) int64 {
+
+	const ptsWrap int64 = 1 << 33
+	var time int64 = ((pts-mediaFileIndex.StartPts)%ptsWrap + ptsWrap) % ptsWrap
+	if time >= ptsWrap/2 {
+		time -= ptsWrap
+	}
+
+	return time + fmp4TimeOffset
+}
+
+// addVideoSamples 将 H.264 帧转换为样本，时长为相邻帧的解码时间之差，最后一帧按帧率计算
+func addVideoSamples(mediaFileIndex *ts.MediaFileIndex, track *fmp4Track, pesList []*ts.Pes) {
+
+	// 默认帧时长
+	var frameDuration uint32 = uint32(ts.TimeScale / 25)
+	if mediaFileIndex.VideoCodec.FrameRate > 0 {
+		frameDuration = uint32(float64(ts.TimeScale)/mediaFileIndex.VideoCodec.FrameRate + 0.5)
+	}
+
+	for _, pes := range pesList {
+
+		if track.Sps == nil {
+			track.Sps, track.Pps = ts.GetH264ParameterSets(pes.Payload)
+		}
+
+		var sample fmp4Sample
+		sample.DecodeTime = getFmp4Time(mediaFileIndex, pes.DTS)
+		sample.CompositionOffset = int32(getFmp4Time(mediaFileIndex, pes.PTS) - sample.DecodeTime)
+		sample.IsKeyFrame = pes.IsKeyFrame
+		sample.Data = ts.ConvertH264Sample(pes.Payload)
+		track.Samples = append(track.Samples, sample)
+	}
+
+	var i int
+	for i = 0; i < len(track.Samples); i++ {
+		if i+1 < len(track.Samples) && track.Samples[i+1].DecodeTime > track.Samples[i].DecodeTime {
+			track.Samples[i].Duration = uint32(track.Samples[i+1].DecodeTime - track.Samples[i].DecodeTime)
+		} else {
+			track.Samples[i].Duration = frameDuration
+		}
+	}
+}
+
+// addAudioSamples 将 ADTS 帧转换为样本，每帧 1024 个采样，时间单位为采样率
+func addAudioSamples(mediaFileIndex *ts.MediaFileIndex, track *fmp4Track, pesList []*ts.Pes) {
+
+	// 编码信息取自第一个 ADTS 帧头，不存在时使用索引中的音频编码信息
+	track.AudioCodec = mediaFileIndex.AudioCodec
+	for _, pes := range pesList {
+		if info, ok := ts.ParseAdtsHeader(pes.Payload); ok {
+			track.AudioCodec = info
+			break
+		}
+	}
+
+	track.TimeScale = track.AudioCodec.SampleRate
+	if track.TimeScale == 0 {
+		track.TimeScale = 48000
+	}
+
+	for _, pes := range pesList {
+
+		// pes 中的多个帧按采样数依次计算时间
+		decodeTime := getFmp4Time(mediaFileIndex, pes.PTS) * int64(track.TimeScale) / ts.TimeScale
+		for i, frame := range ts.SplitAdtsFrames(pes.Payload) {
+
+			var sample fmp4Sample
+			sample.DecodeTime = decodeTime + int64(i)*int64(aacFrameSamples)
+			sample.Duration = aacFrameSamples
+			sample.IsKeyFrame = true
+			sample.Data = frame
+			track.Samples = append(track.Samples, sample)
+		}
+	}
+}
+
+// createFmp4Init 创建初始化分片
+// ftyp
+// moov[mvhd,trak[tkhd,mdia[mdhd,hdlr,minf[vmhd/smhd,dinf[dref],stbl[stsd,stts,stsc,stsz,stco]]]]...,mvex[trex...]]
+func createFmp4Init(tracks []*fmp4Track) ([]byte, error) {
+
+	// ftyp: major_brand(32),minor_version(32),compatible_brands(32)...
+	ftyp := mp4Box("ftyp", []byte("iso6"), []byte{0, 0, 0, 0}, []byte("iso6cmfcmp41"))
+
+	// mvhd: creation_time(32),modification_time(32),timescale(32),duration(32),rate(32),volume(16),reserved(80),
+	// matrix(288),pre_defined(192),next_track_ID(32)
+	var mvhd []byte
+	mvhd = appendUint32(mvhd, 0)
+	mvhd = appendUint32(mvhd, 0)
+	mvhd = appendUint32(mvhd, 1000)
+	mvhd = appendUint32(mvhd, 0)
+	mvhd = appendUint32(mvhd, 0x00010000)
+	mvhd = appendUint16(mvhd, 0x0100)
+	mvhd = append(mvhd, make([]byte, 10)...)
+	mvhd = appendMatrix(mvhd)
+	mvhd = append(mvhd, make([]byte, 24)...)
+	mvhd = appendUint32(mvhd, uint32(len(tracks)+1))
+
+	var moovPayloads [][]byte = [][]byte{mp4FullBox("mvhd", 0, 0, mvhd)}
+	var mvexPayloads [][]byte
+	for _, track := range tracks {
+
+		trak, err := createTrak(track)
+		if err != nil {
+			return nil, err
+		}
+		moovPayloads = append(moovPayloads, trak)
+
+		// trex: track_ID(32),default_sample_description_index(32),default_sample_duration(32),
+		// default_sample_size(32),default_sample_flags(32)
+		var trex []byte
+		trex = appendUint32(trex, track.TrackID)
+		trex = appendUint32(trex, 1)
+		trex = appendUint32(trex, 0)
+		trex = appendUint32(trex, 0)
+		trex = appendUint32(trex, 0)
+		mvexPayloads = append(mvexPayloads, mp4FullBox("trex", 0, 0, trex))
+	}
+	moovPayloads = append(moovPayloads, mp4Box("mvex", mvexPayloads...))
+
+	return append(ftyp, mp4Box("moov", moovPayloads...)...), nil
+}
+
+// createTrak 创建轨道信息
+func createTrak(track *fmp4Track) ([]byte, error) {
+
+	// tkhd: creation_time(32),modification_time(32),track_ID(32),reserved(32),duration(32),reserved(64),
+	// layer(16),alternate_group(16),volume(16),reserved(16),matrix(288),width(32),height(32)
+	var tkhd []byte
+	tkhd = appendUint32(tkhd, 0)
+	tkhd = appendUint32(tkhd, 0)
+	tkhd = appendUint32(tkhd, track.TrackID)
+	tkhd = appendUint32(tkhd, 0)
+	tkhd = appendUint32(tkhd, 0)
+	tkhd = append(tkhd, make([]byte, 8)...)
+	tkhd = appendUint16(tkhd, 0)
+	tkhd = appendUint16(tkhd, 0)
+	if track.IsVideo {
+		tkhd = appendUint16(tkhd, 0)
+	} else {
+		tkhd = appendUint16(tkhd, 0x0100)
+	}
+	tkhd = appendUint16(tkhd, 0)
+	tkhd = appendMatrix(tkhd)
+	tkhd = appendUint32(tkhd, uint32(track.Width)<<16)
+	tkhd = appendUint32(tkhd, uint32(track.Height)<<16)
+
+	// mdhd: creation_time(32),modification_time(32),timescale(32),duration(32),pad(1),language(15),pre_defined(16)
+	// 语言为 und
+	var mdhd []byte
+	mdhd = appendUint32(mdhd, 0)
+	mdhd = appendUint32(mdhd, 0)
+	mdhd = appendUint32(mdhd, track.TimeScale)
+	mdhd = appendUint32(mdhd, 0)
+	mdhd = appendUint16(mdhd, 0x55C4)
+	mdhd = appendUint16(mdhd, 0)
+
+	// hdlr: pre_defined(32),handler_type(32),reserved(96),name
+	var hdlr []byte
+	var mediaHeader []byte
+	var sampleEntry []byte
+	var err error
+	if track.IsVideo {
+		hdlr = append(make([]byte, 4), "vide"...)
+		hdlr = append(hdlr, make([]byte, 12)...)
+		hdlr = append(hdlr, "VideoHandler\x00"...)
+
+		// vmhd: graphicsmode(16),opcolor(48)
+		mediaHeader = mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+		sampleEntry, err = createAvcSampleEntry(track)
+	} else {
+		hdlr = append(make([]byte, 4), "soun"...)
+		hdlr = append(hdlr, make([]byte, 12)...)
+		hdlr = append(hdlr, "SoundHandler\x00"...)
+
+		// smhd: balance(16),reserved(16)
+		mediaHeader = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+		sampleEntry = createAacSampleEntry(track)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// dinf[dref[url]]，媒体数据位于同一文件
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, []byte{0, 0, 0, 1}, mp4FullBox("url ", 0, 1)))
+
+	// 样本信息位于 moof 中，stbl 中的表均为空
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, sampleEntry),
+		mp4FullBox("stts", 0, 0, make([]byte, 4)),
+		mp4FullBox("stsc", 0, 0, make([]byte, 4)),
+		mp4FullBox("stsz", 0, 0, make([]byte, 8)),
+		mp4FullBox("stco", 0, 0, make([]byte, 4)))
+
+	minf := mp4Box("minf", mediaHeader, dinf, stbl)
+	mdia := mp4Box("mdia", mp4FullBox("mdhd", 0, 0, mdhd), mp4FullBox("hdlr", 0, 0, hdlr), minf)
+
+	// tkhd flags: track_enabled|track_in_movie
+	return mp4Box("trak", mp4FullBox("tkhd", 0, 3, tkhd), mdia), nil
+}
+
+// createAvcSampleEntry 创建 H.264 样本描述，参数集保留在样本中，使用 avc3
+// avcC 中的参数集取自第一个分片，只作为初始的参数集
+// avc3: reserved(48),data_reference_index(16),pre_defined(16),reserved(16),pre_defined(96),width(16),height(16),
+// horizresolution(32),vertresolution(32),reserved(32),frame_count(16),compressorname(256),depth(16),pre_defined(16)
+func createAvcSampleEntry(track *fmp4Track) ([]byte, error) {
+
+	if len(track.Sps) < 4 || len(track.Pps) == 0 {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "Create fMP4 init segment failed, H.264 parameter sets not found!")
+		return nil, err
+	}
+
+	var avc3 []byte
+	avc3 = append(avc3, make([]byte, 6)...)
+	avc3 = appendUint16(avc3, 1)
+	avc3 = append(avc3, make([]byte, 16)...)
+	avc3 = appendUint16(avc3, track.Width)
+	avc3 = appendUint16(avc3, track.Height)
+	avc3 = appendUint32(avc3, 0x00480000)
+	avc3 = appendUint32(avc3, 0x00480000)
+	avc3 = appendUint32(avc3, 0)
+	avc3 = appendUint16(avc3, 1)
+	avc3 = append(avc3, make([]byte, 32)...)
+	avc3 = appendUint16(avc3, 0x0018)
+	avc3 = appendUint16(avc3, 0xFFFF)
+
+	// avcC: configurationVersion(8),AVCProfileIndication(8),profile_compatibility(8),AVCLevelIndication(8),
+	// reserved(6),lengthSizeMinusOne(2),reserved(3),numOfSequenceParameterSets(5),{length(16),sps}...,
+	// numOfPictureParameterSets(8),{length(16),pps}...
+	var avcC []byte
+	avcC = append(avcC, 1, track.Sps[1], track.Sps[2], track.Sps[3], 0xFF, 0xE1)
+	avcC = appendUint16(avcC, uint16(len(track.Sps)))
+	avcC = append(avcC, track.Sps...)
+	avcC = append(avcC, 1)
+	avcC = appendUint16(avcC, uint16(len(track.Pps)))
+	avcC = append(avcC, track.Pps...)
+
+	return mp4Box("avc3", avc3, mp4Box("avcC", avcC)), nil
+}
+
+// createAacSampleEntry 创建 AAC 样本描述
+// mp4a: reserved(48),data_reference_index(16),reserved(64),channelcount(16),samplesize(16),pre_defined(16),
+// reserved(16),samplerate(32)，samplerate 为16.16定点数，采样率超过 65535 时为0，以 AudioSpecificConfig 为准
+func createAacSampleEntry(track *fmp4Track) []byte {
+
+	var channels uint16 = uint16(track.AudioCodec.Channels)
+	if channels == 0 {
+		channels = 2
+	}
+
+	var mp4a []byte
+	mp4a = append(mp4a, make([]byte, 6)...)
+	mp4a = appendUint16(mp4a, 1)
+	mp4a = append(mp4a, make([]byte, 8)...)
+	mp4a = appendUint16(mp4a, channels)
+	mp4a = appendUint16(mp4a, 16)
+	mp4a = appendUint16(mp4a, 0)
+	mp4a = appendUint16(mp4a, 0)
+	var sampleRate uint32 = 0
+	if track.TimeScale <= 0xFFFF {
+		sampleRate = track.TimeScale << 16
+	}
+	mp4a = appendUint32(mp4a, sampleRate)
+
+	// ES_Descriptor: ES_ID(16),flags(8),DecoderConfigDescriptor,SLConfigDescriptor
+	// DecoderConfigDescriptor: objectTypeIndication(8)=0x40,streamType(6)=0x05,upStream(1),reserved(1),
+	// bufferSizeDB(24),maxBitrate(32),avgBitrate(32),DecoderSpecificInfo
+	decoderConfig := []byte{0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	decoderSpecificInfo := mp4Descriptor(0x05, track.AudioCodec.AudioSpecificConfig())
+	esDescriptor := mp4Descriptor(0x03, []byte{0, byte(track.TrackID), 0},
+		mp4Descriptor(0x04, decoderConfig, decoderSpecificInfo),
+		mp4Descriptor(0x06, []byte{0x02}))
+
+	return mp4Box("mp4a", mp4a, mp4FullBox("esds", 0, 0, esDescriptor))
+}
+
+// createFmp4Fragment 创建媒体分片，每个轨道一个 traf，样本数据按轨道顺序写入 mdat
+// moof[mfhd,traf[tfhd,tfdt,trun]...]
+// mdat
+func createFmp4Fragment(sequence int, tracks []*fmp4Track) []byte {
+
+	// 先以数据偏移量 0 计算 moof 大小，再写入实际的数据偏移量
+	moof := createMoof(sequence, tracks, 0)
+	moof = createMoof(sequence, tracks, uint32(len(moof)+8))
+
+	var mdatPayloads [][]byte
+	for _, track := range tracks {
+		for _, sample := range track.Samples {
+			mdatPayloads = append(mdatPayloads, sample.Data)
+		}
+	}
+
+	return append(moof, mp4Box("mdat", mdatPayloads...)...)
+}
+
+// createMoof 创建分片信息
+//
+//	dataOffset 第一个样本相对于 moof 开始的偏移量
+func createMoof(sequence int, tracks []*fmp4Track, dataOffset uint32) []byte {
+
+	// mfhd: sequence_number(32)，从1开始
+	var mfhd []byte
+	mfhd = appendUint32(mfhd, uint32(sequence+1))
+
+	var moofPayloads [][]byte = [][]byte{mp4FullBox("mfhd", 0, 0, mfhd)}
+	for _, track := range tracks {
+
+		// tfhd flags: default-base-is-moof
+		var tfhd []byte
+		tfhd = appendUint32(tfhd, track.TrackID)
+
+		// tfdt: baseMediaDecodeTime(64)
+		var baseDecodeTime int64 = 0
+		if len(track.Samples) > 0 && track.Samples[0].DecodeTime > 0 {
+			baseDecodeTime = track.Samples[0].DecodeTime
+		}
+		var tfdt []byte
+		tfdt = appendUint64(tfdt, uint64(baseDecodeTime))
+
+		// trun: sample_count(32),data_offset(32),{sample_duration(32),sample_size(32),sample_flags(32),
+		// sample_composition_time_offset(32)}...
+		// 视频 flags: data-offset|sample-duration|sample-size|sample-flags|sample-composition-time-offset
+		// 音频 flags: data-offset|sample-duration|sample-size
+		var trunFlags uint32 = 0x000301
+		if track.IsVideo {
+			trunFlags = 0x000F01
+		}
+
+		var trun []byte
+		trun = appendUint32(trun, uint32(len(track.Samples)))
+		trun = appendUint32(trun, dataOffset)
+		for _, sample := range track.Samples {
+			trun = appendUint32(trun, sample.Duration)
+			trun = appendUint32(trun, uint32(len(sample.Data)))
+			if track.IsVideo {
+				if sample.IsKeyFrame {
+					trun = appendUint32(trun, sampleFlagsKeyFrame)
+				} else {
+					trun = appendUint32(trun, sampleFlagsNonKeyFrame)
+				}
+				trun = appendUint32(trun, uint32(sample.CompositionOffset))
+			}
+			dataOffset += uint32(len(sample.Data))
+		}
+
+		// trun 使用 version 1，显示时间偏移为有符号数
+		moofPayloads = append(moofPayloads, mp4Box("traf",
+			mp4FullBox("tfhd", 0, 0x020000, tfhd),
+			mp4FullBox("tfdt", 1, 0, tfdt),
+			mp4FullBox("trun", 1, trunFlags, trun)))
+	}
+
+	return mp4Box("moof", moofPayloads...)
+}
@@ -0,0 +1,196 @@
+package hls
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	errors "../errors"
+	ts "../ts"
+)
+
+// IFramePlaylist 是否为视频生成I帧播放列表(EXT-X-I-FRAMES-ONLY)，用于快进快退及拖动预览
+var IFramePlaylist bool
+
+// UseIFramePlaylist 是否输出I帧播放列表
+// I帧以字节范围引用源文件，加密、非188字节包或选择了非默认节目时不输出
+//
+//	programNumber 节目号，0 表示第一个节目
+func UseIFramePlaylist(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, programNumber uint16) bool {
+
+	if !IFramePlaylist || programNumber != 0 || mediaFileIndex.VideoPID == 0 || len(mediaFileIndex.KeyFrames) == 0 {
+		return false
+	}
+
+	// 加密需要在输出时进行
+	if IsEncrypted(baseFileURINoSuffix) {
+		return false
+	}
+
+	// 初始化分片中的pat、pmt为188字节的ts包
+	return mediaFileIndex.PkgSize == ts.TsPkgSize
+}
+
+// GetIFrameM3U8 I帧m3u8文件获取，请求路径为 {group_name}/xxx.m3u8
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetIFrameM3U8(m3u8FileURI string, host string, programNumber uint16) (string, error) {
+
+	// 无后缀的基本文件路径
+	var baseFileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".m3u8"), ".M3U8")
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		Log.Error(err.Error())
+		return "", err
+	}
+
+	if !UseIFramePlaylist(mediaFileIndex, baseFileURINoSuffix, programNumber) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetIFrameM3U8 failed, I-frame playlist not exist!")
+		return "", err
+	}
+
+	return createIFrameM3u8(mediaFileIndex, baseFileURINoSuffix, host), nil
+}
+
+// GetIFrameInit I帧播放列表及字节范围引用的二级m3u8的初始化分片获取，请求路径为 {group_name}/xxx_init.ts
+// 初始化分片只包含pat、pmt，I帧及分片的字节范围直接引用源文件，不包含插入的pat、pmt
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetIFrameInit(fileURI string, programNumber uint16) ([]byte, error) {
+
+	Log.Debug("GetIFrameInit, fileURI:" + fileURI)
+
+	if !strings.HasSuffix(fileURI, "_init.ts") {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetIFrameInit failed, unsupported file type!")
+		return nil, err
+	}
+	var baseFileURINoSuffix = strings.TrimSuffix(fileURI, "_init.ts")
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	useInit := UseIFramePlaylist(mediaFileIndex, baseFileURINoSuffix, programNumber) || UseByteRange(mediaFileIndex, baseFileURINoSuffix, programNumber)
+	if !useInit || mediaFileIndex.GetProgramPsi() == nil {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetIFrameInit failed, I-frame playlist not exist!")
+		return nil, err
+	}
+
+	return ts.CreatePsiPackets(mediaFileIndex.GetProgramPsi(), nil, ts.TsPkgSize), nil
+}
+
+// createIFrameM3u8 创建I帧m3u8，每个I帧一个分片，时长为到下一个I帧的时长
+// #EXTM3U
+// #EXT-X-VERSION:5
+// #EXT-X-TARGETDURATION:{MAX_DURATION}
+// #EXT-X-MEDIA-SEQUENCE:0
+// #EXT-X-PLAYLIST-TYPE:VOD
+// #EXT-X-I-FRAMES-ONLY
+// #EXT-X-MAP:URI="http://host:port/iframe/{group_name}/xxx_init.ts"
+// #EXTINF:2.000,
+// #EXT-X-BYTERANGE:{SIZE}@{START_OFFSET}
+// http://host:port/media/{group_name}/xxx.ts
+// #EXT-X-ENDLIST
+func createIFrameM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, host string) string {
+
+	Log.Debug(">>> GetIFrameM3u8 Start: " + baseFileURINoSuffix + ".m3u8")
+
+	// m3u8 文件内容
+	var resultStr = ""
+
+	// #EXTM3U
+	resultStr += "#EXTM3U\n"
+
+	// I帧播放列表中的 EXT-X-MAP 需要版本5
+	resultStr += "#EXT-X-VERSION:5\n"
+
+	// #EXT-X-TARGETDURATION，I帧间隔由源文件决定，取最大值
+	var targetDuration int = 1
+	var i int
+	for i = 0; i < len(mediaFileIndex.KeyFrames); i++ {
+		if duration := roundDuration(getIFrameDuration(mediaFileIndex, i)); duration > targetDuration {
+			targetDuration = duration
+		}
+	}
+	resultStr += "#EXT-X-TARGETDURATION:" + strconv.Itoa(targetDuration) + "\n"
+
+	// #EXT-X-MEDIA-SEQUENCE:0
+	// #EXT-X-PLAYLIST-TYPE:VOD
+	resultStr += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	resultStr += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+	resultStr += "#EXT-X-I-FRAMES-ONLY\n"
+
+	// #EXT-X-MAP
+	resultStr += "#EXT-X-MAP:URI=\"" + getMediaURL(host, "/iframe/", baseFileURINoSuffix, "_init.ts") + "\"\n"
+
+	// 所有I帧使用同一个地址
+	mediaURL := getMediaURL(host, "/media/", baseFileURINoSuffix, ".ts")
+	for i = 0; i < len(mediaFileIndex.KeyFrames); i++ {
+
+		keyFrame := mediaFileIndex.KeyFrames[i]
+
+		// #EXT-X-DISCONTINUITY
+		if keyFrame.Discontinuity {
+			resultStr += "#EXT-X-DISCONTINUITY\n"
+		}
+
+		// #EXTINF:2.000,
+		resultStr += "#EXTINF:" + formatDuration(getIFrameDuration(mediaFileIndex, i)) + ",\n"
+
+		// #EXT-X-BYTERANGE:{SIZE}@{START_OFFSET}
+		resultStr += "#EXT-X-BYTERANGE:" + strconv.FormatUint(uint64(keyFrame.Size), 10) + "@" + strconv.FormatUint(keyFrame.StartOffset, 10) + "\n"
+		resultStr += mediaURL + "\n"
+	}
+
+	// #EXT-X-ENDLIST
+	resultStr += "#EXT-X-ENDLIST"
+
+	Log.Debug("<<< GetIFrameM3u8 End")
+	return resultStr
+}
+
+// createIFrameStreamInf 创建一级m3u8中的I帧播放列表
+// #EXT-X-I-FRAME-STREAM-INF:BANDWIDTH={BANDWIDTH},CODECS="{CODECS}",RESOLUTION={WIDTH}x{HEIGHT},URI="http://host:port/hls_iframe/{group_name}/xxx.m3u8"
+func createIFrameStreamInf(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, host string) string {
+
+	// BANDWIDTH 为单个I帧码率的峰值，单位为 bit/s
+	var bandwidth float64 = 0
+	var i int
+	for i = 0; i < len(mediaFileIndex.KeyFrames); i++ {
+		duration := getIFrameDuration(mediaFileIndex, i)
+		if duration > 0 {
+			bandwidth = math.Max(bandwidth, float64(mediaFileIndex.KeyFrames[i].Size)*8/duration)
+		}
+	}
+	var resultStr = "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=" + strconv.FormatUint(uint64(math.Ceil(bandwidth)), 10)
+
+	// 只包含视频编码
+	if videoCodec := getVideoCodec(&mediaFileIndex.VideoCodec); videoCodec != "" {
+		resultStr += ",CODECS=\"" + videoCodec + "\""
+	}
+	if resolution := GetResolution(mediaFileIndex); resolution != "" {
+		resultStr += ",RESOLUTION=" + resolution
+	}
+
+	return resultStr + ",URI=\"" + getMediaURL(host, "/hls_iframe/", baseFileURINoSuffix, ".m3u8") + "\"\n"
+}
+
+// getIFrameDuration I帧的时长(秒)，为到下一个I帧的时长，最后一个I帧到媒体结束
+func getIFrameDuration(mediaFileIndex *ts.MediaFileIndex, index int) float64 {
+
+	var endTime int64 = mediaFileIndex.TimesArray[len(mediaFileIndex.TimesArray)-1].MaxTime
+	if index+1 < len(mediaFileIndex.KeyFrames) {
+		endTime = mediaFileIndex.KeyFrames[index+1].Time
+	}
+
+	// 不连续点前后的显示时间可能交错
+	duration := endTime - mediaFileIndex.KeyFrames[index].Time
+	if duration < 0 {
+		return 0
+	}
+	return float64(duration) / float64(ts.TimeScale)
+}
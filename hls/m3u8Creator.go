@@ -1,7 +1,7 @@
 package hls
 
 import (
-	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -15,9 +15,12 @@ import (
 // Log 系统日志
 var Log *ezlog.Log
 
-// TargetDuration m3u8单片最大时长
+// TargetDuration 分片目标时长，分片只在关键帧处切分，实际时长可能超过目标时长
 var TargetDuration int
 
+// HevcCodecTag HEVC 在 CODECS 中使用的标识，hvc1 或 hev1
+var HevcCodecTag string
+
 // Init 初始化
 func Init() {
 
@@ -36,35 +39,225 @@ func Init() {
 		panic(err.Error())
 	}
 
+	// HEVC 标识，默认 hvc1
+	HevcCodecTag, err = config.SysConfig.Get("m3u8.hevc_codec_tag")
+	if err != nil || HevcCodecTag == "" {
+		HevcCodecTag = "hvc1"
+	}
+
+	// SCTE-35 切点是否输出 EXT-X-CUE-OUT/EXT-X-CUE-IN，默认不输出
+	cueTagsStr, err := config.SysConfig.Get("m3u8.cue_tags")
+	CueTags = err == nil && cueTagsStr == "true"
+
+	// 是否以字节范围引用源文件，默认不使用
+	byteRangeStr, err := config.SysConfig.Get("m3u8.byte_range")
+	ByteRange = err == nil && byteRangeStr == "true"
+
+	// 是否输出I帧播放列表，默认不输出
+	iFramePlaylistStr, err := config.SysConfig.Get("m3u8.i_frame_playlist")
+	IFramePlaylist = err == nil && iFramePlaylistStr == "true"
+
+	// 媒体分片格式，默认 ts
+	SegmentFormat, err = config.SysConfig.Get("m3u8.segment_format")
+	if err != nil || SegmentFormat != SegmentFormatFmp4 {
+		SegmentFormat = SegmentFormatTs
+	}
+
 	Log = logger.Log
 }
 
-// GetM3U8 M3U8文件获取
-func GetM3U8(m3u8FileURI string, host string) (string, error) {
+// GetM3U8 一级M3U8文件获取，存在多个码率的版本时每个版本输出一个 EXT-X-STREAM-INF
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetM3U8(m3u8FileURI string, host string, programNumber uint16) (string, error) {
+
+	// 无后缀的基本文件路径
+	var baseFileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".m3u8"), ".M3U8")
+
+	// 获取各版本的ts索引对象
+	renditions, err := getRenditions(baseFileURINoSuffix, programNumber)
+
+	if err != nil {
+		Log.Error(err.Error())
+		return "", err
+	}
+	return createMainM3u8(renditions, host, programNumber), nil
+}
+
+// GetSubM3U8 二级M3U8文件获取
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetSubM3U8(m3u8FileURI string, host string, programNumber uint16) (string, error) {
 
 	// 无后缀的基本文件路径
 	var baseFileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".m3u8"), ".M3U8")
 
 	// 获取ts索引对象
-	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix)
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
 
 	if err != nil {
 		Log.Error(err.Error())
 		return "", err
 	}
-	return createSubM3u8(mediaFileIndex, baseFileURINoSuffix, host), nil
+	return createSubM3u8(mediaFileIndex, baseFileURINoSuffix, host, programNumber), nil
+}
+
+// createMainM3u8 创建一级m3u8，多个版本时音频、字幕的组ID后追加版本序号
+// #EXTM3U
+// #EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="{LANGUAGE}",LANGUAGE="{LANGUAGE}",DEFAULT=YES,AUTOSELECT=YES,URI="http://host:port/hls_audio/{group_name}/xxx_{track}.m3u8"
+// #EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="{LANGUAGE} {PAGE}",LANGUAGE="{LANGUAGE}",DEFAULT=NO,AUTOSELECT=YES,URI="http://host:port/hls_subtitle/{group_name}/xxx_{track}.m3u8"
+// #EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH={BANDWIDTH},CODECS="{CODECS}",RESOLUTION={WIDTH}x{HEIGHT},FRAME-RATE={FRAME_RATE},NAME="{SERVICE_NAME}",AUDIO="audio",SUBTITLES="subs"
+// http://host:port/hls_sub/{group_name}/xxx.m3u8
+// #EXT-X-I-FRAME-STREAM-INF:BANDWIDTH={BANDWIDTH},CODECS="{CODECS}",RESOLUTION={WIDTH}x{HEIGHT},URI="http://host:port/hls_iframe/{group_name}/xxx.m3u8"
+func createMainM3u8(renditions []rendition, host string, programNumber uint16) string {
+
+	Log.Debug(">>> GetMainM3u8 Start: " + renditions[0].BaseFileURINoSuffix + ".m3u8, renditions: " + strconv.Itoa(len(renditions)))
+
+	// m3u8 文件内容
+	var resultStr = ""
+
+	// #EXTM3U
+	resultStr += "#EXTM3U\n"
+
+	// #EXT-X-MEDIA
+	for i := range renditions {
+		resultStr += createMediaTags(&renditions[i], getGroupIDSuffix(renditions, i), host, programNumber)
+	}
+
+	// #EXT-X-STREAM-INF
+	for i := range renditions {
+		resultStr += createStreamInf(&renditions[i], getGroupIDSuffix(renditions, i), host, programNumber)
+	}
+
+	// #EXT-X-I-FRAME-STREAM-INF
+	for _, r := range renditions {
+		if UseIFramePlaylist(r.MediaFileIndex, r.BaseFileURINoSuffix, programNumber) {
+			resultStr += createIFrameStreamInf(r.MediaFileIndex, r.BaseFileURINoSuffix, host)
+		}
+	}
+
+	Log.Debug("<<< GetMainM3u8 End")
+	return resultStr
+}
+
+// getGroupIDSuffix 音频、字幕组ID的后缀，只有一个版本时为空
+func getGroupIDSuffix(renditions []rendition, index int) string {
+	if len(renditions) == 1 {
+		return ""
+	}
+	return "_" + strconv.Itoa(index)
+}
+
+// createMediaTags 创建版本的音频轨道及字幕轨道
+// #EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio{SUFFIX}",...
+// #EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs{SUFFIX}",...
+func createMediaTags(r *rendition, groupIDSuffix string, host string, programNumber uint16) string {
+
+	var resultStr = ""
+
+	// #EXT-X-MEDIA:TYPE=AUDIO，存在多个音频轨道时每个音频轨道一个音频m3u8
+	if HasAlternateAudio(r.MediaFileIndex) {
+		resultStr += createAudioMedia(r.MediaFileIndex, r.BaseFileURINoSuffix, audioGroupID+groupIDSuffix, host, programNumber)
+	}
+
+	// #EXT-X-MEDIA:TYPE=SUBTITLES，每个字幕轨道一个字幕m3u8
+	for i, track := range r.MediaFileIndex.SubtitleTracks {
+		resultStr += "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"" + subtitleGroupID + groupIDSuffix + "\",NAME=\"" + getSubtitleName(&track) + "\""
+		if track.Language != "" {
+			resultStr += ",LANGUAGE=\"" + track.Language + "\""
+		}
+		resultStr += ",DEFAULT=NO,AUTOSELECT=YES"
+		if track.HearingImpaired {
+			resultStr += ",CHARACTERISTICS=\"public.accessibility.transcribes-spoken-dialog,public.accessibility.describes-music-and-sound\""
+		}
+		resultStr += ",URI=\"" + getMediaURL(host, "/hls_subtitle/", r.BaseFileURINoSuffix, "_"+strconv.Itoa(i)+".m3u8") + getProgramQuery(programNumber) + "\"\n"
+	}
+
+	return resultStr
+}
+
+// createStreamInf 创建版本的 EXT-X-STREAM-INF 及二级m3u8地址
+// #EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH={BANDWIDTH},AVERAGE-BANDWIDTH={AVERAGE_BANDWIDTH},CODECS="{CODECS}",...
+// http://host:port/hls_sub/{group_name}/xxx.m3u8
+func createStreamInf(r *rendition, groupIDSuffix string, host string, programNumber uint16) string {
+
+	mediaFileIndex := r.MediaFileIndex
+
+	// #EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH={BANDWIDTH},AVERAGE-BANDWIDTH={AVERAGE_BANDWIDTH},CODECS="{CODECS}"
+	// BANDWIDTH 为分片码率的峰值，AVERAGE-BANDWIDTH 为整个文件的平均码率，单位为 bit/s
+	var averageBandwidth uint64 = uint64(mediaFileIndex.BindWidth) * 8
+	var resultStr = "#EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=" + strconv.FormatUint(getPeakBandwidth(mediaFileIndex), 10)
+	resultStr += ",AVERAGE-BANDWIDTH=" + strconv.FormatUint(averageBandwidth, 10)
+
+	codecs := GetCodecs(mediaFileIndex)
+	if codecs != "" {
+		resultStr += ",CODECS=\"" + codecs + "\""
+	}
+
+	// 视频图像尺寸及帧率
+	if resolution := GetResolution(mediaFileIndex); resolution != "" {
+		resultStr += ",RESOLUTION=" + resolution
+	}
+	if frameRate := GetFrameRate(mediaFileIndex); frameRate != "" {
+		resultStr += ",FRAME-RATE=" + frameRate
+	}
+
+	// 存在SDT业务名称时输出 NAME，属性值中不能包含双引号及换行
+	if serviceName := mediaFileIndex.Service.ServiceName; serviceName != "" {
+		serviceName = strings.NewReplacer("\"", "'", "\n", " ", "\r", " ").Replace(serviceName)
+		resultStr += ",NAME=\"" + serviceName + "\""
+	}
+
+	// 音频轨道独立输出时关联音频组
+	if HasAlternateAudio(mediaFileIndex) {
+		resultStr += ",AUDIO=\"" + audioGroupID + groupIDSuffix + "\""
+	}
+
+	// 存在字幕轨道时关联字幕组
+	if len(mediaFileIndex.SubtitleTracks) > 0 {
+		resultStr += ",SUBTITLES=\"" + subtitleGroupID + groupIDSuffix + "\""
+	}
+	resultStr += "\n"
+
+	// 二级m3u8地址
+	resultStr += getMediaURL(host, "/hls_sub/", r.BaseFileURINoSuffix, ".m3u8") + getProgramQuery(programNumber) + "\n"
+	return resultStr
 }
 
-// createMainM3u8 创建二级m3u8
+// getPeakBandwidth 分片码率的峰值，单位为 bit/s，不低于整个文件的平均码率
+func getPeakBandwidth(mediaFileIndex *ts.MediaFileIndex) uint64 {
+
+	var bandwidth float64 = float64(mediaFileIndex.BindWidth) * 8
+	for _, video := range GetVideoList(mediaFileIndex, float64(TargetDuration)) {
+		if video.Duration > 0 {
+			bandwidth = math.Max(bandwidth, float64(video.Size)*8/video.Duration)
+		}
+	}
+
+	return uint64(math.Ceil(bandwidth))
+}
+
+// createSubM3u8 创建二级m3u8
 // #EXTM3U
 // #EXT-X-VERSION:4
-// #EXT-X-TARGETDURATION:{M3U8_TARGET_DURATION}
+// #EXT-X-TARGETDURATION:{MAX_DURATION}
 // #EXT-X-MEDIA-SEQUENCE:0
 // #EXT-X-PLAYLIST-TYPE:VOD
+// #EXT-X-PROGRAM-DATE-TIME:1970-01-01T00:00:00.000Z
+// #EXT-X-DATERANGE:ID="splice-{EVENT_ID}-{N}",START-DATE="{DATE}",PLANNED-DURATION={DURATION},SCTE35-OUT=0x{SPLICE_INFO}
+// #EXT-X-KEY:METHOD=AES-128,URI="http://host:port/key/{group_name}/xxx_{keyIndex}.key",IV=0x{SEQUENCE}
 // #EXTINF:6.006,
 // 2000_vod_00001.ts
 // #EXT-X-ENDLIST
-func createSubM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, host string) string {
+// fMP4 分片时版本为7，分片之前输出初始化分片
+// #EXT-X-MAP:URI="http://host:port/fmp4/{group_name}/xxx_init.mp4"
+// http://host:port/fmp4/{group_name}/xxx_{sequence}.m4s
+// SAMPLE-AES 加密时版本为5
+// 以字节范围引用源文件时版本为6，所有分片使用同一个地址，分片之前输出只包含pat、pmt的初始化分片
+// #EXT-X-MAP:URI="http://host:port/iframe/{group_name}/xxx_init.ts"
+// #EXT-X-BYTERANGE:{SIZE}@{START_OFFSET}
+// http://host:port/media/{group_name}/xxx.ts
+func createSubM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, host string, programNumber uint16) string {
 
 	Log.Debug(">>> GetSubnM3u8 Start: " + baseFileURINoSuffix + ".m3u8")
 
@@ -75,40 +268,68 @@ func createSubM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string
 	resultStr += "#EXTM3U\n"
 
 	// #EXT-X-VERSION:4
-	resultStr += "#EXT-X-VERSION:4 \n"
+	var isFmp4 bool = UseFmp4(mediaFileIndex)
+	var isByteRange bool = UseByteRange(mediaFileIndex, baseFileURINoSuffix, programNumber)
+	if isByteRange {
+
+		// 非I帧播放列表中的 EXT-X-MAP 需要版本6
+		resultStr += "#EXT-X-VERSION:6\n"
+	} else {
+		resultStr += createVersionTag(mediaFileIndex, baseFileURINoSuffix)
+	}
+
+	// 获取文件列表
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
 
-	// #EXT-X-TARGETDURATION:{M3U8_TARGET_DURATION}
-	targetDurationStr := strconv.FormatUint(uint64(TargetDuration), 10)
-	resultStr += "#EXT-X-TARGETDURATION:" + targetDurationStr + "\n"
+	// #EXT-X-TARGETDURATION:{MAX_DURATION}
+	resultStr += "#EXT-X-TARGETDURATION:" + strconv.Itoa(getTargetDuration(videoList)) + "\n"
 
 	// #EXT-X-MEDIA-SEQUENCE:0
 	// #EXT-X-PLAYLIST-TYPE:VOD
 	resultStr += "#EXT-X-MEDIA-SEQUENCE:0\n"
 	resultStr += "#EXT-X-PLAYLIST-TYPE:VOD\n"
 
-	// 获取文件列表
-	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+	// #EXT-X-MAP
+	if isFmp4 {
+		resultStr += "#EXT-X-MAP:URI=\"" + getMediaURL(host, "/fmp4/", baseFileURINoSuffix, "_init.mp4") + getProgramQuery(programNumber) + "\"\n"
+	} else if isByteRange {
+		resultStr += "#EXT-X-MAP:URI=\"" + getMediaURL(host, "/iframe/", baseFileURINoSuffix, "_init.ts") + "\"\n"
+	}
 
 	var i int
 	for i = 0; i < len(videoList); i++ {
 
-		// #EXTINF:6.006,
-		resultStr += "#EXTINF:" + fmt.Sprintf("%.2f", videoList[i].Duration) + "\n"
+		// #EXT-X-DISCONTINUITY
+		if videoList[i].Discontinuity {
+			resultStr += "#EXT-X-DISCONTINUITY\n"
+		}
 
-		// ./video/video_index.M3U8
-		// 作为二级m3u8文件"
-		sequenceStr := strconv.FormatUint(uint64(videoList[i].Sequence), 10)
+		// 存在SCTE-35切点时，EXT-X-DATERANGE 需要以 EXT-X-PROGRAM-DATE-TIME 确定日期
+		// 不连续点之后播放器不再按时长推算日期，重新输出
+		if (i == 0 || videoList[i].Discontinuity) && len(mediaFileIndex.SplicePoints) > 0 {
+			resultStr += "#EXT-X-PROGRAM-DATE-TIME:" + formatProgramDateTime(videoList[i].StartTime) + "\n"
+		}
 
-		// 组名
-		groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+		// #EXT-X-DATERANGE
+		resultStr += createSpliceTags(mediaFileIndex, videoList, i)
 
-		// 视频相对路径
-		mediaFileURI := baseFileURINoSuffix[strings.Index(baseFileURINoSuffix, "/")+1 : len(baseFileURINoSuffix)]
+		// #EXT-X-KEY
+		resultStr += createKeyTag(mediaFileIndex, baseFileURINoSuffix, videoList[i].Sequence, host, programNumber)
 
-		var escapeUrl string = "http://" + host + "/video/" + groupName + "/" + url.QueryEscape(mediaFileURI + "_" + sequenceStr + ".ts") + "\n"
+		// #EXTINF:6.006,
+		resultStr += "#EXTINF:" + formatDuration(videoList[i].Duration) + ",\n"
 
-		// 防止encodeURL导致 空格变 + 
-		resultStr += strings.Replace(escapeUrl, "+", "%20", -1)
+		// ./video/video_index.M3U8
+		// 作为二级m3u8文件"
+		sequenceStr := strconv.FormatUint(uint64(videoList[i].Sequence), 10)
+		if isFmp4 {
+			resultStr += getMediaURL(host, "/fmp4/", baseFileURINoSuffix, "_"+sequenceStr+".m4s") + getProgramQuery(programNumber) + "\n"
+		} else if isByteRange {
+			resultStr += "#EXT-X-BYTERANGE:" + strconv.FormatUint(videoList[i].Size, 10) + "@" + strconv.FormatUint(videoList[i].StartOffset, 10) + "\n"
+			resultStr += getMediaURL(host, "/media/", baseFileURINoSuffix, ".ts") + "\n"
+		} else {
+			resultStr += getMediaURL(host, "/video/", baseFileURINoSuffix, "_"+sequenceStr+".ts") + getProgramQuery(programNumber) + "\n"
+		}
 	}
 
 	// #EXT-X-ENDLIST
@@ -117,3 +338,43 @@ func createSubM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string
 	Log.Debug("<<< GetSubM3u8 End")
 	return resultStr
 }
+
+// createVersionTag 创建二级m3u8的版本号，fMP4 分片(EXT-X-MAP)为7，KEYFORMAT 需要版本5，其他为4
+func createVersionTag(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string) string {
+
+	if UseFmp4(mediaFileIndex) {
+		return "#EXT-X-VERSION:7\n"
+	}
+	if getEncryptMethod(mediaFileIndex, baseFileURINoSuffix) == encryptMethodSampleAes {
+		return "#EXT-X-VERSION:5\n"
+	}
+	return "#EXT-X-VERSION:4 \n"
+}
+
+// getMediaURL 计算媒体相关的请求地址
+//
+//	host 服务地址
+//	routePrefix 路由前缀，例如 /video/
+//	baseFileURINoSuffix 不带后缀的请求路径
+//	suffix 追加在媒体相对路径后的后缀
+func getMediaURL(host string, routePrefix string, baseFileURINoSuffix string, suffix string) string {
+
+	// 组名
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
+
+	// 视频相对路径
+	mediaFileURI := baseFileURINoSuffix[strings.Index(baseFileURINoSuffix, "/")+1 : len(baseFileURINoSuffix)]
+
+	var escapeUrl string = "http://" + host + routePrefix + groupName + "/" + url.QueryEscape(mediaFileURI+suffix)
+
+	// 防止encodeURL导致 空格变 + 
+	return strings.Replace(escapeUrl, "+", "%20", -1)
+}
+
+// getProgramQuery 计算节目选择参数，默认节目不需要参数
+func getProgramQuery(programNumber uint16) string {
+	if programNumber == 0 {
+		return ""
+	}
+	return "?program=" + strconv.Itoa(int(programNumber))
+}
@@ -0,0 +1,79 @@
+package hls
+
+import (
+	"encoding/binary"
+)
+
+// mp4 box 写入工具，box 均按 ISO/IEC 14496-12 的字段顺序以大端序写入
+
+// mp4Box 生成 box，size(32),type(32),payload
+func mp4Box(boxType string, payloads ...[]byte) []byte {
+
+	var size int = 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	var box []byte = make([]byte, 0, size)
+	box = appendUint32(box, uint32(size))
+	box = append(box, boxType...)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+
+	return box
+}
+
+// mp4FullBox 生成 full box，size(32),type(32),version(8),flags(24),payload
+func mp4FullBox(boxType string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+// appendUint16 追加16bit整数
+func appendUint16(data []byte, value uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+// appendUint32 追加32bit整数
+func appendUint32(data []byte, value uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+// appendUint64 追加64bit整数
+func appendUint64(data []byte, value uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+// mp4Matrix 单位变换矩阵
+var mp4Matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// appendMatrix 追加单位变换矩阵
+func appendMatrix(data []byte) []byte {
+	for _, value := range mp4Matrix {
+		data = appendUint32(data, value)
+	}
+	return data
+}
+
+// mp4Descriptor 生成 MPEG-4 描述符，tag(8),size(8...),payload，长度使用4字节的可变长编码
+func mp4Descriptor(tag uint8, payloads ...[]byte) []byte {
+
+	var size int = 0
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	var descriptor []byte = []byte{tag, 0x80 | byte(size>>21&0x7F), 0x80 | byte(size>>14&0x7F), 0x80 | byte(size>>7&0x7F), byte(size & 0x7F)}
+	for _, payload := range payloads {
+		descriptor = append(descriptor, payload...)
+	}
+
+	return descriptor
+}
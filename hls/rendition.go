@@ -0,0 +1,148 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	errors "../errors"
+	ts "../ts"
+)
+
+// renditionFileSuffix 版本清单文件后缀，与媒体文件同目录，例如 movie.renditions
+const renditionFileSuffix string = ".renditions"
+
+// renditionLabel 按命名约定识别版本的后缀，例如 movie_1080p、movie_720p、movie_2500k
+var renditionLabel = regexp.MustCompile(`^_[0-9]+[pPkK]$`)
+
+// rendition 同一媒体的一个码率版本
+type rendition struct {
+	BaseFileURINoSuffix string             // 不带后缀的请求路径
+	MediaFileIndex      *ts.MediaFileIndex // ts索引对象
+}
+
+// getRenditions 获取媒体的所有码率版本
+// 存在版本清单文件时按清单顺序输出，否则按命名约定查找同目录下的版本，按码率从高到低排列
+// 未找到其他版本时只包含请求的媒体文件本身
+//
+//	programNumber 节目号，0 表示第一个节目
+func getRenditions(baseFileURINoSuffix string, programNumber uint16) ([]rendition, error) {
+
+	renditionURIs, fromManifest := findRenditionURIs(baseFileURINoSuffix)
+
+	// 单一版本
+	if len(renditionURIs) == 0 {
+		mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+		if err != nil {
+			return nil, err
+		}
+		return []rendition{{BaseFileURINoSuffix: baseFileURINoSuffix, MediaFileIndex: mediaFileIndex}}, nil
+	}
+
+	// 索引失败的版本不输出
+	var renditions []rendition
+	for _, renditionURI := range renditionURIs {
+		mediaFileIndex, err := ts.GetMediaFileIndex(renditionURI, programNumber)
+		if err != nil {
+			Log.Warn("Skip rendition " + renditionURI + ", " + err.Error())
+			continue
+		}
+		renditions = append(renditions, rendition{BaseFileURINoSuffix: renditionURI, MediaFileIndex: mediaFileIndex})
+	}
+
+	if len(renditions) == 0 {
+		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "GetM3U8 failed, no rendition can be indexed!")
+		return nil, err
+	}
+
+	if !fromManifest {
+		sort.SliceStable(renditions, func(i, j int) bool {
+			return renditions[i].MediaFileIndex.BindWidth > renditions[j].MediaFileIndex.BindWidth
+		})
+	}
+
+	return renditions, nil
+}
+
+// findRenditionURIs 查找媒体的码率版本，返回不带后缀的请求路径，以及是否来自版本清单文件
+// 版本清单文件每行一个同目录下的媒体文件名，空行及 # 开始的行忽略
+// 命名约定为 {name}_{height}p 或 {name}_{bitrate}k，{name} 对应的媒体文件存在时同样作为一个版本
+func findRenditionURIs(baseFileURINoSuffix string) ([]string, bool) {
+
+	mediaFileLocalPath, err := ts.GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return nil, false
+	}
+
+	// 本地目录及媒体名称
+	localPathNoSuffix, _ := ts.TrimMediaFileSuffix(mediaFileLocalPath)
+	localDir, name := filepath.Split(localPathNoSuffix)
+	uriDir := baseFileURINoSuffix[0 : strings.LastIndex(baseFileURINoSuffix, "/")+1]
+
+	// 版本清单文件
+	if manifest, err := os.ReadFile(localPathNoSuffix + renditionFileSuffix); err == nil {
+
+		var renditionURIs []string
+		for _, line := range strings.Split(string(manifest), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			// 只允许引用同目录下的文件
+			if strings.Contains(line, "/") || strings.Contains(line, "\\") || strings.Contains(line, "..") {
+				Log.Warn("Invalid rendition in " + localPathNoSuffix + renditionFileSuffix + ": " + line)
+				continue
+			}
+			renditionName, _ := ts.TrimMediaFileSuffix(line)
+			renditionURIs = append(renditionURIs, uriDir+renditionName)
+		}
+		return renditionURIs, true
+	}
+
+	// 按命名约定查找
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return nil, false
+	}
+
+	var hasRendition bool = false
+	var renditionNames []string
+	for _, entry := range entries {
+
+		entryName, ok := ts.TrimMediaFileSuffix(entry.Name())
+		if !ok || entry.IsDir() || !strings.HasPrefix(entryName, name) || containsName(renditionNames, entryName) {
+			continue
+		}
+
+		if entryName == name {
+			renditionNames = append(renditionNames, entryName)
+		} else if renditionLabel.MatchString(entryName[len(name):]) {
+			renditionNames = append(renditionNames, entryName)
+			hasRendition = true
+		}
+	}
+
+	// 不存在其他版本
+	if !hasRendition {
+		return nil, false
+	}
+
+	var renditionURIs []string
+	for _, renditionName := range renditionNames {
+		renditionURIs = append(renditionURIs, uriDir+renditionName)
+	}
+	return renditionURIs, false
+}
+
+// containsName 名称列表中是否已包含指定名称，同名的 .ts、.m2ts 文件只作为一个版本
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
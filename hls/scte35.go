@@ -0,0 +1,115 @@
+package hls
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ts "../ts"
+)
+
+// CueTags 是否在 EXT-X-DATERANGE 之外输出 EXT-X-CUE-OUT/EXT-X-CUE-IN
+var CueTags bool
+
+// programDateTimeBase 媒体开始时间对应的日期，媒体文件不包含绝对时间，EXT-X-DATERANGE 均以该日期为基准
+var programDateTimeBase = time.Unix(0, 0).UTC()
+
+// createSpliceTags 创建第 index 个分片之前的切点标签
+// 离开节目的切点位于所在分片之前，返回节目的切点位于所在分片之后，保证广告内容完整
+// #EXT-X-DATERANGE:ID="splice-{EVENT_ID}-{N}",START-DATE="{DATE}",PLANNED-DURATION={DURATION},SCTE35-OUT=0x{SPLICE_INFO}
+// #EXT-X-CUE-OUT:DURATION={DURATION}
+// #EXT-X-DATERANGE:ID="splice-{EVENT_ID}-{N}",START-DATE="{DATE}",DURATION={DURATION},SCTE35-IN=0x{SPLICE_INFO}
+// #EXT-X-CUE-IN
+func createSpliceTags(mediaFileIndex *ts.MediaFileIndex, videoList []VideoInfo, index int) string {
+
+	videoInfo := &videoList[index]
+
+	// 返回节目的切点所在范围为上一个分片开始之后至当前分片开始
+	var previousStartTime int64 = -1
+	if index > 0 {
+		previousStartTime = videoList[index-1].StartTime
+	}
+
+	var resultStr = ""
+	for i, point := range mediaFileIndex.SplicePoints {
+
+		if point.Type == ts.SpliceTypeOut {
+
+			if point.Time < videoInfo.StartTime || point.Time >= videoInfo.EndTime {
+				continue
+			}
+
+			resultStr += "#EXT-X-DATERANGE:ID=\"" + getSpliceID(&point, i) + "\",START-DATE=\"" + formatProgramDateTime(point.Time) + "\""
+			if point.Duration > 0 {
+				resultStr += ",PLANNED-DURATION=" + formatSpliceDuration(point.Duration)
+			}
+			resultStr += ",SCTE35-OUT=0x" + strings.ToUpper(hex.EncodeToString(point.Data)) + "\n"
+
+			if CueTags {
+				resultStr += "#EXT-X-CUE-OUT"
+				if point.Duration > 0 {
+					resultStr += ":DURATION=" + formatSpliceDuration(point.Duration)
+				}
+				resultStr += "\n"
+			}
+			continue
+		}
+
+		if point.Time <= previousStartTime || point.Time > videoInfo.StartTime {
+			continue
+		}
+
+		// 返回节目的切点与之前同一事件的离开切点使用相同的ID及开始日期
+		var outIndex int = findSpliceOut(mediaFileIndex.SplicePoints, i)
+		if outIndex >= 0 {
+			out := mediaFileIndex.SplicePoints[outIndex]
+			resultStr += "#EXT-X-DATERANGE:ID=\"" + getSpliceID(&out, outIndex) + "\",START-DATE=\"" + formatProgramDateTime(out.Time) + "\""
+			resultStr += ",DURATION=" + formatSpliceDuration(point.Time-out.Time)
+		} else {
+			resultStr += "#EXT-X-DATERANGE:ID=\"" + getSpliceID(&point, i) + "\",START-DATE=\"" + formatProgramDateTime(point.Time) + "\""
+		}
+		resultStr += ",SCTE35-IN=0x" + strings.ToUpper(hex.EncodeToString(point.Data)) + "\n"
+
+		if CueTags {
+			resultStr += "#EXT-X-CUE-IN\n"
+		}
+	}
+
+	return resultStr
+}
+
+// findSpliceOut 查找返回节目的切点之前同一事件的离开切点，不存在时返回-1
+func findSpliceOut(points []ts.SplicePoint, inIndex int) int {
+
+	var i int
+	for i = inIndex - 1; i >= 0; i-- {
+		if points[i].EventID != points[inIndex].EventID {
+			continue
+		}
+		if points[i].Type == ts.SpliceTypeOut {
+			return i
+		}
+
+		// 同一事件已经返回过节目
+		return -1
+	}
+
+	return -1
+}
+
+// getSpliceID 切点的 EXT-X-DATERANGE ID，事件ID可能被重复使用，追加切点序号
+func getSpliceID(point *ts.SplicePoint, index int) string {
+	return "splice-" + strconv.FormatUint(uint64(point.EventID), 10) + "-" + strconv.Itoa(index)
+}
+
+// formatProgramDateTime 转换为 EXT-X-PROGRAM-DATE-TIME 及 START-DATE 的日期格式
+func formatProgramDateTime(mediaTime int64) string {
+	return programDateTimeBase.Add(time.Duration(mediaTime * int64(time.Second) / ts.TimeScale)).Format("2006-01-02T15:04:05.000Z")
+}
+
+// formatSpliceDuration 转换为切点时长，单位秒
+func formatSpliceDuration(duration int64) string {
+	return fmt.Sprintf("%.3f", float64(duration)/float64(ts.TimeScale))
+}
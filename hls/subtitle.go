@@ -0,0 +1,201 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	errors "../errors"
+	ts "../ts"
+)
+
+// subtitleGroupID 一级m3u8中字幕轨道的组ID
+const subtitleGroupID string = "subs"
+
+// GetSubtitleM3U8 字幕m3u8文件获取，请求路径为 {group_name}/xxx_{track}.m3u8
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetSubtitleM3U8(m3u8FileURI string, host string, programNumber uint16) (string, error) {
+
+	// 无后缀的文件路径
+	var fileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".m3u8"), ".M3U8")
+
+	// 字幕轨道序号
+	baseFileURINoSuffix, track, err := splitSequence(fileURINoSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		Log.Error(err.Error())
+		return "", err
+	}
+
+	if track >= len(mediaFileIndex.SubtitleTracks) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetSubtitleM3U8 failed, subtitle track not exist!")
+		return "", err
+	}
+
+	return createSubtitleM3u8(mediaFileIndex, baseFileURINoSuffix, track, host, programNumber), nil
+}
+
+// GetSubtitle 字幕分片获取，请求路径为 {group_name}/xxx_{track}_{sequence}.vtt
+//
+//	programNumber 节目号，0 表示第一个节目
+func GetSubtitle(subtitleFileURI string, programNumber uint16) (string, error) {
+
+	Log.Debug("GetSubtitle, subtitleFileURI:" + subtitleFileURI)
+
+	// 无后缀的文件路径
+	var fileURINoSuffix = strings.TrimSuffix(strings.TrimSuffix(subtitleFileURI, ".vtt"), ".VTT")
+
+	// 分片序号
+	trackFileURINoSuffix, sequence, err := splitSequence(fileURINoSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	// 字幕轨道序号
+	baseFileURINoSuffix, track, err := splitSequence(trackFileURINoSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	// 获取ts索引对象
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		return "", err
+	}
+
+	if track >= len(mediaFileIndex.SubtitleTracks) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetSubtitle failed, subtitle track not exist!")
+		return "", err
+	}
+
+	// 字幕分片与视频分片一一对应
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+	if sequence >= len(videoList) {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "GetSubtitle failed, can't get subtitle file!")
+		return "", err
+	}
+
+	return createWebVtt(mediaFileIndex, track, &videoList[sequence]), nil
+}
+
+// createSubtitleM3u8 创建字幕m3u8，分片与视频分片一一对应
+// #EXTM3U
+// #EXT-X-VERSION:4
+// #EXT-X-TARGETDURATION:{MAX_DURATION}
+// #EXT-X-MEDIA-SEQUENCE:0
+// #EXT-X-PLAYLIST-TYPE:VOD
+// #EXTINF:6.006,
+// http://host:port/subtitle/{group_name}/xxx_{track}_{sequence}.vtt
+// #EXT-X-ENDLIST
+func createSubtitleM3u8(mediaFileIndex *ts.MediaFileIndex, baseFileURINoSuffix string, track int, host string, programNumber uint16) string {
+
+	Log.Debug(">>> GetSubtitleM3u8 Start: " + baseFileURINoSuffix + ", track: " + fmt.Sprint(track))
+
+	// m3u8 文件内容
+	var resultStr = ""
+
+	// 获取文件列表
+	videoList := GetVideoList(mediaFileIndex, float64(TargetDuration))
+
+	resultStr += "#EXTM3U\n"
+	resultStr += "#EXT-X-VERSION:4 \n"
+	resultStr += "#EXT-X-TARGETDURATION:" + strconv.Itoa(getTargetDuration(videoList)) + "\n"
+	resultStr += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	resultStr += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+
+	trackStr := strconv.Itoa(track)
+	var i int
+	for i = 0; i < len(videoList); i++ {
+
+		// #EXT-X-DISCONTINUITY
+		if videoList[i].Discontinuity {
+			resultStr += "#EXT-X-DISCONTINUITY\n"
+		}
+
+		// #EXTINF:6.006,
+		resultStr += "#EXTINF:" + formatDuration(videoList[i].Duration) + ",\n"
+
+		sequenceStr := strconv.FormatUint(uint64(videoList[i].Sequence), 10)
+		resultStr += getMediaURL(host, "/subtitle/", baseFileURINoSuffix, "_"+trackStr+"_"+sequenceStr+".vtt") + getProgramQuery(programNumber) + "\n"
+	}
+
+	// #EXT-X-ENDLIST
+	resultStr += "#EXT-X-ENDLIST"
+
+	Log.Debug("<<< GetSubtitleM3u8 End")
+	return resultStr
+}
+
+// createWebVtt 创建字幕分片，包含与视频分片时间重叠的字幕条目
+// WEBVTT
+// X-TIMESTAMP-MAP=MPEGTS:{START_PTS},LOCAL:00:00:00.000
+//
+// 00:00:01.000 --> 00:00:03.000
+// text
+func createWebVtt(mediaFileIndex *ts.MediaFileIndex, track int, videoInfo *VideoInfo) string {
+
+	// 字幕时间从媒体开始时计时，对应媒体开始时的时间戳，fMP4 分片时对应 fMP4 时间轴上的媒体开始时间
+	var startPts int64 = mediaFileIndex.StartPts
+	if UseFmp4(mediaFileIndex) {
+		startPts = fmp4TimeOffset
+	}
+
+	var resultStr = "WEBVTT\n"
+	resultStr += "X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(startPts, 10) + ",LOCAL:00:00:00.000\n"
+
+	for _, cue := range mediaFileIndex.SubtitleCues {
+
+		if cue.Track != track || cue.EndTime <= videoInfo.StartTime || cue.StartTime >= videoInfo.EndTime {
+			continue
+		}
+
+		resultStr += "\n"
+		resultStr += formatVttTime(cue.StartTime) + " --> " + formatVttTime(cue.EndTime) + "\n"
+		resultStr += escapeVttText(cue.Text) + "\n"
+	}
+
+	return resultStr
+}
+
+// getSubtitleName 字幕轨道名称，语言及 teletext 页号
+func getSubtitleName(track *ts.SubtitleTrack) string {
+	if track.Language == "" {
+		return "Teletext " + track.PageNumber()
+	}
+	return track.Language + " " + track.PageNumber()
+}
+
+// formatVttTime 转换为 WebVTT 时间格式 hh:mm:ss.ttt
+func formatVttTime(time int64) string {
+	millisecond := time * 1000 / ts.TimeScale
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millisecond/3600000, millisecond/60000%60, millisecond/1000%60, millisecond%1000)
+}
+
+// escapeVttText 转义字幕文本中的特殊字符，去除空行
+func escapeVttText(text string) string {
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+	return strings.Replace(text, "\n\n", "\n", -1)
+}
+
+// splitSequence 拆分请求路径末尾的序号，例如 {group_name}/xxx_1 拆分为 {group_name}/xxx 和 1
+func splitSequence(fileURINoSuffix string) (string, int, error) {
+
+	if strings.LastIndex(fileURINoSuffix, "_") < strings.Index(fileURINoSuffix, "/") {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "Can't get sequence from url!")
+		return "", 0, err
+	}
+
+	sequence, err := strconv.Atoi(fileURINoSuffix[strings.LastIndex(fileURINoSuffix, "_")+1:])
+	if err != nil || sequence < 0 {
+		err := errors.NewError(errors.ErrorCodeGetStreamFailed, "Can't get sequence from url!")
+		return "", 0, err
+	}
+
+	return fileURINoSuffix[0:strings.LastIndex(fileURINoSuffix, "_")], sequence, nil
+}
@@ -10,16 +10,35 @@ import (
 
 // Folder 媒体本地文件夹爱
 type Folder struct {
-	LocalPath string // 媒体本地文件夹
-	GroupName string // 映射到url的文件夹
+	LocalPath   string // 媒体本地文件夹
+	GroupName   string // 映射到url的文件夹
+	PkgMode     string // 非188字节包(M2TS/204字节)的输出方式
+	Encryption  string // 分片加密方式
+	KeyRotation int    // 每个密钥使用的分片数，0 表示整个媒体文件使用同一个密钥
 }
 
+// 非188字节包的输出方式
+const (
+	PkgModeStrip       = "strip"       // 转换为188字节的ts包输出（默认）
+	PkgModePassthrough = "passthrough" // 按源文件原样输出
+)
+
+// 分片加密方式
+const (
+	EncryptionNone      = "none"       // 不加密（默认）
+	EncryptionAes128    = "aes-128"    // AES-128 CBC 整片加密
+	EncryptionSampleAes = "sample-aes" // SAMPLE-AES，只加密 H.264 及 AAC 的样本数据
+)
+
 // Log 系统日志
 var Log *ezlog.Log
 
 // IndexFileFolder 索引文件存放目录
 var IndexFileFolder string
 
+// KeyFileFolder 密钥文件存放目录
+var KeyFileFolder string
+
 // 媒体文件存放目录集合
 var MediaFileFolders map[string]Folder
 
@@ -41,6 +60,12 @@ func LoadPath() {
 	}
 	Log.Info("Load index_file_folder complete! index_file_folder: " + IndexFileFolder)
 
+	// 密钥文件目录，只有分组启用加密时需要
+	KeyFileFolder, err = config.SysConfig.Get("path.key_file_folder")
+	if err != nil {
+		KeyFileFolder = ""
+	}
+
 	Log.Info("Loading media_file_folders")
 
 	count, err := config.SysConfig.Count("path.media_file_folders")
@@ -61,13 +86,47 @@ func LoadPath() {
 			panic(err.Error())
 		}
 
+		// 未配置时默认转换为188字节的ts包
+		pkgMode, err := config.SysConfig.Get("path.media_file_folders[" + strconv.Itoa(i) + "].packet_mode")
+		if err != nil || pkgMode == "" {
+			pkgMode = PkgModeStrip
+		}
+		if pkgMode != PkgModeStrip && pkgMode != PkgModePassthrough {
+			panic("Unsupported packet_mode: " + pkgMode + ", group_name: " + groupName)
+		}
+
+		// 未配置时不加密
+		encryption, err := config.SysConfig.Get("path.media_file_folders[" + strconv.Itoa(i) + "].encryption")
+		if err != nil || encryption == "" {
+			encryption = EncryptionNone
+		}
+		if encryption != EncryptionNone && encryption != EncryptionAes128 && encryption != EncryptionSampleAes {
+			panic("Unsupported encryption: " + encryption + ", group_name: " + groupName)
+		}
+		if encryption != EncryptionNone && KeyFileFolder == "" {
+			panic("key_file_folder is required by encryption, group_name: " + groupName)
+		}
+
+		// 密钥轮换周期，未配置时不轮换
+		var keyRotation int
+		keyRotationStr, err := config.SysConfig.Get("path.media_file_folders[" + strconv.Itoa(i) + "].key_rotation")
+		if err == nil && keyRotationStr != "" {
+			keyRotation, err = strconv.Atoi(keyRotationStr)
+			if err != nil || keyRotation < 0 {
+				panic("Wrong key_rotation: " + keyRotationStr + ", group_name: " + groupName)
+			}
+		}
+
 		var f Folder
 		f.LocalPath = localPath
 		f.GroupName = groupName
+		f.PkgMode = pkgMode
+		f.Encryption = encryption
+		f.KeyRotation = keyRotation
 
 		MediaFileFolders[groupName] = f
 
-		Log.Info("Watch localPath: " + localPath + ", group_name:" + groupName)
+		Log.Info("Watch localPath: " + localPath + ", group_name:" + groupName + ", packet_mode:" + pkgMode + ", encryption:" + encryption)
 	}
 
 	Log.Info("Load media_file_folders complete!")
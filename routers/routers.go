@@ -1,6 +1,7 @@
 package routers
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"os"
@@ -33,8 +34,31 @@ func Init() {
 	M3u8Host = host
 }
 
-// GetM3U8 M3U8文件获取
+// GetM3U8 一级M3U8文件获取
 func GetM3U8(w http.ResponseWriter, r *http.Request) {
+	writeM3U8(w, r, "/hls/", hls.GetM3U8)
+}
+
+// GetSubM3U8 二级M3U8文件获取
+func GetSubM3U8(w http.ResponseWriter, r *http.Request) {
+	writeM3U8(w, r, "/hls_sub/", hls.GetSubM3U8)
+}
+
+// GetSubtitleM3U8 字幕M3U8文件获取
+func GetSubtitleM3U8(w http.ResponseWriter, r *http.Request) {
+	writeM3U8(w, r, "/hls_subtitle/", hls.GetSubtitleM3U8)
+}
+
+// GetAudioM3U8 音频M3U8文件获取
+func GetAudioM3U8(w http.ResponseWriter, r *http.Request) {
+	writeM3U8(w, r, "/hls_audio/", hls.GetAudioM3U8)
+}
+
+// writeM3U8 输出M3U8文件
+//
+//	routePrefix 路由前缀
+//	creator m3u8文件生成方法
+func writeM3U8(w http.ResponseWriter, r *http.Request, routePrefix string, creator func(string, string, uint16) (string, error)) {
 
 	var url = r.URL.Path
 	Log.Debug(">>>>>>>>>>> Request url:" + url)
@@ -47,8 +71,17 @@ func GetM3U8(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	// 获取m3u8文件
-	m3u8, err := hls.GetM3U8(strings.Replace(r.URL.Path, "/hls/", "", 1), M3u8Host)
+	m3u8, err := creator(strings.Replace(r.URL.Path, routePrefix, "", 1), M3u8Host, programNumber)
 	if err != nil {
 		w.WriteHeader(404)
 		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
@@ -61,8 +94,152 @@ func GetM3U8(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(m3u8))
 }
 
+// GetIFrameM3U8 I帧M3U8文件获取
+func GetIFrameM3U8(w http.ResponseWriter, r *http.Request) {
+	writeM3U8(w, r, "/hls_iframe/", hls.GetIFrameM3U8)
+}
+
 // GetVideoStream 视频文件获取
 func GetVideoStream(w http.ResponseWriter, r *http.Request) {
+	writeStream(w, r, "/video/", hls.GetVideoStream)
+}
+
+// GetAudioStream 音频文件获取
+func GetAudioStream(w http.ResponseWriter, r *http.Request) {
+	writeStream(w, r, "/audio/", hls.GetAudioStream)
+}
+
+// GetFmp4Stream fMP4 分片获取
+func GetFmp4Stream(w http.ResponseWriter, r *http.Request) {
+	writeFmp4(w, r, "/fmp4/", "video/mp4", hls.GetFmp4Segment)
+}
+
+// GetFmp4AudioStream fMP4 音频分片获取
+func GetFmp4AudioStream(w http.ResponseWriter, r *http.Request) {
+	writeFmp4(w, r, "/fmp4_audio/", "audio/mp4", hls.GetFmp4AudioSegment)
+}
+
+// GetIFrameInit I帧播放列表的初始化分片获取
+func GetIFrameInit(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + url)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 非ts请求，返回404
+	if !strings.HasSuffix(url, ".ts") {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!"))
+		return
+	}
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 生成初始化分片
+	segment, err := hls.GetIFrameInit(strings.Replace(r.URL.Path, "/iframe/", "", 1), programNumber)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment)))
+	w.Write(segment)
+}
+
+// GetKey 分片密钥获取
+func GetKey(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + url)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 非key请求，返回404
+	if !strings.HasSuffix(url, ".key") {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!"))
+		return
+	}
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 获取密钥
+	key, err := hls.GetKey(strings.Replace(r.URL.Path, "/key/", "", 1), programNumber)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+	w.Write(key)
+}
+
+// writeFmp4 输出 fMP4 初始化分片或媒体分片
+//
+//	routePrefix 路由前缀
+//	contentType 返回的内容类型
+//	creator 分片生成方法
+func writeFmp4(w http.ResponseWriter, r *http.Request, routePrefix string, contentType string, creator func(string, uint16) ([]byte, error)) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + url)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 非 mp4/m4s 请求，返回404
+	if !(strings.HasSuffix(url, ".mp4") || strings.HasSuffix(url, ".m4s")) {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!"))
+		return
+	}
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 生成分片
+	segment, err := creator(strings.Replace(r.URL.Path, routePrefix, "", 1), programNumber)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment)))
+	w.Write(segment)
+}
+
+// writeStream 输出媒体分片
+//
+//	routePrefix 路由前缀
+//	seeker 分片信息查找方法
+func writeStream(w http.ResponseWriter, r *http.Request, routePrefix string, seeker func(string, uint16) (*hls.VideoInfo, string, error)) {
 
 	var url = r.URL.Path
 	Log.Debug(">>>>>>>>>>> Request url:" + url)
@@ -75,8 +252,23 @@ func GetVideoStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	// 获取视频文件信息
-	videoInfo, realMediaLocalPath, err := hls.GetVideoStream(strings.Replace(r.URL.Path, "/video/", "", 1))
+	videoInfo, realMediaLocalPath, err := seeker(strings.Replace(r.URL.Path, routePrefix, "", 1), programNumber)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
 
 	// 打开文件
 	file, err := os.Open(realMediaLocalPath)
@@ -102,19 +294,60 @@ func GetVideoStream(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Last-Modified", fileStat.ModTime().Format(http.TimeFormat))
 	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
 	w.Header().Set("Content-Type", "video/MP2T")
-	w.Header().Set("Content-Length", strconv.FormatUint(videoInfo.Size, 10))
+
+	// 按PID过滤或转换为188字节的ts包后输出，SAMPLE-AES 加密时同样需要188字节的ts包
+	if videoInfo.Filter != nil || videoInfo.ConvertToTs || videoInfo.SampleAes != nil {
+		writeConvertedStream(w, file, fileStat.Size(), videoInfo)
+		file.Close()
+		return
+	}
 
 	file.Seek(int64(videoInfo.StartOffset), 0)
 
+	// 预先读取分片开始部分的数据，用于确定插入的pat、pmt的连续计数器
+	buf := make([]byte, min(1024*1024*10, fileStat.Size()))
+	n, err := io.ReadFull(file, buf[0:min(int64(len(buf)), int64(videoInfo.Size))])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!\n"))
+		w.Write([]byte(err.Error()))
+		file.Close()
+		return
+	}
+	err = nil
+
+	// 分片开始处插入pat、pmt
+	psiPackets := ts.CreatePsiPackets(videoInfo.Psi, buf[0:n], videoInfo.PkgSize)
+	contentLength := uint64(len(psiPackets)) + videoInfo.Size
+
+	// 分组启用加密时边读取边加密
+	var out io.Writer = w
+	var encrypter *hls.SegmentEncrypter
+	if videoInfo.Key != nil {
+		encrypter, err = hls.NewSegmentEncrypter(w, videoInfo.Key, videoInfo.IV)
+		if err != nil {
+			w.WriteHeader(404)
+			w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+			w.Write([]byte(err.Error()))
+			file.Close()
+			return
+		}
+		out = encrypter
+		contentLength = hls.EncryptedSize(contentLength)
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatUint(contentLength, 10))
+	out.Write(psiPackets)
+
 	// Stream data out !
 	var tranceSize uint64
 
-	buf := make([]byte, min(1024*1024*10, fileStat.Size()))
-	n := 0
-
 	for err == nil {
 
-		n, err = file.Read(buf)
+		// 第一次使用预先读取的数据
+		if tranceSize > 0 {
+			n, err = file.Read(buf)
+		}
 
 		// 读取文件失败
 		if err != nil {
@@ -126,19 +359,200 @@ func GetVideoStream(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		// 预先读取的数据为空时，文件已结束
+		if n == 0 {
+			break
+		}
+
 		if tranceSize+uint64(n) > videoInfo.Size {
-			w.Write(buf[0 : videoInfo.Size-tranceSize])
+			out.Write(buf[0 : videoInfo.Size-tranceSize])
 			tranceSize += videoInfo.Size - tranceSize
 			break
 		} else {
-			w.Write(buf[0:n])
+			out.Write(buf[0:n])
 			tranceSize += uint64(n)
 		}
 	}
 
+	// 输出最后一个块及填充
+	if encrypter != nil {
+		encrypter.Close()
+	}
+
 	file.Close()
 }
 
+// writeConvertedStream 按PID过滤或转换为188字节的ts包，通过管道边转换边输出，输出长度未知，不设置 Content-Length
+// 预先读取转换结果的开始部分用于确定插入的pat、pmt的连续计数器，SAMPLE-AES 只缓存尚未结束的pes
+//
+//	fileSize 媒体文件大小
+func writeConvertedStream(w http.ResponseWriter, file *os.File, fileSize int64, videoInfo *hls.VideoInfo) {
+
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeReader.Close()
+
+	go func() {
+		var err error
+		if videoInfo.Filter != nil {
+
+			// 按pes边界对齐时需要读取分片之后的数据
+			var readSize int64 = int64(videoInfo.Size)
+			if videoInfo.Filter.AlignPID >= 0 {
+				readSize = fileSize - int64(videoInfo.StartOffset)
+			}
+			err = ts.FilterTs(io.NewSectionReader(file, int64(videoInfo.StartOffset), readSize), pipeWriter, videoInfo.Filter)
+		} else {
+			err = ts.ConvertToTs(io.NewSectionReader(file, int64(videoInfo.StartOffset), int64(videoInfo.Size)), pipeWriter)
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	// 预先读取转换结果的开始部分，pat 通常每隔 100ms 重复一次
+	buf := make([]byte, min(1024*1024, int64(videoInfo.Size)+int64(ts.TsPkgSize)))
+	n, err := io.ReadFull(pipeReader, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 分组启用加密时边转换边加密整个分片
+	var out io.Writer = w
+	var encrypter *hls.SegmentEncrypter
+	if videoInfo.Key != nil {
+		encrypter, err = hls.NewSegmentEncrypter(w, videoInfo.Key, videoInfo.IV)
+		if err != nil {
+			w.WriteHeader(404)
+			w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		out = encrypter
+	}
+
+	// SAMPLE-AES 按pes加密样本数据
+	var sampleAesWriter *ts.SampleAesWriter
+	if videoInfo.SampleAes != nil {
+		sampleAesWriter, err = ts.NewSampleAesWriter(out, videoInfo.SampleAes)
+		if err != nil {
+			w.WriteHeader(404)
+			w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		out = sampleAesWriter
+	}
+
+	// 分片开始处插入pat、pmt
+	out.Write(ts.CreatePsiPackets(videoInfo.Psi, buf[0:n], ts.TsPkgSize))
+	out.Write(buf[0:n])
+
+	// 已输出响应头，转换失败时只能结束输出
+	_, err = io.CopyBuffer(out, pipeReader, buf)
+	if err != nil {
+		Log.Warn("Write converted stream failed, " + err.Error())
+		return
+	}
+
+	// 输出最后的pes及加密填充
+	if sampleAesWriter != nil {
+		sampleAesWriter.Close()
+	}
+	if encrypter != nil {
+		encrypter.Close()
+	}
+}
+
+// GetMediaFile 源媒体文件获取，支持 Range 请求，用于字节范围(EXT-X-BYTERANGE)引用的分片及I帧
+func GetMediaFile(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + url)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 非ts请求，返回404
+	if !(strings.HasSuffix(url, ".ts")) {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!"))
+		return
+	}
+
+	// 未启用字节范围及I帧播放列表，或加密的分组只能通过 /video/ 获取分片
+	baseFileURINoSuffix := strings.TrimSuffix(strings.Replace(url, "/media/", "", 1), ".ts")
+	if !(hls.ByteRange || hls.IFramePlaylist) || hls.IsEncrypted(baseFileURINoSuffix) {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!"))
+		return
+	}
+
+	// 真实媒体文件路径，后缀可能为 .m2ts、.mts
+	realMediaLocalPath, err := ts.GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	file, err := os.Open(realMediaLocalPath)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer file.Close()
+
+	fileStat, err := file.Stat()
+	if err != nil || fileStat.IsDir() {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!"))
+		return
+	}
+
+	// 处理 Range、If-Modified-Since 等请求头
+	w.Header().Set("Content-Type", "video/MP2T")
+	http.ServeContent(w, r, fileStat.Name(), fileStat.ModTime(), file)
+}
+
+// GetSubtitle 字幕分片获取
+func GetSubtitle(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + url)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// 非vtt请求，返回404
+	if !(strings.HasSuffix(url, ".vtt") || strings.HasSuffix(url, ".VTT")) {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: Unsurported file type!"))
+		return
+	}
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 获取字幕分片
+	vtt, err := hls.GetSubtitle(strings.Replace(r.URL.Path, "/subtitle/", "", 1), programNumber)
+	if err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte("ERROR 404: The file requested is not exist!\n"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 返回字幕分片内容
+	w.Header().Set("Content-Type", "text/vtt;charset=UTF-8")
+	w.Write([]byte(vtt))
+}
+
 // CreateIndex 主动创建索引
 func CreateIndex(w http.ResponseWriter, r *http.Request) {
 
@@ -153,12 +567,18 @@ func CreateIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m3u8FileURI := strings.Replace(r.URL.Path, "/createIndex/", "", 1)
+	m3u8FileURI := strings.Replace(r.URL.Path, "/api/create_index/", "", 1)
 	baseFileURINoSuffix := strings.TrimSuffix(strings.TrimSuffix(m3u8FileURI, ".ts"), ".Ts")
-	indexFileURI := baseFileURINoSuffix + ".tsidx"
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Wrong program number!\"}"))
+		return
+	}
 
 	// 获取m3u8文件
-	err := ts.CreateMediaFileIndex(indexFileURI)
+	err = ts.CreateMediaFileIndex(baseFileURINoSuffix, programNumber)
 	if err != nil {
 		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Create index failed! ,erros: " + err.Error() + "\"}"))
 		return
@@ -167,6 +587,146 @@ func CreateIndex(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("{\"code\":\"1\",\"msg\":\"\"}"))
 }
 
+// GetMediaInfo 获取媒体文件信息
+func GetMediaInfo(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// 非ts请求，返回错误
+	if !(strings.HasSuffix(url, ".ts") || strings.HasSuffix(url, ".Ts")) {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Unsurported file type!\"}"))
+		return
+	}
+
+	mediaFileURI := strings.Replace(r.URL.Path, "/api/get_media_info/", "", 1)
+	baseFileURINoSuffix := strings.TrimSuffix(strings.TrimSuffix(mediaFileURI, ".ts"), ".Ts")
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Wrong program number!\"}"))
+		return
+	}
+
+	// 获取ts索引对象，不存在时创建
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":" + jsonString("Get media info failed! ,erros: "+err.Error()) + "}"))
+		return
+	}
+
+	var resultJson string
+
+	resultJson += "{\"code\":\"1\","
+	resultJson += "\"info\":{"
+	resultJson += "\"programNumber\":" + strconv.FormatUint(uint64(mediaFileIndex.ProgramNumber), 10) + ","
+	resultJson += "\"duration\":" + strconv.FormatUint(uint64(mediaFileIndex.Duration), 10) + ","
+	resultJson += "\"bandwidth\":" + strconv.FormatUint(uint64(mediaFileIndex.BindWidth)*8, 10) + ","
+	resultJson += "\"fileSize\":\"" + formatFileSize(int64(mediaFileIndex.VideoSize)) + "\","
+	resultJson += "\"codecs\":" + jsonString(hls.GetCodecs(mediaFileIndex)) + ","
+	resultJson += "\"resolution\":" + jsonString(hls.GetResolution(mediaFileIndex)) + ","
+	resultJson += "\"frameRate\":" + strconv.FormatFloat(mediaFileIndex.VideoCodec.FrameRate, 'f', 3, 64) + ","
+	resultJson += "\"sampleRate\":" + strconv.FormatUint(uint64(mediaFileIndex.AudioCodec.SampleRate), 10) + ","
+	resultJson += "\"channels\":" + strconv.FormatUint(uint64(mediaFileIndex.AudioCodec.Channels), 10) + ","
+	resultJson += "\"audioOnly\":" + strconv.FormatBool(mediaFileIndex.IsAudioOnly()) + ","
+	resultJson += "\"serviceID\":" + strconv.FormatUint(uint64(mediaFileIndex.Service.ServiceID), 10) + ","
+	resultJson += "\"serviceType\":" + strconv.FormatUint(uint64(mediaFileIndex.Service.ServiceType), 10) + ","
+	resultJson += "\"providerName\":" + jsonString(mediaFileIndex.Service.ProviderName) + ","
+	resultJson += "\"serviceName\":" + jsonString(mediaFileIndex.Service.ServiceName)
+	resultJson += "}}"
+
+	w.Write([]byte(resultJson))
+}
+
+// GetCorruptionReport 获取媒体文件损坏情况及传输错误统计
+func GetCorruptionReport(w http.ResponseWriter, r *http.Request) {
+
+	var url = r.URL.Path
+	Log.Debug(">>>>>>>>>>> Request url:" + r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// 非ts请求，返回错误
+	if !(strings.HasSuffix(url, ".ts") || strings.HasSuffix(url, ".Ts")) {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Unsurported file type!\"}"))
+		return
+	}
+
+	mediaFileURI := strings.Replace(r.URL.Path, "/api/get_corruption_report/", "", 1)
+	baseFileURINoSuffix := strings.TrimSuffix(strings.TrimSuffix(mediaFileURI, ".ts"), ".Ts")
+
+	// 节目号
+	programNumber, err := getProgramNumber(r)
+	if err != nil {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":\"Wrong program number!\"}"))
+		return
+	}
+
+	// 获取ts索引对象，不存在时创建
+	mediaFileIndex, err := ts.GetMediaFileIndex(baseFileURINoSuffix, programNumber)
+	if err != nil {
+		w.Write([]byte("{\"code\":\"-1\",\"msg\":" + jsonString("Get corruption report failed! ,erros: "+err.Error()) + "}"))
+		return
+	}
+
+	var skippedSize uint64
+	var rangesJson string
+	for i, corruptedRange := range mediaFileIndex.CorruptedRanges {
+
+		skippedSize += corruptedRange.Size
+
+		rangesJson += "{"
+		rangesJson += "\"offset\":" + strconv.FormatUint(corruptedRange.StartOffset, 10) + ","
+		rangesJson += "\"size\":" + strconv.FormatUint(corruptedRange.Size, 10)
+		rangesJson += "}"
+
+		if i < (len(mediaFileIndex.CorruptedRanges) - 1) {
+			rangesJson += ","
+		}
+	}
+
+	var pidsJson string
+	for i, pidStatistics := range mediaFileIndex.Statistics.PIDs {
+
+		pidsJson += "{"
+		pidsJson += "\"pid\":" + strconv.FormatUint(uint64(pidStatistics.PID), 10) + ","
+		pidsJson += "\"ccErrorCount\":" + strconv.FormatUint(uint64(pidStatistics.CCErrorCount), 10) + ","
+		pidsJson += "\"duplicateCount\":" + strconv.FormatUint(uint64(pidStatistics.DuplicateCount), 10) + ","
+		pidsJson += "\"teiCount\":" + strconv.FormatUint(uint64(pidStatistics.TEICount), 10) + ","
+		pidsJson += "\"scrambledCount\":" + strconv.FormatUint(uint64(pidStatistics.ScrambledCount), 10)
+		pidsJson += "}"
+
+		if i < (len(mediaFileIndex.Statistics.PIDs) - 1) {
+			pidsJson += ","
+		}
+	}
+
+	statistics := mediaFileIndex.Statistics
+
+	var resultJson string
+
+	resultJson += "{\"code\":\"1\","
+	resultJson += "\"report\":{"
+	resultJson += "\"damaged\":" + strconv.FormatBool(mediaFileIndex.IsDamaged()) + ","
+	resultJson += "\"fileSize\":" + strconv.FormatUint(mediaFileIndex.VideoSize, 10) + ","
+	resultJson += "\"skippedSize\":" + strconv.FormatUint(skippedSize, 10) + ","
+	resultJson += "\"crcErrorCount\":" + strconv.FormatUint(uint64(statistics.CRCErrorCount), 10) + ","
+	resultJson += "\"sectionErrorCount\":" + strconv.FormatUint(uint64(statistics.SectionErrorCount), 10) + ","
+	resultJson += "\"errorPacketCount\":" + strconv.FormatUint(uint64(statistics.ErrorPacketCount), 10) + ","
+	resultJson += "\"ccErrorCount\":" + strconv.FormatUint(uint64(statistics.CCErrorCount), 10) + ","
+	resultJson += "\"duplicateCount\":" + strconv.FormatUint(uint64(statistics.DuplicateCount), 10) + ","
+	resultJson += "\"teiCount\":" + strconv.FormatUint(uint64(statistics.TEICount), 10) + ","
+	resultJson += "\"scrambledCount\":" + strconv.FormatUint(uint64(statistics.ScrambledCount), 10) + ","
+	resultJson += "\"skippedRanges\":[" + rangesJson + "],"
+	resultJson += "\"pids\":[" + pidsJson + "]"
+	resultJson += "}}"
+
+	w.Write([]byte(resultJson))
+}
+
 // GetProcessInfo 获取索引进度
 func GetProcessInfo(w http.ResponseWriter, r *http.Request) {
 
@@ -211,6 +771,30 @@ func formatFileSize(fileSize int64) (size string) {
 	}
  }
 
+// getProgramNumber 获取请求参数中的节目号，未指定时为0，表示第一个节目
+func getProgramNumber(r *http.Request) (uint16, error) {
+
+	programStr := r.URL.Query().Get("program")
+	if programStr == "" {
+		return 0, nil
+	}
+
+	programNumber, err := strconv.ParseUint(programStr, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(programNumber), nil
+}
+
+// jsonString 转换为JSON字符串，名称等外部文本可能包含需要转义的字符
+func jsonString(str string) string {
+	data, err := json.Marshal(str)
+	if err != nil {
+		return "\"\""
+	}
+	return string(data)
+}
+
 func min(x int64, y int64) int64 {
 	if x < y {
 		return x
@@ -0,0 +1,181 @@
+package ts
+
+import (
+	"fmt"
+)
+
+// AudioCodecInfo 音频编码信息
+type AudioCodecInfo struct {
+	StreamType uint8  //8 流类型，0 表示未知
+	ObjectType uint8  //8 AAC audio object type，0 表示未知
+	SampleRate uint32 //24 采样率，0 表示未知
+	Channels   uint8  //8 声道数，0 表示未知
+	Layer      uint8  //8 MPEG-1/MPEG-2 音频层，1~3，0 表示未知
+}
+
+// adtsSampleRates ADTS 采样率索引对应的采样率
+var adtsSampleRates = [13]uint32{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ParseAdtsHeader 解析 ADTS 帧头，提取 AAC 编码信息
+// syncword(12),ID(1),layer(2),protection_absent(1),profile(2),sampling_frequency_index(4),
+// private_bit(1),channel_configuration(3)...
+func ParseAdtsHeader(data []byte) (AudioCodecInfo, bool) {
+
+	var info AudioCodecInfo
+	if len(data) < 7 || data[0] != 0xFF || data[1]&0xF0 != 0xF0 {
+		return info, false
+	}
+
+	sampleRateIndex := data[2] >> 2 & 0xF
+	if int(sampleRateIndex) >= len(adtsSampleRates) {
+		return info, false
+	}
+
+	// profile 为 audio object type 减1
+	info.StreamType = StreamTypeAAC
+	info.ObjectType = data[2]>>6&0x3 + 1
+	info.SampleRate = adtsSampleRates[sampleRateIndex]
+	info.Channels = (data[2]&0x1)<<2 | data[3]>>6&0x3
+
+	// channel_configuration 为 7 时为 7.1 声道
+	if info.Channels == 7 {
+		info.Channels = 8
+	}
+
+	return info, true
+}
+
+// ParseMpegAudioHeader 查找第一个 MPEG-1/MPEG-2 音频帧头，提取音频层
+// syncword(11),version(2),layer(2),protection_bit(1),bitrate_index(4),sampling_frequency(2)...
+// layer 为 01 时为 Layer III，10 时为 Layer II，11 时为 Layer I
+func ParseMpegAudioHeader(data []byte, streamType uint8) (AudioCodecInfo, bool) {
+
+	var info AudioCodecInfo
+	var i int
+	for i = 0; i+4 <= len(data); i++ {
+
+		if data[i] != 0xFF || data[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+
+		// version 01、layer 00、bitrate_index 1111、sampling_frequency 11 为保留值
+		layer := data[i+1] >> 1 & 0x3
+		if data[i+1]>>3&0x3 == 0x1 || layer == 0 || data[i+2]>>4 == 0xF || data[i+2]>>2&0x3 == 0x3 {
+			continue
+		}
+
+		info.StreamType = streamType
+		info.Layer = 4 - layer
+		return info, true
+	}
+
+	return info, false
+}
+
+// AudioSpecificConfig 计算 AAC 的 AudioSpecificConfig(ISO/IEC 14496-3)，未知的编码信息按 AAC-LC 处理
+// audioObjectType(5),samplingFrequencyIndex(4),channelConfiguration(4),GASpecificConfig(3)
+func (info *AudioCodecInfo) AudioSpecificConfig() []byte {
+
+	var objectType uint8 = info.ObjectType
+	if objectType == 0 {
+		objectType = 2
+	}
+
+	// 未知的采样率使用 44100
+	var sampleRateIndex uint8 = 4
+	for i, sampleRate := range adtsSampleRates {
+		if sampleRate == info.SampleRate {
+			sampleRateIndex = uint8(i)
+		}
+	}
+
+	// 7.1 声道的 channel_configuration 为 7
+	var channelConfig uint8 = info.Channels
+	if channelConfig == 8 {
+		channelConfig = 7
+	}
+
+	return []byte{objectType<<3 | sampleRateIndex>>1, sampleRateIndex<<7 | channelConfig<<3}
+}
+
+// SplitAdtsFrames 拆分 ADTS 格式的 AAC 数据，返回不含帧头的原始数据块，损坏的数据被丢弃
+// 每个 ADTS 帧只包含一个原始数据块
+func SplitAdtsFrames(data []byte) [][]byte {
+
+	var frames [][]byte = make([][]byte, 0)
+
+	var pos int = 0
+	for pos+7 <= len(data) {
+
+		if data[pos] != 0xFF || data[pos+1]&0xF0 != 0xF0 {
+			pos++
+			continue
+		}
+
+		// protection_absent 为 0 时帧头之后有2字节的CRC
+		headerLength := 7
+		if data[pos+1]&0x1 == 0 {
+			headerLength = 9
+		}
+
+		// aac_frame_length(13) 包含帧头
+		frameLength := int(data[pos+3]&0x3)<<11 | int(data[pos+4])<<3 | int(data[pos+5])>>5
+		if frameLength <= headerLength || pos+frameLength > len(data) {
+			break
+		}
+
+		frames = append(frames, data[pos+headerLength:pos+frameLength])
+		pos += frameLength
+	}
+
+	return frames
+}
+
+// descriptorTagLanguage ISO_639_language_descriptor 标签
+const descriptorTagLanguage uint8 = 0x0A
+
+// ISO_639_language_descriptor 中的音频类型
+const (
+	AudioTypeUndefined                uint8 = 0x00 // 未定义
+	AudioTypeCleanEffects             uint8 = 0x01 // 无对白音效
+	AudioTypeHearingImpaired          uint8 = 0x02 // 听障
+	AudioTypeVisualImpairedCommentary uint8 = 0x03 // 视障解说
+)
+
+// AudioTrack 音频轨道
+type AudioTrack struct {
+	PID        uint16 // 音频流PID
+	StreamType uint8  // 音频流类型
+	Language   string // ISO 639 语言代码，未知时为空
+	AudioType  uint8  // 音频类型
+}
+
+// getAudioTracks 获取pmt中的音频轨道，按pmt中的顺序排列，第一个为默认音频
+func getAudioTracks(pmt *Pmt) []AudioTrack {
+
+	var tracks []AudioTrack = make([]AudioTrack, 0)
+	for i := range pmt.Streams {
+
+		s := &pmt.Streams[i]
+		audioType := getAudioStreamType(s)
+		if audioType == 0 {
+			continue
+		}
+
+		var track AudioTrack
+		track.PID = s.ElementaryPID
+		track.StreamType = audioType
+
+		// ISO_639_language_code(24),audio_type(8)，存在多个语言时取第一个
+		descriptor := findDescriptor(s.Descriptors, descriptorTagLanguage)
+		if len(descriptor) >= 4 {
+			track.Language = getLanguageCode(descriptor[0:3])
+			track.AudioType = descriptor[3]
+		}
+		tracks = append(tracks, track)
+
+		Log.Debug("识别到音频轨道，PID：" + fmt.Sprint(track.PID) + "，语言：" + track.Language)
+	}
+
+	return tracks
+}
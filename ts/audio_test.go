@@ -0,0 +1,33 @@
+package ts
+
+import "testing"
+
+// TestParseMpegAudioHeader 校验从 MPEG 音频帧头中解析音频层
+func TestParseMpegAudioHeader(t *testing.T) {
+
+	var cases = []struct {
+		name  string
+		data  []byte
+		ok    bool
+		layer uint8
+	}{
+		{"mpeg-1 layer ii", []byte{0xFF, 0xFD, 0xA4, 0x04}, true, 2},
+		{"mpeg-1 layer iii", []byte{0xFF, 0xFB, 0x90, 0x64}, true, 3},
+		{"mpeg-2 layer iii", []byte{0xFF, 0xF3, 0x84, 0xC4}, true, 3},
+		{"mpeg-1 layer i", []byte{0xFF, 0xFF, 0x90, 0x00}, true, 1},
+		{"leading data", []byte{0x00, 0x12, 0xFF, 0xFD, 0xA4, 0x04}, true, 2},
+		{"reserved layer", []byte{0xFF, 0xF9, 0xA4, 0x04}, false, 0},
+		{"bad bitrate", []byte{0xFF, 0xFD, 0xF4, 0x04}, false, 0},
+		{"too short", []byte{0xFF, 0xFD, 0xA4}, false, 0},
+	}
+
+	for _, c := range cases {
+		info, ok := ParseMpegAudioHeader(c.data, StreamTypeMP2)
+		if ok != c.ok || info.Layer != c.layer {
+			t.Errorf("%s: ParseMpegAudioHeader = (layer %d, %v), want (layer %d, %v)", c.name, info.Layer, ok, c.layer, c.ok)
+		}
+		if ok && info.StreamType != StreamTypeMP2 {
+			t.Errorf("%s: StreamType = 0x%02X, want 0x%02X", c.name, info.StreamType, StreamTypeMP2)
+		}
+	}
+}
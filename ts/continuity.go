@@ -0,0 +1,117 @@
+package ts
+
+import (
+	"fmt"
+	"sort"
+)
+
+// nullPID 空包PID，不检查连续计数
+const nullPID uint16 = 0x1FFF
+
+// PIDStatistics 单个PID的传输错误统计
+type PIDStatistics struct {
+	PID            uint16 // 包标识
+	CCErrorCount   uint32 // 连续计数错误次数
+	DuplicateCount uint32 // 重复包数
+	TEICount       uint32 // 传输错误指示为1的包数
+	ScrambledCount uint32 // 加扰的包数
+}
+
+// continuityState 单个PID的连续计数状态
+type continuityState struct {
+	lastCC    uint8 // 上一个包的连续计数
+	duplicate bool  // 上一个包是否为重复包
+}
+
+// checkContinuity 检查ts包的连续计数，统计传输错误指示及加扰的包
+//
+// 连续计数只在包含有效载荷时递增，允许连续出现一个重复包；
+// 适配域不连续指示为1时重新开始计数
+func (d *Demuxer) checkContinuity(pHeader *Header) {
+
+	if pHeader.PID == nullPID {
+		return
+	}
+
+	statistics, ok := d.pidStatistics[pHeader.PID]
+	if !ok {
+		statistics = &PIDStatistics{PID: pHeader.PID}
+		d.pidStatistics[pHeader.PID] = statistics
+	}
+
+	// 传输错误的包，包头不可信，不参与连续计数检查，之后的包重新开始计数
+	if pHeader.TransportErrorIndicator == 0x1 {
+		statistics.TEICount++
+		delete(d.continuityMap, pHeader.PID)
+		return
+	}
+
+	if pHeader.TransportScramblingControl != 0x0 {
+		statistics.ScrambledCount++
+	}
+
+	state, ok := d.continuityMap[pHeader.PID]
+	discontinuity := pHeader.AdaptationField != nil && pHeader.AdaptationField.DiscontinuityIndicator == 0x1
+	d.continuityMap[pHeader.PID] = &continuityState{lastCC: pHeader.ContinuityCounter}
+
+	if !ok || discontinuity {
+		return
+	}
+
+	// 无有效载荷的包，连续计数不变
+	hasPayload := pHeader.AdaptationFieldControl == 0x01 || pHeader.AdaptationFieldControl == 0x03
+	if !hasPayload {
+		if pHeader.ContinuityCounter != state.lastCC {
+			statistics.CCErrorCount++
+			Log.Debug("Continuity counter error, PID: " + fmt.Sprint(pHeader.PID) + ", offset: " + fmt.Sprint(d.curOffset-uint64(d.pkgSize)))
+		}
+		return
+	}
+
+	// 重复包，只允许连续出现一次
+	if pHeader.ContinuityCounter == state.lastCC {
+		if state.duplicate {
+			statistics.CCErrorCount++
+			return
+		}
+		statistics.DuplicateCount++
+		d.continuityMap[pHeader.PID].duplicate = true
+		return
+	}
+
+	if pHeader.ContinuityCounter != (state.lastCC+1)&0x0f {
+		statistics.CCErrorCount++
+		Log.Debug("Continuity counter error, PID: " + fmt.Sprint(pHeader.PID) + ", offset: " + fmt.Sprint(d.curOffset-uint64(d.pkgSize)))
+	}
+}
+
+// getPIDStatistics 按PID排序的传输错误统计
+func (d *Demuxer) getPIDStatistics() []PIDStatistics {
+
+	var pidStatistics []PIDStatistics = make([]PIDStatistics, 0, len(d.pidStatistics))
+	for _, statistics := range d.pidStatistics {
+		pidStatistics = append(pidStatistics, *statistics)
+	}
+
+	sort.Slice(pidStatistics, func(i, j int) bool {
+		return pidStatistics[i].PID < pidStatistics[j].PID
+	})
+
+	return pidStatistics
+}
+
+// sumPIDStatistics 汇总各PID的传输错误统计
+func (statistics *Statistics) sumPIDStatistics() {
+
+	statistics.CCErrorCount = 0
+	statistics.DuplicateCount = 0
+	statistics.TEICount = 0
+	statistics.ScrambledCount = 0
+
+	for _, pidStatistics := range statistics.PIDs {
+		statistics.CCErrorCount += pidStatistics.CCErrorCount
+		statistics.DuplicateCount += pidStatistics.DuplicateCount
+		statistics.TEICount += pidStatistics.TEICount
+		statistics.ScrambledCount += pidStatistics.ScrambledCount
+	}
+}
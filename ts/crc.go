@@ -0,0 +1,31 @@
+package ts
+
+// crcTable MPEG-2 CRC32 查找表，多项式 0x04C11DB7
+var crcTable [256]uint32
+
+// init 生成查找表
+func init() {
+	var i uint32
+	for i = 0; i < 256; i++ {
+		var crc uint32 = i << 24
+		var j int
+		for j = 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc = crc << 1
+			}
+		}
+		crcTable[i] = crc
+	}
+}
+
+// crc32Mpeg2 计算 MPEG-2 CRC32，初始值 0xFFFFFFFF，不反转，不异或输出
+// 对包含CRC字段的完整段计算结果为0
+func crc32Mpeg2(data []byte) uint32 {
+	var crc uint32 = 0xFFFFFFFF
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
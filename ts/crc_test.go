@@ -0,0 +1,83 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCrc32Mpeg2 使用已知的PSI段校验CRC32，段尾4字节为CRC
+func TestCrc32Mpeg2(t *testing.T) {
+
+	var cases = []struct {
+		name    string
+		section []byte
+	}{
+		{
+			// PAT：transport_stream_id 1，节目 1 的pmt位于 PID 0x1000
+			name: "pat",
+			section: []byte{0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00,
+				0x00, 0x01, 0xF0, 0x00,
+				0x2A, 0xB1, 0x04, 0xB2},
+		},
+		{
+			// PMT：节目 1，PCR_PID 0x100，H.264(0x100) 及 AAC(0x101)
+			name: "pmt",
+			section: []byte{0x02, 0xB0, 0x17, 0x00, 0x01, 0xC1, 0x00, 0x00,
+				0xE1, 0x00, 0xF0, 0x00,
+				0x1B, 0xE1, 0x00, 0xF0, 0x00,
+				0x0F, 0xE1, 0x01, 0xF0, 0x00,
+				0x2F, 0x44, 0xB9, 0x9B},
+		},
+	}
+
+	for _, c := range cases {
+		body := c.section[:len(c.section)-4]
+		crc := c.section[len(c.section)-4:]
+		want := uint32(crc[0])<<24 | uint32(crc[1])<<16 | uint32(crc[2])<<8 | uint32(crc[3])
+
+		if got := crc32Mpeg2(body); got != want {
+			t.Errorf("%s: crc32Mpeg2(body) = 0x%08X, want 0x%08X", c.name, got, want)
+		}
+		if got := crc32Mpeg2(c.section); got != 0 {
+			t.Errorf("%s: crc32Mpeg2(section) = 0x%08X, want 0", c.name, got)
+		}
+
+		// 任意一位错误时校验失败
+		damaged := append([]byte(nil), c.section...)
+		damaged[3] ^= 0x01
+		if crc32Mpeg2(damaged) == 0 {
+			t.Errorf("%s: crc32Mpeg2 of damaged section = 0", c.name)
+		}
+	}
+}
+
+// TestMuxSectionCrc 生成的段可以通过CRC校验，want 不为空时与已知的段一致
+func TestMuxSectionCrc(t *testing.T) {
+
+	var cases = []struct {
+		name    string
+		section []byte
+		want    []byte
+	}{
+		{
+			name:    "pat",
+			section: muxPatSection(&Pat{TransportstreamID: 1, Programs: []PatProgram{{ProgramNumber: 1, PID: 0x1000}}}),
+			want: []byte{0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00,
+				0x00, 0x01, 0xF0, 0x00,
+				0x2A, 0xB1, 0x04, 0xB2},
+		},
+		{
+			name:    "empty",
+			section: muxSection(0x02, 1, 0, nil),
+		},
+	}
+
+	for _, c := range cases {
+		if got := crc32Mpeg2(c.section); got != 0 {
+			t.Errorf("%s: crc32Mpeg2(section) = 0x%08X, want 0", c.name, got)
+		}
+		if c.want != nil && !bytes.Equal(c.section, c.want) {
+			t.Errorf("%s: section = % X, want % X", c.name, c.section, c.want)
+		}
+	}
+}
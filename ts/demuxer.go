@@ -3,6 +3,7 @@ package ts
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	errors "../errors"
 )
@@ -10,143 +11,326 @@ import (
 // TsPkgSize TS包字节数
 const TsPkgSize int = 188
 
+// M2TS(BDAV) 包，每个ts包前带有4字节的时间戳
+const (
+	M2tsPkgSize    int = 192
+	m2tsSyncOffset int = 4
+)
+
+// FecPkgSize 带16字节 Reed-Solomon 校验码的ts包，校验码位于ts包之后
+const FecPkgSize int = 204
+
 // TsReloadNum 预加载包数量
 const TsReloadNum int = 100000
 
-// header Ts头
-type header struct {
-	syncByte                   uint8  //8 同步字节：固定为0x47;
-	transportErrorIndicator    uint8  //1 传输错误标志：‘1’表示在相关的传输包中至少有一个不可纠正的错误位。
-	payloadUnitStartIndicator  uint8  //1 负载起始标志：在前4个字节之后会有一个调整字节，其的数值为后面调整字段的长度length。
-	transportPriority          uint8  //1 传输优先级标志
-	PID                        uint16 //13 PID
-	transportScramblingControl uint8  //2 加扰控制标志：表示TS流分组有效负载的加密模式。空包为‘00’
-	adaptationFieldControl     uint8  //2 适配域控制标志‘00’为ISO/IEC未来使用保留；
-	continuityCounter          uint8  //4 连续性计数器
-	adaptaionFieldLength       uint8  //8 适配域长度
-}
-
-// patProgram pat 中的 Program
-type patProgram struct {
-	programNumber uint16 //16 节目号
-	reserved      uint8  //3 保留字段，固定为111
+// 流类型
+const (
+	StreamTypeH264 uint8 = 0x1b // h.264编码
+	StreamTypeHEVC uint8 = 0x24 // h.265编码
+	StreamTypeAAC  uint8 = 0x0f // aac编码
+	StreamTypeMP3  uint8 = 0x03 // mpeg-1 音频(mp3)
+	StreamTypeMP2  uint8 = 0x04 // mpeg-2 音频
+	StreamTypeAC3  uint8 = 0x81 // ac-3 音频(ATSC)
+	streamTypePES  uint8 = 0x06 // 私有数据pes，需根据描述符判断内容
+)
+
+// 描述符标签
+const (
+	descriptorTagAC3 uint8 = 0x6a // DVB ac-3 描述符
+)
+
+// Header Ts头
+type Header struct {
+	SyncByte                   uint8            //8 同步字节：固定为0x47;
+	TransportErrorIndicator    uint8            //1 传输错误标志：‘1’表示在相关的传输包中至少有一个不可纠正的错误位。
+	PayloadUnitStartIndicator  uint8            //1 负载起始标志：在前4个字节之后会有一个调整字节，其的数值为后面调整字段的长度length。
+	TransportPriority          uint8            //1 传输优先级标志
+	PID                        uint16           //13 PID
+	TransportScramblingControl uint8            //2 加扰控制标志：表示TS流分组有效负载的加密模式。空包为‘00’
+	AdaptationFieldControl     uint8            //2 适配域控制标志‘00’为ISO/IEC未来使用保留；
+	ContinuityCounter          uint8            //4 连续性计数器
+	AdaptationFieldLength      uint8            //8 适配域长度
+	AdaptationField            *AdaptationField // 适配域，不存在时为nil
+}
+
+// AdaptationField 适配域
+type AdaptationField struct {
+	AdaptationFieldLength             uint8  //8 适配域长度
+	DiscontinuityIndicator            uint8  //1 不连续状态指示：置1时表示当前包的连续计数或系统时间基不连续
+	RandomAccessIndicator             uint8  //1 随机访问指示：置1时表示下一个pes包含随机访问点
+	ElementaryStreamPriorityIndicator uint8  //1 基本流优先级指示
+	PCRFlag                           uint8  //1 置1时表示PCR字段存在
+	OPCRFlag                          uint8  //1 置1时表示OPCR字段存在
+	SplicingPointFlag                 uint8  //1 置1时表示spliceCountdown字段存在
+	TransportPrivateDataFlag          uint8  //1 置1时表示私有数据存在
+	AdaptationFieldExtensionFlag      uint8  //1 置1时表示适配域扩展存在
+	PCR                               int64  //42 节目时钟参考，base(33bit)*300+extension(9bit)，单位1/27MHz
+	OPCR                              int64  //42 原始节目时钟参考，单位1/27MHz
+	SpliceCountdown                   int8   //8 拼接倒计数，为0时表示当前包为拼接点
+	TransportPrivateDataLength        uint8  //8 私有数据长度
+	TransportPrivateData              []byte // 私有数据
+}
+
+// PatProgram pat 中的 Program
+type PatProgram struct {
+	ProgramNumber uint16 //16 节目号
+	Reserved      uint8  //3 保留字段，固定为111
 	PID           uint16 //16 节目号对应内容的PID值
 }
 
-// pat 表
-type pat struct {
-	tableID                uint8  //8 pat表固定为0x00
-	sectionSyntaxIndicator uint8  //1 段语法标志位，固定为1
-	zero                   uint8  //1 固定为0
-	reserved1              uint8  //2 保留字段，固定为11
-	sectionLength          uint16 //12 表示这个字节后面数据的长度,包括 CRC信息
-	transportstreamID      uint16 //16 该传输流的ID
-	reserved2              uint8  //2 保留字段，固定为11
-	versionNumber          uint8  //5 版本号，固定为00000,有变化则版本号加1
-	currentNextIndicator   uint8  //1 pat是否有效,固定为1，表示这个pat表可以用，如果为0则要等待下一个pat表
-	sectionNumber          uint8  //8 分段号码,最多256个分段
-	lastSectionNumber      uint8  //8 最后一个分段的号码
-	networkPID             uint16 //16 网络PID
+// Pat pat表
+type Pat struct {
+	TableID                uint8  //8 pat表固定为0x00
+	SectionSyntaxIndicator uint8  //1 段语法标志位，固定为1
+	Zero                   uint8  //1 固定为0
+	Reserved1              uint8  //2 保留字段，固定为11
+	SectionLength          uint16 //12 表示这个字节后面数据的长度,包括 CRC信息
+	TransportstreamID      uint16 //16 该传输流的ID
+	Reserved2              uint8  //2 保留字段，固定为11
+	VersionNumber          uint8  //5 版本号，固定为00000,有变化则版本号加1
+	CurrentNextIndicator   uint8  //1 pat是否有效,固定为1，表示这个pat表可以用，如果为0则要等待下一个pat表
+	SectionNumber          uint8  //8 分段号码,最多256个分段
+	LastSectionNumber      uint8  //8 最后一个分段的号码
+	NetworkPID             uint16 //16 网络PID
 	CRC                    uint32 //32 CRC校验码
-	programCount           uint8  //8 节目数量
+	ProgramCount           uint8  //8 节目数量
 	pLoopData              []byte //循环数据
-	programs               []patProgram
+	Programs               []PatProgram
 }
 
-// stream 视频流信息结构体
-type stream struct {
-	streamType    uint8  //8 流类型  h.264编码对应0x1b;aac编码对应0x0f;mp3编码对应0x03
-	reserved1     uint8  //3 保留字段，固定为111
-	elementaryPID uint16 //13 元素PID,与streamType对应的PID
-	reserved2     uint8  //4 保留字段，固定为1111
+// Stream pmt中的基本流信息
+type Stream struct {
+	StreamType    uint8  //8 流类型  h.264编码对应0x1b;aac编码对应0x0f;mp3编码对应0x03
+	Reserved1     uint8  //3 保留字段，固定为111
+	ElementaryPID uint16 //13 元素PID,与streamType对应的PID
+	Reserved2     uint8  //4 保留字段，固定为1111
 	ESInfoLength  uint16 //12  描述信息，指定为0x000表示没有
+	Descriptors   []byte // 描述信息
 }
 
-// pmt 表
-type pmt struct {
-	tableID                uint8    //8 pat表固定为0x00
-	sectionSyntaxIndicator uint8    //1 段语法标志位，固定为1
-	zero                   uint8    //1 固定为0
-	reserved1              uint8    //2  保留字段，固定为11
-	sectionLength          uint16   //12 表示这个字节后面数据的长度,包括 CRC信息
-	programNumber          uint16   //16 频道号码，表示当前的pmt关联到的频道，取值0x0001
-	reserved2              uint8    //2 保留字段，固定为11
-	versionNumber          uint8    //5 版本号，固定为00000，如果pat有变化则版本号加1
-	currentNextIndicator   uint8    //1 是否有效
-	sectionNumber          uint8    //8 分段号码
-	lastSectionNumber      uint8    //8 最后一个分段的号码
-	reserved3              uint8    //3 保留字段，固定为111
+// Pmt pmt表
+type Pmt struct {
+	TableID                uint8    //8 pat表固定为0x00
+	SectionSyntaxIndicator uint8    //1 段语法标志位，固定为1
+	Zero                   uint8    //1 固定为0
+	Reserved1              uint8    //2  保留字段，固定为11
+	SectionLength          uint16   //12 表示这个字节后面数据的长度,包括 CRC信息
+	ProgramNumber          uint16   //16 频道号码，表示当前的pmt关联到的频道，取值0x0001
+	Reserved2              uint8    //2 保留字段，固定为11
+	VersionNumber          uint8    //5 版本号，固定为00000，如果pat有变化则版本号加1
+	CurrentNextIndicator   uint8    //1 是否有效
+	SectionNumber          uint8    //8 分段号码
+	LastSectionNumber      uint8    //8 最后一个分段的号码
+	Reserved3              uint8    //3 保留字段，固定为111
 	PcrPID                 uint16   //13 PCR_PID PCR(节目参考时钟)所在TS分组的PID，指定为视频PID
-	reserved4              uint8    //4 保留字段固定为 1111
-	programInfoLength      uint16   //12 节目描述信息，指定为0x000表示没有
+	Reserved4              uint8    //4 保留字段固定为 1111
+	ProgramInfoLength      uint16   //12 节目描述信息，指定为0x000表示没有
 	CRC                    uint32   //32 CRC校验码
-	streamCount            uint8    //8 流总数
+	StreamCount            uint8    //8 流总数
 	pLoopData              []byte   //循环数据
-	streams                []stream // 流数据
+	Streams                []Stream // 流数据
 }
 
 // Pes pes数据结构体
 type Pes struct {
-	pesStartCodePrefix     uint32 //24 起始码，固定必须是'0000 0000 0000 0000 0000 0001' (0x000001)。用于标识包的开始。
-	streamID               uint8  //8 流ID
-	PESPacketLength        uint16 //16 PES包的长度
-	twobit10               uint8  //2 固定两位分割bit 0x2
-	PESScramblingControl   uint8  //2 字段指示 PES 包有效载荷的加扰方式; PES 包头，其中包括任选字段只要存在，应不加扰。00 不加扰
-	PESPriority            uint8  //1 指示在此 PES 包中该有效载荷的优先级。
-	dataAlignmentIndicator uint8  //1 数据校准标志
-	copyright              uint8  //1 版权保护标志
-	originalOrCopy         uint8  //1 是否为复制
-	PtsDtsFlags            uint8  //2 PTS(presentation time stamp 显示时间标签),DTS(decoding time stamp 解码时间标签)标志位
-	ESCRFlag               uint8  //1 置于‘1’时指示 PES 包头中 ESCR 基准字段和 ESCR 扩展字段均存在。
-	ESRateFlag             uint8  //1 置于‘1’时指示 PES 包头中 ESRate 字段存在。
-	DSMTrickModeFlag       uint8  //1 特技方式
-	additionalCopyInfoFlag uint8  //1 置于‘1’时指示 additionalCopyInfo 存在。
-	PESCRCFlag             uint8  //1 置于‘1’时指示 PES 包中 CRC 字段存在。
-	PESExtensionFlag       uint8  //1 置于‘1’时指示 PES 包头中扩展字段存在。置于‘0’时指示此字段不存在
-	PESHeaderDataLength    uint8  //8 指示在此PES包头中包含的由任选字段和任意填充字节所占据的字节总数。
-	PTS                    int64  //33 PTS(presentation time stamp 显示时间标签)
-	DTS                    int64  //33 DTS(decoding time stamp 解码时间标签)标志位
-	ESCRBase               uint64 //33 基本流时钟参考
-	ESCRExtension          uint16 //9 基本流时钟参考
-	ESRate                 uint32 //22 ES 速率（基本流速率）
-	trickModeControl       uint8  //3 3 比特字段，指示适用于相关视频流的特技方式
-	fieldID                uint8  //2 2 比特字段，指示哪些字段应予显示
-	intraSliceRefresh      uint8  //1 1 比特标志，置于‘1’时指示此 PES 包中视频数据的编码截面间可能存在丢失宏块
-	frequencyTruncation    uint8  //2 指示在此 PES 包中编码视频数据时曾经使用的受限系数集
-	repCntrl               uint8  //5 指示交错图像中每个字段应予显示的次数，或者连续图像应予显示的次数
-	additionalCopyInfo     uint8  //7 此 7 比特字段包含与版权信息有关的专用数据
-	previousPESPacketCRC   uint16 //16 包含产生解码器中 16 寄存器零输出的 CRC 值
-	PkgOffset              uint64 // pes开始位置所处的文件偏移量
-	ptime                  int64
-	dtime                  int64
-	PID                    uint16
+	PESStartCodePrefix     uint32           //24 起始码，固定必须是'0000 0000 0000 0000 0000 0001' (0x000001)。用于标识包的开始。
+	StreamID               uint8            //8 流ID
+	PESPacketLength        uint16           //16 PES包的长度
+	Twobit10               uint8            //2 固定两位分割bit 0x2
+	PESScramblingControl   uint8            //2 字段指示 PES 包有效载荷的加扰方式; PES 包头，其中包括任选字段只要存在，应不加扰。00 不加扰
+	PESPriority            uint8            //1 指示在此 PES 包中该有效载荷的优先级。
+	DataAlignmentIndicator uint8            //1 数据校准标志
+	Copyright              uint8            //1 版权保护标志
+	OriginalOrCopy         uint8            //1 是否为复制
+	PtsDtsFlags            uint8            //2 PTS(presentation time stamp 显示时间标签),DTS(decoding time stamp 解码时间标签)标志位
+	ESCRFlag               uint8            //1 置于‘1’时指示 PES 包头中 ESCR 基准字段和 ESCR 扩展字段均存在。
+	ESRateFlag             uint8            //1 置于‘1’时指示 PES 包头中 ESRate 字段存在。
+	DSMTrickModeFlag       uint8            //1 特技方式
+	AdditionalCopyInfoFlag uint8            //1 置于‘1’时指示 additionalCopyInfo 存在。
+	PESCRCFlag             uint8            //1 置于‘1’时指示 PES 包中 CRC 字段存在。
+	PESExtensionFlag       uint8            //1 置于‘1’时指示 PES 包头中扩展字段存在。置于‘0’时指示此字段不存在
+	PESHeaderDataLength    uint8            //8 指示在此PES包头中包含的由任选字段和任意填充字节所占据的字节总数。
+	PTS                    int64            //33 PTS(presentation time stamp 显示时间标签)
+	DTS                    int64            //33 DTS(decoding time stamp 解码时间标签)标志位
+	ESCRBase               uint64           //33 基本流时钟参考
+	ESCRExtension          uint16           //9 基本流时钟参考
+	ESRate                 uint32           //22 ES 速率（基本流速率）
+	TrickModeControl       uint8            //3 3 比特字段，指示适用于相关视频流的特技方式
+	FieldID                uint8            //2 2 比特字段，指示哪些字段应予显示
+	IntraSliceRefresh      uint8            //1 1 比特标志，置于‘1’时指示此 PES 包中视频数据的编码截面间可能存在丢失宏块
+	FrequencyTruncation    uint8            //2 指示在此 PES 包中编码视频数据时曾经使用的受限系数集
+	RepCntrl               uint8            //5 指示交错图像中每个字段应予显示的次数，或者连续图像应予显示的次数
+	AdditionalCopyInfo     uint8            //7 此 7 比特字段包含与版权信息有关的专用数据
+	PreviousPESPacketCRC   uint16           //16 包含产生解码器中 16 寄存器零输出的 CRC 值
+	PkgOffset              uint64           // pes开始位置所处的文件偏移量
+	PkgEndOffset           uint64           // pes最后一个包结尾的文件偏移量
+	IsKeyFrame             bool             // 是否为关键帧(IDR图像)
+	AdaptationField        *AdaptationField // pes起始包的适配域，不存在时为nil
+	PCR                    int64            // pes开始前最近一次的节目时钟参考，-1 表示尚未出现
+	Discontinuity          bool             // pes开始前出现了不连续状态指示
+	PID                    uint16           // pes所属的PID
+	Payload                []byte           // pes有效载荷(ES数据)，不包含pes包头
 }
 
 // Demuxer TS解封装器
 type Demuxer struct {
-	globalpat     pat               // 全局pat表
-	globalpmt     pmt               // 全局pmt表
-	bufferMap     map[uint16][]byte // 全局ts buffer临时存储，key PID,值 byte数据切片
-	curPesLen     int               // 当前pes结束长度
+	globalpat     Pat                      // 全局pat表
+	globalpmt     Pmt                      // 全局pmt表
+	bufferMap     map[uint16][]byte        // 全局ts buffer临时存储，key PID,值 byte数据切片
+	sectionMap    map[uint16][]byte        // 未完整的PSI段临时存储，key PID
+	pesStartMap   map[uint16]*pesStartInfo // 当前pes起始包信息，key PID
 	curVideoPID   int
 	curAudioPID   int
+	curIndexPID   int            // 用于建立索引的流PID，有视频时为视频流，否则为音频流
+	selectProgram uint16         // 选择的节目号，0 表示第一个节目
+	curVideoType  uint8          // 当前视频流类型
+	curAudioType  uint8          // 当前音频流类型
+	videoCodec    VideoCodecInfo // 视频编码信息
+	audioCodec    AudioCodecInfo // 音频编码信息
 	curOffset     uint64
+	pkgSize       int                         // 源文件中每个包的字节数，用于计算偏移量
+	lastPCR       int64                       // 最近一次的节目时钟参考
+	discontinuity bool                        // 是否有未处理的不连续状态指示
+	statistics    Statistics                  // 统计信息
+	services      map[uint16]ServiceInfo      // 业务描述信息，key 业务ID(节目号)
+	continuityMap map[uint16]*continuityState // 连续计数状态，key PID
+	pidStatistics map[uint16]*PIDStatistics   // 传输错误统计，key PID
+	reader        *packetReader               // 媒体数据读取器，通过 NewDemuxer 创建时有效
+
+	OnPacket  func(pkt *Packet)        // 解析出ts包时回调，包数据在回调返回后失效
+	OnPat     func(pat *Pat)           // pat表更新时回调
+	OnPmt     func(pmt *Pmt)           // 选择的节目的pmt表更新时回调
+	OnService func(info *ServiceInfo)  // SDT中的业务描述信息更新时回调
+	OnPes     func(pes *Pes)           // 选择的节目中的基本流解析出完整的pes时回调
+	OnSplice  func(event *SpliceEvent) // 选择的节目中解析出SCTE-35切点时回调
+}
+
+// Statistics 解封装统计信息
+type Statistics struct {
+	CRCErrorCount     uint32          // PSI段CRC校验失败次数
+	SectionErrorCount uint32          // 跨包拼接不完整而被丢弃的PSI段数
+	ErrorPacketCount  uint32          // 解析失败的包数
+	CCErrorCount      uint32          // 连续计数错误次数，各PID之和
+	DuplicateCount    uint32          // 重复包数，各PID之和
+	TEICount          uint32          // 传输错误指示为1的包数，各PID之和
+	ScrambledCount    uint32          // 加扰的包数，各PID之和
+	PIDs              []PIDStatistics // 各PID的传输错误统计，按PID排序
+}
+
+// pesStartInfo pes起始包信息
+type pesStartInfo struct {
+	offset          uint64           // 起始包的文件偏移量
+	endOffset       uint64           // 已读取的最后一个包结尾的文件偏移量
+	adaptationField *AdaptationField // 起始包的适配域
+	pcr             int64            // 起始时最近一次的节目时钟参考
+	discontinuity   bool             // 起始前出现了不连续状态指示
 }
 
 // Init 初始化解封装器
 func (d *Demuxer) Init() {
 	d.bufferMap = make(map[uint16][]byte)
-	d.curPesLen = -1
+	d.pesStartMap = make(map[uint16]*pesStartInfo)
+	d.sectionMap = make(map[uint16][]byte)
+	d.services = make(map[uint16]ServiceInfo)
+	d.continuityMap = make(map[uint16]*continuityState)
+	d.pidStatistics = make(map[uint16]*PIDStatistics)
 	d.curVideoPID = -1
 	d.curAudioPID = -1
+	d.curIndexPID = -1
+	d.selectProgram = 0
+	d.curVideoType = 0
+	d.curAudioType = 0
 	d.curOffset = 0
+	d.pkgSize = TsPkgSize
+	d.lastPCR = -1
+	d.discontinuity = false
+	d.statistics = Statistics{}
+}
+
+// SelectProgram 选择需要解封装的节目，0 表示第一个可建立索引的节目
+func (d *Demuxer) SelectProgram(programNumber uint16) {
+	d.selectProgram = programNumber
+}
+
+// GetStatistics 获取解封装统计信息
+func (d *Demuxer) GetStatistics() Statistics {
+	statistics := d.statistics
+	statistics.PIDs = d.getPIDStatistics()
+	statistics.sumPIDStatistics()
+	return statistics
 }
 
 // DemuxPkg 解封装
 func (d *Demuxer) DemuxPkg(pKgBuf []byte) (*Pes, error) {
 
+	pes, err := d.demuxPkg(pKgBuf)
+	if err != nil {
+		d.statistics.ErrorPacketCount++
+	}
+	return pes, err
+}
+
+// SetPkgSize 设置源文件中每个包的字节数(188/192/204)，DemuxPkg 仍只接收188字节的ts包
+func (d *Demuxer) SetPkgSize(pkgSize int) {
+	d.pkgSize = pkgSize
+}
+
+// Resync 跳过损坏的数据后重新同步
+//
+//	offset 下一个有效包的偏移量
+//
+// 跨越损坏区间的PES及PSI段数据不完整，将被丢弃
+func (d *Demuxer) Resync(offset uint64) {
+	d.curOffset = offset
+	d.bufferMap = make(map[uint16][]byte)
+	d.pesStartMap = make(map[uint16]*pesStartInfo)
+	for PID := range d.sectionMap {
+		d.dropSection(PID)
+	}
+	d.continuityMap = make(map[uint16]*continuityState)
+}
+
+// Flush 输入结束时解析各基本流缓存中最后一个pes，按PID排序返回，解析失败的pes将被忽略
+func (d *Demuxer) Flush() []*Pes {
+
+	var pesList []*Pes = make([]*Pes, 0)
+	for _, s := range d.globalpmt.Streams {
+
+		if len(d.bufferMap[s.ElementaryPID]) == 0 {
+			continue
+		}
+
+		var pHeader Header
+		pHeader.PID = s.ElementaryPID
+		pesResult, err := d.readPes(d.bufferMap[pHeader.PID], &pHeader)
+
+		// 清空旧数据
+		d.bufferMap[pHeader.PID] = nil
+
+		if err != nil {
+			Log.Warn("Demux last pes failed: " + err.Error() + ", PID: " + fmt.Sprint(pHeader.PID))
+			continue
+		}
+		pesList = append(pesList, pesResult)
+	}
+
+	sort.Slice(pesList, func(i, j int) bool {
+		return pesList[i].PID < pesList[j].PID
+	})
+
+	return pesList
+}
+
+// Offset 下一个包的偏移量
+func (d *Demuxer) Offset() uint64 {
+	return d.curOffset
+}
+
+// demuxPkg 解封装单个ts包
+func (d *Demuxer) demuxPkg(pKgBuf []byte) (*Pes, error) {
+
 	// 新包头记录当前包头的偏移量
-	d.curOffset += uint64(TsPkgSize)
+	d.curOffset += uint64(d.pkgSize)
 
 	// check包长度
 	if 188 != len(pKgBuf) {
@@ -166,8 +350,15 @@ func (d *Demuxer) DemuxPkg(pKgBuf []byte) (*Pes, error) {
 		return nil, adpReadErr
 	}
 
+	// 检查连续计数
+	d.checkContinuity(header)
+
+	if d.OnPacket != nil {
+		d.OnPacket(&Packet{Header: *header, Offset: d.curOffset - uint64(d.pkgSize), Payload: getPayload(pKgBuf, header), Data: pKgBuf})
+	}
+
 	// 获取有效载荷, adaptationFieldControl 01,11 代表有有效载荷
-	if header.adaptationFieldControl == 0x01 || header.adaptationFieldControl == 0x03 {
+	if header.AdaptationFieldControl == 0x01 || header.AdaptationFieldControl == 0x03 {
 		pesResult, payloadReadErr := d.readPayload(pKgBuf, header)
 		if payloadReadErr != nil {
 			return nil, payloadReadErr
@@ -181,102 +372,190 @@ func (d *Demuxer) DemuxPkg(pKgBuf []byte) (*Pes, error) {
 }
 
 // 解析TS包头
-func (d *Demuxer) readTsHeader(pKgBuf []byte) (*header, error) {
-	var header header
-	header.syncByte = pKgBuf[0]
+func (d *Demuxer) readTsHeader(pKgBuf []byte) (*Header, error) {
+	var header Header
+	header.SyncByte = pKgBuf[0]
 
 	// 不是有效的ts包，抛弃
-	if header.syncByte != 0x47 {
+	if header.SyncByte != 0x47 {
 		err := errors.NewError(errors.ErrorCodeDemuxFailed, "TsHeader read failed!")
 		return nil, err
 	}
 
-	header.transportErrorIndicator = pKgBuf[1] >> 7
-	header.payloadUnitStartIndicator = pKgBuf[1] >> 6 & 0x01
-	header.transportPriority = pKgBuf[1] >> 5 & 0x01
+	header.TransportErrorIndicator = pKgBuf[1] >> 7
+	header.PayloadUnitStartIndicator = pKgBuf[1] >> 6 & 0x01
+	header.TransportPriority = pKgBuf[1] >> 5 & 0x01
 	header.PID = uint16(pKgBuf[1]&0x1f)<<8 | uint16(pKgBuf[2])
-	header.transportScramblingControl = pKgBuf[3] >> 6
-	header.adaptationFieldControl = pKgBuf[3] >> 4 & 0x03
-	header.continuityCounter = pKgBuf[3] & 0x0f
+	header.TransportScramblingControl = pKgBuf[3] >> 6
+	header.AdaptationFieldControl = pKgBuf[3] >> 4 & 0x03
+	header.ContinuityCounter = pKgBuf[3] & 0x0f
 
 	return &header, nil
 }
 
 // 解析适配域
-func (d *Demuxer) readAdaptionField(pKgBuf []byte, pHeader *header) error {
+func (d *Demuxer) readAdaptionField(pKgBuf []byte, pHeader *Header) error {
+
+	// adaptationFieldControl 10,11 代表有适配域
+	if pHeader.AdaptationFieldControl != 0x2 && pHeader.AdaptationFieldControl != 0x3 {
+		return nil
+	}
+
+	pHeader.AdaptationFieldLength = pKgBuf[4]
+
+	// 适配域最长183字节(仅有适配域时)
+	if int(pHeader.AdaptationFieldLength) > TsPkgSize-5 {
+		err := errors.NewError(errors.ErrorCodeDemuxFailed, "adaptation field length error!")
+		return err
+	}
+
+	var af AdaptationField
+	af.AdaptationFieldLength = pHeader.AdaptationFieldLength
+	pHeader.AdaptationField = &af
+
+	// 长度为0时用于填充一个字节，没有标志位
+	if af.AdaptationFieldLength == 0 {
+		return nil
+	}
+
+	af.DiscontinuityIndicator = pKgBuf[5] >> 7 & 0x1
+	af.RandomAccessIndicator = pKgBuf[5] >> 6 & 0x1
+	af.ElementaryStreamPriorityIndicator = pKgBuf[5] >> 5 & 0x1
+	af.PCRFlag = pKgBuf[5] >> 4 & 0x1
+	af.OPCRFlag = pKgBuf[5] >> 3 & 0x1
+	af.SplicingPointFlag = pKgBuf[5] >> 2 & 0x1
+	af.TransportPrivateDataFlag = pKgBuf[5] >> 1 & 0x1
+	af.AdaptationFieldExtensionFlag = pKgBuf[5] & 0x1
+
+	// 可选域字节索引及结束位置
+	var pos int = 6
+	var end int = 5 + int(af.AdaptationFieldLength)
+
+	if af.PCRFlag == 0x1 && pos+6 <= end {
+		af.PCR = readClockReference(pKgBuf[pos : pos+6])
+		pos += 6
+	}
+
+	if af.OPCRFlag == 0x1 && pos+6 <= end {
+		af.OPCR = readClockReference(pKgBuf[pos : pos+6])
+		pos += 6
+	}
+
+	if af.SplicingPointFlag == 0x1 && pos+1 <= end {
+		af.SpliceCountdown = int8(pKgBuf[pos])
+		pos++
+	}
+
+	if af.TransportPrivateDataFlag == 0x1 && pos+1 <= end {
+		af.TransportPrivateDataLength = pKgBuf[pos]
+		pos++
 
-	if pHeader.adaptationFieldControl == 0x2 || pHeader.adaptationFieldControl == 0x3 {
-		pHeader.adaptaionFieldLength = pKgBuf[4]
+		if pos+int(af.TransportPrivateDataLength) <= end {
+			af.TransportPrivateData = pKgBuf[pos : pos+int(af.TransportPrivateDataLength)]
+			pos += int(af.TransportPrivateDataLength)
+		}
+	}
+
+	// 适配域扩展暂不解析
+
+	// 记录节目时钟参考
+	if af.PCRFlag == 0x1 && len(d.globalpmt.Streams) > 0 && pHeader.PID == d.globalpmt.PcrPID {
+		d.lastPCR = af.PCR
+	}
+
+	// 索引流或时钟参考流出现不连续，后续时间戳不再连续
+	if af.DiscontinuityIndicator == 0x1 {
+		if int(pHeader.PID) == d.curIndexPID || (len(d.globalpmt.Streams) > 0 && pHeader.PID == d.globalpmt.PcrPID) {
+			d.discontinuity = true
+		}
 	}
 
-	//  TODO 具体解析未实现
 	return nil
 }
 
-// 解析有效载荷
-func (d *Demuxer) readPayload(pKgBuf []byte, pHeader *header) (*Pes, error) {
+// readClockReference 解析PCR/OPCR，base(33bit),reserved(6bit),extension(9bit)
+func readClockReference(data []byte) int64 {
+	base := int64(data[0])<<25 | int64(data[1])<<17 | int64(data[2])<<9 | int64(data[3])<<1 | int64(data[4])>>7
+	extension := int64(data[4]&0x1)<<8 | int64(data[5])
+	return base*300 + extension
+}
+
+// getPayload 获取ts包的有效载荷，不存在时返回nil
+func getPayload(pKgBuf []byte, pHeader *Header) []byte {
 
 	// 负载信息起始索引
 	var start int = 4
 
-	// 同时存在负载和适配域
-	if pHeader.adaptationFieldControl == 0x3 {
-		start = start + 1 + int(pHeader.adaptaionFieldLength)
+	switch pHeader.AdaptationFieldControl {
+	case 0x1:
+		return pKgBuf[start:]
+	case 0x3:
+
+		// 同时存在负载和适配域
+		start = start + 1 + int(pHeader.AdaptationFieldLength)
+		return pKgBuf[start:]
 	}
 
-	// 看是否为pat信息
-	if pHeader.PID == 0x0 {
+	return nil
+}
 
-		// 对于PSI,payloadUnitStartIndicator 为1时
-		// 有效载荷开始的位置应再偏移 1 个字节,pointer_field。
-		if pHeader.payloadUnitStartIndicator == 0x01 {
-			start = start + 1
-		}
+// 解析有效载荷
+func (d *Demuxer) readPayload(pKgBuf []byte, pHeader *Header) (*Pes, error) {
 
-		err := d.readpat(pKgBuf[start:len(pKgBuf)], pHeader)
-		if err != nil {
-			return nil, err
+	payload := getPayload(pKgBuf, pHeader)
+
+	// 看是否为pat信息
+	if pHeader.PID == 0x0 {
+		for _, section := range d.readSections(payload, pHeader) {
+			err := d.readpat(section, pHeader)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	// 是否为 BAT/SDT 信息
+	// 是否为 BAT/SDT 信息，只解析SDT
 	if pHeader.PID == 0x11 {
-		//  TODO 具体解析未实现
-		//  ...
-		//  ...
+		for _, section := range d.readSections(payload, pHeader) {
+			d.readsdt(section)
+		}
 	}
 
-	// 仍然未找到有效的视频流
-	if(d.curVideoPID == -1){
+	// 仍然未找到可建立索引的流
+	if d.curIndexPID == -1 {
 
-		// 同时解析每一个program，存在有效的pmt表后，第一个program会更新curVideoPID，后续program将被忽略
+		// 同时解析每一个program，存在有效的pmt表后，第一个program(或选择的program)会更新curIndexPID，其他program将被忽略
 		var i int
-		for i = 0; i < len(d.globalpat.programs); i ++ {
+		for i = 0; i < len(d.globalpat.Programs); i++ {
 
 			// 看是否为pmt信息
-			if d.globalpat.programs[i].PID == pHeader.PID {
-
-				// 对于PSI,payloadUnitStartIndicator 为1时
-				// 有效载荷开始的位置应再偏移 1 个字节,pointer_field。
-				if pHeader.payloadUnitStartIndicator == 0x01 {
-					start = start + 1
-				}
-				err := d.readpmt(pKgBuf[start:len(pKgBuf)], pHeader)
-
-				if err != nil {
-					return nil, err
+			if d.globalpat.Programs[i].PID == pHeader.PID {
+				for _, section := range d.readSections(payload, pHeader) {
+					err := d.readpmt(section, pHeader)
+					if err != nil {
+						return nil, err
+					}
 				}
+				break
 			}
 
 		}
 
 	} else {
 
-		// 切片只需要视频信息
-		if int(pHeader.PID) == d.curVideoPID {
+		// SCTE-35 切点信息以段的形式传输
+		if d.isSpliceInfoPID(pHeader.PID) {
+			for _, section := range d.readSections(payload, pHeader) {
+				d.readSpliceInfo(section, pHeader)
+			}
+			return nil, nil
+		}
+
+		// 解析选择的节目中各基本流的PES数据
+		if d.isElementaryPID(pHeader.PID) {
 
 			// 解析PES数据
-			pesResult, err := d.readPesPayload(pKgBuf[start:len(pKgBuf)], pHeader)
+			pesResult, err := d.readPesPayload(payload, pHeader)
 
 			if err != nil {
 				return nil, err
@@ -292,7 +571,12 @@ func (d *Demuxer) readPayload(pKgBuf []byte, pHeader *header) (*Pes, error) {
 }
 
 // 解析pat表数据
-func (d *Demuxer) readpat(payload []byte, pHeader *header) error {
+func (d *Demuxer) readpat(payload []byte, pHeader *Header) error {
+
+	// 校验段长度及CRC，损坏的段将被忽略，等待下一个有效的段
+	if !d.verifySection(payload, 12) {
+		return nil
+	}
 
 	// 获取临时pat表
 	tableID := payload[0]
@@ -367,55 +651,75 @@ func (d *Demuxer) readpat(payload []byte, pHeader *header) error {
 		// 根据缓存计算program数
 		programCount = uint8(len(loopDataBuffer) / 4)
 
-		var programs []patProgram = make([]patProgram, programCount)
+		var programs []PatProgram = make([]PatProgram, 0, programCount)
 
 		var i int
 		for i = 0; i < len(loopDataBuffer); i += 4 {
 
 			var programNumber uint16 = uint16(loopDataBuffer[i]&0xff)<<8 | uint16(loopDataBuffer[i+1]&0xff)
 
-			// 0x00 是NIT
+			// 0x00 是NIT，不作为节目，否则PID为0的pat包会被当作pmt解析
 			if programNumber == 0x00 {
 				networkPID = uint16(loopDataBuffer[i+2]&0x1f)<<8 | uint16(loopDataBuffer[i+3]&0xff)
 			} else {
-				var prg patProgram
-				prg.programNumber = programNumber
-				prg.reserved = loopDataBuffer[i+2] >> 5 & 0x3
+				var prg PatProgram
+				prg.ProgramNumber = programNumber
+				prg.Reserved = loopDataBuffer[i+2] >> 5 & 0x3
 				prg.PID = uint16(loopDataBuffer[i+2]&0x1f)<<8 | uint16(loopDataBuffer[i+3]&0xff)
-				programs[i/4] = prg
+				programs = append(programs, prg)
 			}
 		}
 
+		// 节目数不包含NIT
+		programCount = uint8(len(programs))
+
 		// 提交临时pat表到全局pat表
-		d.globalpat.tableID = tableID
-		d.globalpat.sectionSyntaxIndicator = sectionSyntaxIndicator
-		d.globalpat.zero = zero
-		d.globalpat.reserved1 = reserved1
-		d.globalpat.sectionLength = sectionLength
-		d.globalpat.transportstreamID = transportstreamID
-		d.globalpat.reserved2 = reserved2
-		d.globalpat.versionNumber = versionNumber
-		d.globalpat.currentNextIndicator = currentNextIndicator
-		d.globalpat.sectionNumber = sectionNumber
-		d.globalpat.lastSectionNumber = lastSectionNumber
-		d.globalpat.networkPID = networkPID
+		d.globalpat.TableID = tableID
+		d.globalpat.SectionSyntaxIndicator = sectionSyntaxIndicator
+		d.globalpat.Zero = zero
+		d.globalpat.Reserved1 = reserved1
+		d.globalpat.SectionLength = sectionLength
+		d.globalpat.TransportstreamID = transportstreamID
+		d.globalpat.Reserved2 = reserved2
+		d.globalpat.VersionNumber = versionNumber
+		d.globalpat.CurrentNextIndicator = currentNextIndicator
+		d.globalpat.SectionNumber = sectionNumber
+		d.globalpat.LastSectionNumber = lastSectionNumber
+		d.globalpat.NetworkPID = networkPID
 		d.globalpat.CRC = CRC
-		d.globalpat.programCount = programCount
+		d.globalpat.ProgramCount = programCount
 		d.globalpat.pLoopData = loopDataBuffer
-		d.globalpat.programs = programs
+		d.globalpat.Programs = programs
 
 		Log.Debug("识别到pat表，PID：" + fmt.Sprint(pHeader.PID))
 		Log.Debug(fmt.Sprint(d.globalpat))
+
+		if d.OnPat != nil {
+			pat := d.globalpat
+			d.OnPat(&pat)
+		}
 	}
 
 	return nil
 }
 
 // 解析pmt表数据
-func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
+func (d *Demuxer) readpmt(payload []byte, pHeader *Header) error {
+
+	// 校验段长度及CRC，损坏的段将被忽略，等待下一个有效的段
+	if !d.verifySection(payload, 16) {
+		return nil
+	}
 
 	// 获取临时pmt信息
 	tableID := payload[0]
+
+	// pmt所在的PID上可能有其他表，只解析 table_id 为 0x02 的段
+	if tableID != 0x02 {
+		Log.Debug("Ignore section on pmt PID: " + fmt.Sprint(pHeader.PID) + ", tableID: " + fmt.Sprint(tableID))
+		return nil
+	}
+
 	sectionSyntaxIndicator := payload[1] >> 7 & 0x1
 	zero := payload[1] >> 6 & 0x1
 	reserved1 := payload[1] >> 4 & 0x3
@@ -439,6 +743,11 @@ func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
 		return nil
 	}
 
+	// 非选择的节目
+	if d.selectProgram != 0 && programNumber != d.selectProgram {
+		return nil
+	}
+
 	// 检测固定位
 	if zero != 0x0 {
 		err := errors.NewError(errors.ErrorCodeDemuxFailed, "pmt parse error!zero!")
@@ -463,6 +772,10 @@ func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
 	// 提取循环部分字节数组
 	var loopStartPos int = 12 + int(programInfoLength)
 	var loopLength int = int(sectionLength) - 13 - int(programInfoLength)
+	if loopLength < 0 {
+		err := errors.NewError(errors.ErrorCodeDemuxFailed, "pmt parse error!programInfoLength!")
+		return err
+	}
 
 	// 循环数据数组起始
 	var pLoopData []byte = payload[loopStartPos : loopStartPos+loopLength]
@@ -487,7 +800,7 @@ func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
 		var streamcount uint8 = 0
 
 		// 获取有多少流
-		for pos < len(pLoopData) {
+		for pos+5 <= len(pLoopData) {
 			ESInfoLength := (uint16(pLoopData[pos+3]&0xf) << 8) | uint16(pLoopData[pos+4])
 			if ESInfoLength > 0 {
 				pos += int(ESInfoLength)
@@ -499,21 +812,24 @@ func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
 		// 寄存流总数
 		streamCount = streamcount
 
-		var streams []stream = make([]stream, streamCount)
+		var streams []Stream = make([]Stream, streamCount)
 
 		pos = 0
 		streamcount = 0
-		for pos < len(pLoopData) {
-			var s stream
-			s.streamType = pLoopData[pos]
-			s.reserved1 = (pLoopData[pos+1] >> 5) & 0x7
-			s.elementaryPID = uint16(pLoopData[pos+1]&0x1f)<<8 | uint16(pLoopData[pos+2])
-			s.reserved2 = (pLoopData[pos+3] >> 4) & 0xf
+		for pos+5 <= len(pLoopData) && streamcount < streamCount {
+			var s Stream
+			s.StreamType = pLoopData[pos]
+			s.Reserved1 = (pLoopData[pos+1] >> 5) & 0x7
+			s.ElementaryPID = uint16(pLoopData[pos+1]&0x1f)<<8 | uint16(pLoopData[pos+2])
+			s.Reserved2 = (pLoopData[pos+3] >> 4) & 0xf
 			s.ESInfoLength = uint16(pLoopData[pos+3]&0xf)<<8 | uint16(pLoopData[pos+4])
 
 			if s.ESInfoLength > 0 {
 
-				// TODO 暂未解析
+				// 描述信息，复制一份，包数据在读取下一个包后失效
+				if pos+5+int(s.ESInfoLength) <= len(pLoopData) {
+					s.Descriptors = append([]byte(nil), pLoopData[pos+5:pos+5+int(s.ESInfoLength)]...)
+				}
 				pos += int(s.ESInfoLength)
 			}
 
@@ -522,92 +838,233 @@ func (d *Demuxer) readpmt(payload []byte, pHeader *header) error {
 			streamcount++
 		}
 
-		d.globalpmt.tableID = tableID
-		d.globalpmt.sectionSyntaxIndicator = sectionSyntaxIndicator
-		d.globalpmt.zero = zero
-		d.globalpmt.reserved1 = reserved1
-		d.globalpmt.sectionLength = sectionLength
-		d.globalpmt.programNumber = programNumber
-		d.globalpmt.reserved2 = reserved2
-		d.globalpmt.versionNumber = versionNumber
-		d.globalpmt.currentNextIndicator = currentNextIndicator
-		d.globalpmt.sectionNumber = sectionNumber
-		d.globalpmt.lastSectionNumber = lastSectionNumber
-		d.globalpmt.reserved3 = reserved3
+		d.globalpmt.TableID = tableID
+		d.globalpmt.SectionSyntaxIndicator = sectionSyntaxIndicator
+		d.globalpmt.Zero = zero
+		d.globalpmt.Reserved1 = reserved1
+		d.globalpmt.SectionLength = sectionLength
+		d.globalpmt.ProgramNumber = programNumber
+		d.globalpmt.Reserved2 = reserved2
+		d.globalpmt.VersionNumber = versionNumber
+		d.globalpmt.CurrentNextIndicator = currentNextIndicator
+		d.globalpmt.SectionNumber = sectionNumber
+		d.globalpmt.LastSectionNumber = lastSectionNumber
+		d.globalpmt.Reserved3 = reserved3
 		d.globalpmt.PcrPID = PcrPID
-		d.globalpmt.reserved4 = reserved4
-		d.globalpmt.programInfoLength = programInfoLength
+		d.globalpmt.Reserved4 = reserved4
+		d.globalpmt.ProgramInfoLength = programInfoLength
 		d.globalpmt.CRC = CRC
-		d.globalpmt.streamCount = streamCount
+		d.globalpmt.StreamCount = streamCount
 		d.globalpmt.pLoopData = loopDataBuffer
-		d.globalpmt.streams = streams
+		d.globalpmt.Streams = streams
 
 		var isVideoFound bool = false
 		var isAudioFound bool = false
 		var i int
 
 		// 设置视频、音频
-		for i = 0; i < int(d.globalpmt.streamCount); i++ {
+		for i = 0; i < int(d.globalpmt.StreamCount); i++ {
 
 			// h.264编码对应0x1b
+			// h.265编码对应0x24
 			// aac编码对应0x0f
-			if d.globalpmt.streams[i].streamType == 0x1b && !isVideoFound {
-				d.curVideoPID = int(d.globalpmt.streams[i].elementaryPID)
+			streamType := d.globalpmt.Streams[i].StreamType
+			if (streamType == StreamTypeH264 || streamType == StreamTypeHEVC) && !isVideoFound {
+				d.curVideoPID = int(d.globalpmt.Streams[i].ElementaryPID)
+				d.curVideoType = streamType
 				isVideoFound = true
 			}
-			if d.globalpmt.streams[i].streamType == 0x0f && !isAudioFound {
-				d.curAudioPID = int(d.globalpmt.streams[i].elementaryPID)
+			if audioType := getAudioStreamType(&d.globalpmt.Streams[i]); audioType != 0 && !isAudioFound {
+				d.curAudioPID = int(d.globalpmt.Streams[i].ElementaryPID)
+				d.curAudioType = audioType
 				isAudioFound = true
 			}
 		}
 
+		// 有视频时使用视频建立索引，纯音频时使用音频建立索引
+		if isVideoFound {
+			d.curIndexPID = d.curVideoPID
+		} else if isAudioFound {
+			d.curIndexPID = d.curAudioPID
+		}
+
 		Log.Debug("识别到pmt表，PID：" + fmt.Sprint(pHeader.PID) + ", streamcount: " + fmt.Sprint(streamCount))
 		Log.Debug("识别到当前视频流，PID：" + fmt.Sprint(d.curVideoPID))
 		Log.Debug("识别到当前音频流，PID：" + fmt.Sprint(d.curAudioPID))
 		Log.Debug(fmt.Sprint(d.globalpmt))
+
+		if d.OnPmt != nil {
+			pmt := d.globalpmt
+			d.OnPmt(&pmt)
+		}
 	}
 
 	return nil
 }
 
+// readSections 拼接PSI段，返回当前包中已完整的段
+// 段可能跨越多个ts包，一个包中也可能包含多个段
+func (d *Demuxer) readSections(payload []byte, pHeader *Header) [][]byte {
+
+	var sections [][]byte = make([][]byte, 0)
+
+	if pHeader.PayloadUnitStartIndicator == 0x01 {
+
+		// 对于PSI,payloadUnitStartIndicator 为1时
+		// 有效载荷开始的位置应再偏移 1 + pointer_field 个字节，pointer_field 之前为上一个段的剩余数据
+		if len(payload) == 0 {
+			return sections
+		}
+		pointerField := int(payload[0])
+		payload = payload[1:]
+		if pointerField > len(payload) {
+			d.dropSection(pHeader.PID)
+			return sections
+		}
+
+		// 上一个段的剩余数据，拼接后仍不完整时丢弃
+		if len(d.sectionMap[pHeader.PID]) > 0 {
+			buffer := append(d.sectionMap[pHeader.PID], payload[0:pointerField]...)
+			if len(buffer) >= 3 && 3+getSectionLength(buffer) <= len(buffer) {
+				sections = append(sections, buffer[0:3+getSectionLength(buffer)])
+				d.sectionMap[pHeader.PID] = nil
+			} else {
+				d.dropSection(pHeader.PID)
+			}
+		}
+		payload = payload[pointerField:]
+
+		// 新的段，0xFF 为填充字节
+		for len(payload) > 0 && payload[0] != 0xFF {
+
+			// 段不完整，等待后续包
+			if len(payload) < 3 || 3+getSectionLength(payload) > len(payload) {
+				d.sectionMap[pHeader.PID] = append(make([]byte, 0, 1024), payload...)
+				break
+			}
+
+			plen := 3 + getSectionLength(payload)
+			sections = append(sections, payload[0:plen])
+			payload = payload[plen:]
+		}
+
+	} else {
+
+		// 未找到段起始，丢弃
+		if len(d.sectionMap[pHeader.PID]) == 0 {
+			return sections
+		}
+
+		buffer := append(d.sectionMap[pHeader.PID], payload...)
+		if len(buffer) >= 3 && 3+getSectionLength(buffer) <= len(buffer) {
+			sections = append(sections, buffer[0:3+getSectionLength(buffer)])
+			d.sectionMap[pHeader.PID] = nil
+		} else {
+			d.sectionMap[pHeader.PID] = buffer
+		}
+	}
+
+	return sections
+}
+
+// dropSection 丢弃未完整的段并计数，段的后续数据丢失时调用
+func (d *Demuxer) dropSection(PID uint16) {
+	if len(d.sectionMap[PID]) > 0 {
+		d.statistics.SectionErrorCount++
+		Log.Warn("Incomplete section dropped, PID: " + fmt.Sprint(PID) + ", offset: " + fmt.Sprint(d.curOffset))
+	}
+	d.sectionMap[PID] = nil
+}
+
+// getSectionLength 获取段长度字段，即该字段之后的数据长度
+func getSectionLength(section []byte) int {
+	return int(section[1]&0x0f)<<8 | int(section[2])
+}
+
+// verifySection 校验PSI段的长度及CRC
+//
+//	payload 以table_id开始的段数据
+//	minLength 段的最小长度(包含CRC)
+func (d *Demuxer) verifySection(payload []byte, minLength int) bool {
+
+	if len(payload) < 3 {
+		return false
+	}
+
+	// 段总长度
+	sectionLength := uint16(payload[1]&0x0f)<<8 | uint16(payload[2])
+	var plen int = 3 + int(sectionLength)
+
+	// 段长度错误或段不完整
+	if plen < minLength || plen > len(payload) {
+		Log.Debug("Section length error or section is not complete, tableID: " + fmt.Sprint(payload[0]))
+		return false
+	}
+
+	// 包含CRC字段计算结果应为0
+	if crc32Mpeg2(payload[0:plen]) != 0 {
+		d.statistics.CRCErrorCount++
+		Log.Warn("Section CRC check failed, tableID: " + fmt.Sprint(payload[0]) + ", offset: " + fmt.Sprint(d.curOffset-uint64(d.pkgSize)))
+		return false
+	}
+
+	return true
+}
+
 // 读取pes有效载荷，得到帧数据
-func (d *Demuxer) readPesPayload(payload []byte, pHeader *header) (*Pes, error) {
+func (d *Demuxer) readPesPayload(payload []byte, pHeader *Header) (*Pes, error) {
 
 	var pesResult *Pes
 	var err error
 
 	// ts包中含有新pes包头时
-	if pHeader.payloadUnitStartIndicator == 0x1 {
+	if pHeader.PayloadUnitStartIndicator == 0x1 {
 
 		if len(d.bufferMap[pHeader.PID]) > 0 {
 
-			// 解析PES数据
+			// 解析PES数据，解析失败时仍需记录新pes，错误在最后返回
 			pesResult, err = d.readPes(d.bufferMap[pHeader.PID], pHeader)
 
-			// 清空旧数据
-			d.bufferMap[pHeader.PID] = d.bufferMap[pHeader.PID][0:0]
-			d.curPesLen = -1
+			// 清空旧数据，pes有效载荷引用原缓存，不能复用
+			d.bufferMap[pHeader.PID] = nil
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		// 记录新pes起始包信息，curOffset 已指向当前包结尾
+		startInfo := &pesStartInfo{
+			offset:          d.curOffset - uint64(d.pkgSize),
+			endOffset:       d.curOffset,
+			adaptationField: pHeader.AdaptationField,
+			pcr:             d.lastPCR,
 		}
 
+		// 不连续状态指示只作用于索引流
+		if int(pHeader.PID) == d.curIndexPID {
+			startInfo.discontinuity = d.discontinuity
+			d.discontinuity = false
+		}
+		d.pesStartMap[pHeader.PID] = startInfo
 		d.bufferMap[pHeader.PID] = append(d.bufferMap[pHeader.PID], payload...)
 
+		if err != nil {
+			return nil, err
+		}
+
 	} else {
 
 		d.bufferMap[pHeader.PID] = append(d.bufferMap[pHeader.PID], payload...)
+		if startInfo, ok := d.pesStartMap[pHeader.PID]; ok {
+			startInfo.endOffset = d.curOffset
+		}
 
-		// 判断是否已经满足本帧的长度
-		if d.curPesLen > 0 && d.curPesLen == len(d.bufferMap[pHeader.PID]) {
+		// 判断是否已经满足本帧的长度，PES_packet_length 为0时(视频流)需要等待下一个pes开始
+		pesBuffer := d.bufferMap[pHeader.PID]
+		if len(pesBuffer) >= 6 && getPesLength(pesBuffer) > 6 && len(pesBuffer) >= getPesLength(pesBuffer) {
 
 			// 解析PES数据
-			pesResult, err = d.readPes(d.bufferMap[pHeader.PID], pHeader)
+			pesResult, err = d.readPes(pesBuffer[0:getPesLength(pesBuffer)], pHeader)
 
 			// 清空旧数据
-			d.bufferMap[pHeader.PID] = d.bufferMap[pHeader.PID][0:0]
-			d.curPesLen = -1
+			d.bufferMap[pHeader.PID] = nil
 
 			if err != nil {
 				return nil, err
@@ -621,30 +1078,51 @@ func (d *Demuxer) readPesPayload(payload []byte, pHeader *header) (*Pes, error)
 	return nil, nil
 }
 
+// getPesLength 获取pes包总长度，PES_packet_length 为0(长度不限)时返回6
+func getPesLength(pesBuffer []byte) int {
+	return 6 + (int(pesBuffer[4])<<8 | int(pesBuffer[5]))
+}
+
+// isElementaryPID 是否为选择的节目中的基本流
+func (d *Demuxer) isElementaryPID(PID uint16) bool {
+	for _, s := range d.globalpmt.Streams {
+		if s.ElementaryPID == PID {
+			return true
+		}
+	}
+	return false
+}
+
 // PES包解析
-func (d *Demuxer) readPes(pesBuffer []byte, pHeader *header) (*Pes, error) {
+func (d *Demuxer) readPes(pesBuffer []byte, pHeader *Header) (*Pes, error) {
 
 	var tp Pes
 
-	tp.pesStartCodePrefix = uint32(pesBuffer[0])<<16 | uint32(pesBuffer[1])<<8 | uint32(pesBuffer[2])
+	// 至少包含固定的pes包头
+	if len(pesBuffer) < 9 {
+		err := errors.NewError(errors.ErrorCodeDemuxFailed, "pes length error!")
+		return nil, err
+	}
 
-	if tp.pesStartCodePrefix != 0x001 {
+	tp.PESStartCodePrefix = uint32(pesBuffer[0])<<16 | uint32(pesBuffer[1])<<8 | uint32(pesBuffer[2])
+
+	if tp.PESStartCodePrefix != 0x001 {
 		err := errors.NewError(errors.ErrorCodeDemuxFailed, "pesStartCodePrefix error!")
 		return nil, err
 	}
-	tp.streamID = pesBuffer[3]
+	tp.StreamID = pesBuffer[3]
 	tp.PESPacketLength = uint16(pesBuffer[4])<<8 | uint16(pesBuffer[5])
-	tp.twobit10 = pesBuffer[6] >> 6 & 0x3
+	tp.Twobit10 = pesBuffer[6] >> 6 & 0x3
 	tp.PESScramblingControl = pesBuffer[6] >> 4 & 0x3
 	tp.PESPriority = pesBuffer[6] >> 3 & 0x1
-	tp.dataAlignmentIndicator = pesBuffer[6] >> 2 & 0x1
-	tp.copyright = pesBuffer[6] >> 1 & 0x1
-	tp.originalOrCopy = pesBuffer[6] & 0x1
+	tp.DataAlignmentIndicator = pesBuffer[6] >> 2 & 0x1
+	tp.Copyright = pesBuffer[6] >> 1 & 0x1
+	tp.OriginalOrCopy = pesBuffer[6] & 0x1
 	tp.PtsDtsFlags = pesBuffer[7] >> 6 & 0x3
 	tp.ESCRFlag = pesBuffer[7] >> 5 & 0x1
 	tp.ESRateFlag = pesBuffer[7] >> 4 & 0x1
 	tp.DSMTrickModeFlag = pesBuffer[7] >> 3 & 0x1
-	tp.additionalCopyInfoFlag = pesBuffer[7] >> 2 & 0x1
+	tp.AdditionalCopyInfoFlag = pesBuffer[7] >> 2 & 0x1
 	tp.PESCRCFlag = pesBuffer[7] >> 1 & 0x1
 	tp.PESExtensionFlag = pesBuffer[7] & 0x1
 	tp.PESHeaderDataLength = pesBuffer[8]
@@ -652,6 +1130,12 @@ func (d *Demuxer) readPes(pesBuffer []byte, pHeader *header) (*Pes, error) {
 	// 可选域字节索引
 	var optFieldIDx int = 9
 
+	// pes包头长度不足以容纳时间戳
+	if (tp.PtsDtsFlags == 0x2 && len(pesBuffer) < optFieldIDx+5) || (tp.PtsDtsFlags == 0x3 && len(pesBuffer) < optFieldIDx+10) {
+		err := errors.NewError(errors.ErrorCodeDemuxFailed, "pes header length error!")
+		return nil, err
+	}
+
 	// PTS(presentation time stamp 显示时间标签)
 	// DTS(decoding time stamp 解码时间标签)标志位
 	if tp.PtsDtsFlags == 0x2 {
@@ -677,15 +1161,129 @@ func (d *Demuxer) readPes(pesBuffer []byte, pHeader *header) (*Pes, error) {
 		optFieldIDx += 10
 	}
 
-	tp.PkgOffset = d.curOffset
-	tp.ptime = tp.PTS / 90
-	tp.dtime = tp.DTS / 90
+	// 视频帧检查NAL单元，判断是否为关键帧
+	var payloadStart int = 9 + int(tp.PESHeaderDataLength)
+	if payloadStart < len(pesBuffer) {
+		tp.Payload = pesBuffer[payloadStart:]
+	}
+	if int(pHeader.PID) == d.curVideoPID && tp.Payload != nil {
+		d.readVideoES(&tp, tp.Payload)
+	}
+
+	// 音频帧均可随机访问
+	if int(pHeader.PID) == d.curAudioPID {
+		tp.IsKeyFrame = true
+
+		// 首个 ADTS 帧头中提取编码信息
+		if d.curAudioType == StreamTypeAAC && d.audioCodec.StreamType == 0 {
+			if info, ok := ParseAdtsHeader(tp.Payload); ok {
+				d.audioCodec = info
+			}
+		}
+
+		// 首个 MPEG 音频帧头中提取音频层
+		if (d.curAudioType == StreamTypeMP3 || d.curAudioType == StreamTypeMP2) && d.audioCodec.StreamType == 0 {
+			if info, ok := ParseMpegAudioHeader(tp.Payload, d.curAudioType); ok {
+				d.audioCodec = info
+			}
+		}
+	}
+
+	// 起始包信息
+	tp.PCR = -1
+	if startInfo, ok := d.pesStartMap[pHeader.PID]; ok {
+		tp.PkgOffset = startInfo.offset
+		tp.PkgEndOffset = startInfo.endOffset
+		tp.AdaptationField = startInfo.adaptationField
+		tp.PCR = startInfo.pcr
+		tp.Discontinuity = startInfo.discontinuity
+	}
+
 	tp.PID = pHeader.PID
 	return &tp, nil
 }
 
+// getAudioStreamType 获取音频流类型，非支持的音频流返回0
+// DVB 中 ac-3 使用私有数据pes，需要检查描述符
+func getAudioStreamType(s *Stream) uint8 {
+
+	switch s.StreamType {
+	case StreamTypeAAC, StreamTypeMP3, StreamTypeMP2, StreamTypeAC3:
+		return s.StreamType
+	case streamTypePES:
+		if findDescriptor(s.Descriptors, descriptorTagAC3) != nil {
+			return StreamTypeAC3
+		}
+	}
+
+	return 0
+}
+
+// findDescriptor 在描述信息中查找指定标签的描述符，返回描述符内容，未找到时返回nil
+func findDescriptor(descriptors []byte, tag uint8) []byte {
+
+	var pos int = 0
+	for pos+2 <= len(descriptors) {
+		descriptorTag := descriptors[pos]
+		descriptorLength := int(descriptors[pos+1])
+
+		if pos+2+descriptorLength > len(descriptors) {
+			break
+		}
+
+		if descriptorTag == tag {
+			return descriptors[pos+2 : pos+2+descriptorLength]
+		}
+		pos += 2 + descriptorLength
+	}
+
+	return nil
+}
+
+// readVideoES 解析视频ES数据，判断是否为关键帧，并提取编码信息
+func (d *Demuxer) readVideoES(tp *Pes, es []byte) {
+
+	for _, nal := range splitNalUnits(es) {
+
+		if len(nal) == 0 {
+			continue
+		}
+
+		switch d.curVideoType {
+		case StreamTypeH264:
+
+			// IDR图像
+			nalType := h264NalType(nal)
+			if nalType == h264NalTypeIDR {
+				tp.IsKeyFrame = true
+			}
+
+			// 首个序列参数集中提取编码信息
+			if nalType == h264NalTypeSPS && d.videoCodec.StreamType == 0 {
+				if info, ok := parseH264Sps(nal); ok {
+					d.videoCodec = info
+				}
+			}
+		case StreamTypeHEVC:
+
+			// IRAP图像(BLA/IDR/CRA)
+			nalType := hevcNalType(nal)
+			if nalType >= hevcNalTypeIRAPMin && nalType <= hevcNalTypeIRAPMax {
+				tp.IsKeyFrame = true
+			}
+
+			// 首个序列参数集中提取编码信息
+			if nalType == hevcNalTypeSPS && d.videoCodec.StreamType == 0 {
+				if info, ok := parseHevcSps(nal); ok {
+					d.videoCodec = info
+				}
+			}
+		}
+	}
+}
+
 // 追加TS 分段语法缓存
-func (d *Demuxer) storeTsSectionData(sectionNumber uint8, pLoopData []byte, pHeader *header) {
+func (d *Demuxer) storeTsSectionData(sectionNumber uint8, pLoopData []byte, pHeader *Header) {
 
 	// 当前段是第一个分段
 	if sectionNumber == 0x00 {
@@ -3,10 +3,12 @@ package ts
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	common "../common"
@@ -18,38 +20,144 @@ import (
 
 // Indexer TS文件索引创建器
 type Indexer struct {
-	indexFilePath string  // 索引文件路径
-	frameArray    []Frame // 帧时间片集合列表
-	minTime       int     // 最小显示时间戳
-	maxTime       int     // 最大显示时间戳
+	indexFilePath  string  // 索引文件路径
+	frameArray     []Frame // 帧时间片集合列表
+	minTime        int64   // 最小显示时间戳
+	maxTime        int64   // 最大显示时间戳
+	timeOffset     int64   // 不连续点产生的时间偏移
+	lastDecodeTime int64   // 上一帧解码时间戳
+	frameInterval  int64   // 最近的帧间隔
+	lastPts        int64   // 上一帧回绕处理前的时间戳，-1 表示无
+	wrapOffset     int64   // 时间戳回绕产生的时间偏移
 }
 
-// Frame 以秒为单位的时间片
+// Frame 帧信息，时间单位为 TimeScale
 type Frame struct {
-	Time        float32 // 最小时间
-	StartOffset uint64  // 开始偏移量
+	Time          int64  // 显示时间
+	DecodeTime    int64  // 解码时间
+	StartOffset   uint64 // 开始偏移量
+	Size          uint64 // 从起始包到最后一个包结尾的字节数
+	IsKeyFrame    bool   // 是否为关键帧
+	Discontinuity bool   // 时间戳是否与前一帧不连续
 }
 
 // MediaFileIndex ts文件索引
 type MediaFileIndex struct {
-	VideoSize  uint64      // 视频文件大小
-	BindWidth  uint32      // 带宽(比特率)
-	Duration   uint32      // 总时长
-	TimesArray []TimeSlice // 时间片集合列表
+	VideoSize       uint64          // 视频文件大小
+	BindWidth       uint32          // 带宽(比特率)
+	Duration        uint32          // 总时长
+	ProgramNumber   uint16          // 节目号
+	PmtPID          uint16          // pmt所在的PID
+	PcrPID          uint16          // PCR所在的PID
+	VideoPID        uint16          // 视频流PID，0 表示无视频
+	PatSection      []byte          // 根据pat表生成的pat段，用于在分片开始处插入
+	PmtSection      []byte          // 根据pmt表生成的pmt段，用于在分片开始处插入
+	PkgSize         int             // 源文件包大小(188/192/204)
+	VideoCodec      VideoCodecInfo  // 视频编码信息
+	AudioCodec      AudioCodecInfo  // 音频编码信息
+	AudioTracks     []AudioTrack    // 音频轨道，第一个为默认音频
+	Statistics      Statistics      // 解封装统计信息
+	Service         ServiceInfo     // 业务描述信息，ServiceID 为 0 表示未识别到SDT
+	CorruptedRanges []ByteRange     // 重新同步时跳过的损坏数据区间
+	StartPts        int64           // 媒体开始时间对应的33bit时间戳
+	SubtitleTracks  []SubtitleTrack // 字幕轨道
+	SubtitleCues    []SubtitleCue   // 字幕条目，按开始时间排序
+	SplicePoints    []SplicePoint   // SCTE-35 切点，按时间排序
+	KeyFrames       []KeyFrame      // 视频关键帧，按解码顺序排列，无视频时为空
+	TimesArray      []TimeSlice     // 时间片集合列表
 }
 
-// TimeSlice 以秒为单位的时间片
+// IsAudioOnly 是否为纯音频文件
+func (mediaFileIndex *MediaFileIndex) IsAudioOnly() bool {
+	return mediaFileIndex.VideoCodec.StreamType == 0 && mediaFileIndex.AudioCodec.StreamType != 0
+}
+
+// GetProgramPsi 分片开始处插入的pat、pmt，索引中不包含时返回nil
+func (mediaFileIndex *MediaFileIndex) GetProgramPsi() *ProgramPsi {
+	if len(mediaFileIndex.PatSection) == 0 || len(mediaFileIndex.PmtSection) == 0 {
+		return nil
+	}
+	return &ProgramPsi{PmtPID: mediaFileIndex.PmtPID, PatSection: mediaFileIndex.PatSection, PmtSection: mediaFileIndex.PmtSection}
+}
+
+// GetFilteredProgramPsi 按PID过滤输出的分片开始处插入的pat、pmt，pmt只包含保留的基本流，索引中不包含时返回nil
+// PCR_PID 保持不变，PCR 所在的流未保留时由过滤输出其中带PCR的适配域
+//
+//	PIDs 保留的基本流PID
+func (mediaFileIndex *MediaFileIndex) GetFilteredProgramPsi(PIDs []uint16) *ProgramPsi {
+	psi := mediaFileIndex.GetProgramPsi()
+	if psi == nil {
+		return nil
+	}
+	psi.PmtSection = filterPmtSection(mediaFileIndex.PmtSection, PIDs)
+	if psi.PmtSection == nil {
+		return nil
+	}
+	return psi
+}
+
+// IsDamaged 是否存在损坏的数据或传输错误
+func (mediaFileIndex *MediaFileIndex) IsDamaged() bool {
+	statistics := &mediaFileIndex.Statistics
+	return statistics.CRCErrorCount > 0 || statistics.SectionErrorCount > 0 || statistics.ErrorPacketCount > 0 ||
+		statistics.CCErrorCount > 0 || statistics.TEICount > 0 || len(mediaFileIndex.CorruptedRanges) > 0
+}
+
+// TimeSlice 时间片，时间单位为 TimeScale，从媒体开始时计时
 type TimeSlice struct {
-	MinTime     float32 // 最小时间
-	MaxTime     float32 // 最大时间
-	StartOffset uint64  // 开始偏移量
+	MinTime       int64  // 最小时间
+	MaxTime       int64  // 最大时间
+	StartOffset   uint64 // 开始偏移量
+	IsKeyFrame    bool   // 是否以关键帧开始
+	Discontinuity bool   // 是否从不连续点开始
+}
+
+// KeyFrame 视频关键帧，时间单位为 TimeScale，从媒体开始时计时
+type KeyFrame struct {
+	Time          int64  // 显示时间
+	StartOffset   uint64 // 起始包的偏移量
+	Size          uint32 // 从起始包到最后一个包结尾的字节数
+	Discontinuity bool   // 与前一关键帧之间存在不连续点
 }
 
+// TimeScale 时间单位，每秒 90000，与 PTS 相同
+const TimeScale int64 = 90000
+
+// ptsWrap 33bit 时间戳的回绕周期
+const ptsWrap int64 = 1 << 33
+
 // Log 系统日志
 var Log *ezlog.Log
 
 // VERSION 索引版本号
-const VERSION uint8 = 0
+const VERSION uint8 = 17
+
+// mediaFileSuffixes 支持的媒体文件后缀，按查找顺序排列
+var mediaFileSuffixes = []string{".ts", ".m2ts", ".mts"}
+
+// maxCorruptedRatio 损坏数据占文件大小的最大比例，超过时索引创建失败
+const maxCorruptedRatio float64 = 0.5
+
+// 索引文本块类型
+const (
+	textTypeProviderName uint8 = 1 // 业务提供者名称
+	textTypeServiceName  uint8 = 2 // 业务名称
+	textTypeCue          uint8 = 3 // 字幕文本，属于前一个字幕条目
+	textTypeSpliceData   uint8 = 4 // splice_info_section 原始数据，属于前一个切点
+	textTypePatSection   uint8 = 5 // pat段
+	textTypePmtSection   uint8 = 6 // pmt段
+)
+
+// 扩展记录类型，type = 15 时由载荷的第一个字节区分
+const (
+	extTypeMediaFormat uint8 = 1 // 图像尺寸、帧率及音频格式
+	extTypeProgramPIDs uint8 = 2 // 节目的pmt、PCR及视频流PID
+	extTypeAudioTrack  uint8 = 3 // 音频轨道，按顺序编号
+	extTypeKeyFrame    uint8 = 4 // 视频关键帧，按解码顺序排列
+)
+
+// textChunkSize 每个文本块的最大字节数
+const textChunkSize = 13
 
 // Init 初始化
 func Init() {
@@ -58,7 +166,8 @@ func Init() {
 
 // GetMediaFileIndex 获取ts文件索引
 //  baseFileURINoSuffix 不带后缀的请求路径
-func GetMediaFileIndex(baseFileURINoSuffix string) (*MediaFileIndex, error) {
+//	programNumber 节目号，0 表示第一个节目
+func GetMediaFileIndex(baseFileURINoSuffix string, programNumber uint16) (*MediaFileIndex, error) {
 
 	Log.Debug("GetMediaFileIndex baseFileURINoSuffix:" + baseFileURINoSuffix)
 
@@ -72,10 +181,16 @@ func GetMediaFileIndex(baseFileURINoSuffix string) (*MediaFileIndex, error) {
 	var err error
 
 	// 获得索引文件本地路径
-	var indexFileLocalPath = getIndexFilePath(baseFileURINoSuffix)
+	var indexFileLocalPath = getIndexFilePath(baseFileURINoSuffix, programNumber)
+
+	// 获得媒体文件本地路径
+	tsFilePath, err := GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return nil, err
+	}
 
 	// 尝试读取索引文件
-	mediaFileIndex, err = readIndexFile(indexFileLocalPath)
+	mediaFileIndex, err = readIndexFile(indexFileLocalPath, tsFilePath)
 
 	// 读取索引文件失败，重新创建索引
 	if err != nil {
@@ -84,7 +199,7 @@ func GetMediaFileIndex(baseFileURINoSuffix string) (*MediaFileIndex, error) {
 
 		// 索引器 TODO 需要加锁
 		var indexer Indexer
-		mediaFileIndex, err = indexer.createIndexFile(indexFileLocalPath)
+		mediaFileIndex, err = indexer.createIndexFile(indexFileLocalPath, tsFilePath, programNumber)
 
 		// 创建索引失败
 		if err != nil {
@@ -102,17 +217,24 @@ func GetMediaFileIndex(baseFileURINoSuffix string) (*MediaFileIndex, error) {
 
 // CreateMediaFileIndex 手动创建ts文件索引
 //  baseFileURINoSuffix 不带后缀的请求路径
-func CreateMediaFileIndex(baseFileURINoSuffix string) error {
+//	programNumber 节目号，0 表示第一个节目
+func CreateMediaFileIndex(baseFileURINoSuffix string, programNumber uint16) error {
 
 	Log.Debug("CreateMediaFileIndex baseFileURINoSuffix:" + baseFileURINoSuffix)
 
 	var err error
 
 	// 获得索引文件本地路径
-	var indexFileLocalPath = getIndexFilePath(baseFileURINoSuffix)
+	var indexFileLocalPath = getIndexFilePath(baseFileURINoSuffix, programNumber)
+
+	// 获得媒体文件本地路径
+	tsFilePath, err := GetMediaFilePath(baseFileURINoSuffix)
+	if err != nil {
+		return err
+	}
 
 	// 尝试读取索引文件
-	_, err = readIndexFile(indexFileLocalPath)
+	_, err = readIndexFile(indexFileLocalPath, tsFilePath)
 
 	// 读取索引文件失败，重新创建索引
 	if err != nil {
@@ -120,7 +242,7 @@ func CreateMediaFileIndex(baseFileURINoSuffix string) error {
 		Log.Debug("Now try to build new one.")
 
 		var indexer Indexer
-		_, err = indexer.createIndexFile(indexFileLocalPath)
+		_, err = indexer.createIndexFile(indexFileLocalPath, tsFilePath, programNumber)
 
 		// 创建索引失败
 		if err != nil {
@@ -133,30 +255,149 @@ func CreateMediaFileIndex(baseFileURINoSuffix string) error {
 	return nil
 }
 
-// feedFrame 输入帧数据
+// feedPes 将解析出的pes作为一帧加入索引
+func (indexer *Indexer) feedPes(pes *Pes) {
+
+	// pes不带时间戳时，使用节目时钟参考计时
+	var pts int64 = pes.PTS
+	var dts int64 = pes.DTS
+	if pes.PtsDtsFlags&0x2 == 0 {
+		if pes.PCR < 0 {
+			return
+		}
+		pts = pes.PCR / 300
+		dts = pts
+	}
+
+	// 适配域随机访问指示作为关键帧的补充判断
+	isKeyFrame := pes.IsKeyFrame || (pes.AdaptationField != nil && pes.AdaptationField.RandomAccessIndicator == 0x1)
+	var size uint64 = 0
+	if pes.PkgEndOffset > pes.PkgOffset {
+		size = pes.PkgEndOffset - pes.PkgOffset
+	}
+	indexer.feedFrame(pts, dts, pes.PkgOffset, size, isKeyFrame, pes.Discontinuity)
+}
+
+// feedFrame 输入帧数据，帧按解码顺序输入
 // 	pts 显示时间戳
+// 	dts 解码时间戳，无解码时间戳时与显示时间戳相同
 // 	offset 帧相对媒体文件其实位置的偏移量
-func (indexer *Indexer) feedFrame(pts int64, offset uint64) {
+// 	size 帧从起始包到最后一个包结尾的字节数
+// 	isKeyFrame 是否为关键帧
+// 	discontinuity 时间戳是否与前一帧不连续
+func (indexer *Indexer) feedFrame(pts int64, dts int64, offset uint64, size uint64, isKeyFrame bool, discontinuity bool) {
+
+	// 解码时间戳单调递增，用于处理回绕，显示时间戳按与解码时间戳的差值计算
+	discontinuity = discontinuity && len(indexer.frameArray) > 0
+	if discontinuity {
+		indexer.lastPts = -1
+	}
+	dts = indexer.unwrapPts(dts)
+	pts = dts + ((pts-dts)%ptsWrap+ptsWrap+ptsWrap/2)%ptsWrap - ptsWrap/2
+
+	// 时间戳不连续时，调整时间偏移，使后续帧接续在已有帧之后
+	if discontinuity {
+		indexer.timeOffset = indexer.maxTime + indexer.frameInterval - dts
+	}
+
+	var time int64 = pts + indexer.timeOffset
+	var decodeTime int64 = dts + indexer.timeOffset
 
 	if indexer.minTime < 0 {
-		indexer.minTime = int(pts / 90)
-	} else if indexer.minTime > int(pts/90) {
-		indexer.minTime = int(pts / 90)
+		indexer.minTime = time
+	} else if indexer.minTime > time {
+		indexer.minTime = time
 	}
 
 	if indexer.maxTime < 0 {
-		indexer.maxTime = int(pts / 90)
-	} else if indexer.maxTime < int(pts/90) {
-		indexer.maxTime = int(pts / 90)
+		indexer.maxTime = time
+	} else if indexer.maxTime < time {
+		indexer.maxTime = time
 	}
 
+	// 按解码时间记录帧间隔，用于接续不连续点及计算最后一帧的时长
+	if len(indexer.frameArray) > 0 && decodeTime > indexer.lastDecodeTime {
+		indexer.frameInterval = decodeTime - indexer.lastDecodeTime
+	}
+	indexer.lastDecodeTime = decodeTime
+
 	var f Frame
-	f.Time = float32(pts / 90)
+	f.Time = time
+	f.DecodeTime = decodeTime
 	f.StartOffset = offset
+	f.Size = size
+	f.IsKeyFrame = isKeyFrame
+	f.Discontinuity = discontinuity
 
 	indexer.frameArray = append(indexer.frameArray, f)
 }
 
+// unwrapPts 处理33bit时间戳回绕，返回连续递增的时间戳
+// 与上一帧相差超过半个回绕周期时认为发生了回绕，回绕前的乱序帧仍按回绕前计算
+func (indexer *Indexer) unwrapPts(pts int64) int64 {
+
+	if indexer.lastPts >= 0 {
+		diff := pts - indexer.lastPts
+		if diff < -ptsWrap/2 {
+			indexer.wrapOffset += ptsWrap
+			Log.Debug("Pts wraparound, pts: " + fmt.Sprint(pts) + ", last pts: " + fmt.Sprint(indexer.lastPts))
+		} else if diff > ptsWrap/2 {
+			return pts + indexer.wrapOffset - ptsWrap
+		}
+	}
+
+	indexer.lastPts = pts
+	return pts + indexer.wrapOffset
+}
+
+// mapPts 将其他流的时间戳转换为索引时间，按最近一帧的解码时间戳处理回绕，尚无帧时返回false
+func (indexer *Indexer) mapPts(pts int64) (int64, bool) {
+
+	if indexer.lastPts < 0 {
+		return 0, false
+	}
+
+	diff := ((pts-indexer.lastPts)%ptsWrap+ptsWrap+ptsWrap/2)%ptsWrap - ptsWrap/2
+	return indexer.lastPts + indexer.wrapOffset + diff + indexer.timeOffset, true
+}
+
+// addSplicePoint 按索引时间记录切点
+// 立即执行或未指定时间的切点位于最近一帧，第一帧之前的切点位于媒体开始处
+// 重复发送的同一切点只记录一次，取消事件时删除该事件尚未到达的切点
+func (indexer *Indexer) addSplicePoint(splicePoints *[]SplicePoint, event *SpliceEvent) {
+
+	time, ok := indexer.mapPts(event.PTS)
+	if event.PTS < 0 || !ok {
+		time = indexer.lastDecodeTime
+	}
+
+	if event.Cancel {
+		var points []SplicePoint = make([]SplicePoint, 0, len(*splicePoints))
+		for _, point := range *splicePoints {
+			if point.EventID != event.EventID || point.Time <= time {
+				points = append(points, point)
+			}
+		}
+		*splicePoints = points
+		return
+	}
+
+	for _, point := range *splicePoints {
+		if point.EventID == event.EventID && point.Type == event.Type && point.Time == time {
+			return
+		}
+	}
+
+	var point SplicePoint
+	point.Time = time
+	point.Duration = event.Duration
+	point.EventID = event.EventID
+	point.Type = event.Type
+	point.CommandType = event.CommandType
+	point.Data = event.Data
+	*splicePoints = append(*splicePoints, point)
+}
+
 // writeFile 将索引文件写入硬盘
 // 	pMediaFileIndex 索引数据
 //	indexFileLocalPath 索引文件本地路径
@@ -166,19 +407,56 @@ func (indexer *Indexer) feedFrame(pts int64, offset uint64) {
 // HEADER[0xf(4bit),type(4bit)],PAYLOAD(128bit),ENDFLAG[0xff(8bit)]
 //
 // type = 0 时表示索引基本信息
-// PAYLOAD[version(8bit), bindWidth(32bit),duration(32bit),reserve(56bit)]
+// PAYLOAD[version(8bit), bindWidth(32bit),duration(32bit),programNumber(16bit),pkgSize(8bit),reserve(32bit)]
 // type = 1 时表示视频文件基本信息
-// PAYLOAD[video_size(64bit), reserve(64bit)]
+// PAYLOAD[video_size(64bit),startPts(48bit),reserve(16bit)]
 // type = 2 时表示帧数据
-// PAYLOAD[mintime(32bit),maxtime(32bit),startOffset(64bit)]]
+// PAYLOAD[mintime(48bit),duration(32bit),startOffset(48bit)]
+// type = 3 时表示以关键帧开始的帧数据，PAYLOAD 同 type = 2
+// type = 4 时表示不连续点，紧随其后的帧数据从不连续点开始
+// PAYLOAD[startOffset(64bit), reserve(64bit)]
+// type = 5 时表示视频编码信息
+// PAYLOAD[streamType(8bit),profileSpace(2bit),tierFlag(1bit),profileIDC(5bit),
+// compatibilityFlags(32bit),constraintFlags(48bit),levelIDC(8bit),reserve(24bit)]
+// H.264 时 profileSpace、tierFlag 不存在，profileIDC 为 8bit
+// type = 6 时表示音频编码信息
+// PAYLOAD[streamType(8bit),layer(8bit),reserve(112bit)]
+// layer 为 MPEG-1/MPEG-2 音频层，0 表示未知
+// type = 7 时表示解封装统计信息
+// PAYLOAD[crcErrorCount(32bit),errorPacketCount(32bit),sectionErrorCount(32bit),reserve(32bit)]
+// type = 8 时表示业务描述信息
+// PAYLOAD[serviceID(16bit),serviceType(8bit),reserve(104bit)]
+// type = 9 时表示文本块，同类型文本按顺序拼接
+// PAYLOAD[textType(8bit),length(8bit),text(104bit)]
+// type = 10 时表示跳过的损坏数据区间
+// PAYLOAD[startOffset(64bit),size(64bit)]
+// type = 11 时表示单个PID的传输错误统计，24bit 的计数超出时记为最大值
+// PAYLOAD[PID(16bit),ccErrorCount(32bit),scrambledCount(32bit),duplicateCount(24bit),teiCount(24bit)]
+// type = 12 时表示字幕轨道，按顺序编号
+// PAYLOAD[PID(16bit),subtitleType(8bit),hearingImpaired(8bit),page(16bit),language(24bit),reserve(56bit)]
+// type = 13 时表示字幕条目，字幕文本为紧随其后的文本块
+// PAYLOAD[startTime(48bit),endTime(48bit),track(8bit),reserve(24bit)]
+// type = 14 时表示SCTE-35切点，splice_info_section 原始数据为紧随其后的文本块
+// PAYLOAD[time(48bit),duration(32bit),eventID(32bit),spliceType(8bit),commandType(8bit)]
+// type = 15 时表示扩展记录，extType 为扩展记录类型
+// extType = 1 时表示媒体格式
+// PAYLOAD[extType(8bit),width(16bit),height(16bit),frameRate(32bit),audioObjectType(8bit),sampleRate(24bit),channels(8bit),reserve(16bit)]
+// extType = 2 时表示节目的PID
+// PAYLOAD[extType(8bit),pmtPID(16bit),pcrPID(16bit),videoPID(16bit),reserve(72bit)]
+// extType = 3 时表示音频轨道
+// PAYLOAD[extType(8bit),PID(16bit),streamType(8bit),language(24bit),audioType(8bit),reserve(64bit)]
+// extType = 4 时表示视频关键帧，24bit 的大小超出时记为最大值
+// PAYLOAD[extType(8bit),time(40bit),startOffset(48bit),size(24bit),discontinuity(8bit)]
 //
 // version：索引版本
 // bindWidth: 媒体码率
-// duration: 总时长
+// duration: 总时长（单位秒），帧数据中为分片时长（单位 1/90000 秒）
 // reserve: 保留位，默认0
-// mintime		|最小帧时间（单位秒）(32bit)
-// maxtime		|最大帧时间（单位秒）(32bit)
-// startOffset	|分片偏移量(64bit)
+// mintime		|最小帧时间（单位 1/90000 秒）(48bit)
+// startOffset	|分片偏移量
+// startPts		|媒体开始时间对应的时间戳，用于字幕与视频对齐(48bit)
+// frameRate	|帧率（单位 1/1000 帧每秒）(32bit)
+// textType		|文本类型，1 业务提供者名称，2 业务名称，3 字幕文本，4 切点原始数据，5 pat段，6 pmt段(8bit)
 func writeFile(pMediaFileIndex *MediaFileIndex, indexFileLocalPath string) error {
 
 	var err error
@@ -217,15 +495,15 @@ func writeFile(pMediaFileIndex *MediaFileIndex, indexFileLocalPath string) error
 	// 头信息 HEADER[0xf(4bit),type=0(4bit)]
 	binary.Write(&binBuf, binary.BigEndian, uint8(0xF0))
 
-	// 载荷 PAYLOAD[version=1(8bit), bindWidth(32bit),duration(32bit),reserve(56bit)]
+	// 载荷 PAYLOAD[version=1(8bit), bindWidth(32bit),duration(32bit),programNumber(16bit),pkgSize(8bit),reserve(32bit)]
 	binary.Write(&binBuf, binary.BigEndian, uint8(VERSION))
 	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.BindWidth)
 	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Duration)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.ProgramNumber)
+	binary.Write(&binBuf, binary.BigEndian, uint8(pMediaFileIndex.PkgSize))
 
 	// 保留位
 	binary.Write(&binBuf, binary.BigEndian, uint32(0))
-	binary.Write(&binBuf, binary.BigEndian, uint16(0))
-	binary.Write(&binBuf, binary.BigEndian, uint8(0))
 
 	// ENDFLAG
 	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
@@ -236,17 +514,315 @@ func writeFile(pMediaFileIndex *MediaFileIndex, indexFileLocalPath string) error
 	// 头信息 HEADER[0xf(4bit),type=0(4bit)]
 	binary.Write(&binBuf, binary.BigEndian, uint8(0xF1))
 
-	// 载荷 PAYLOAD[video_size(64bit), reserve(64bit)]
+	// 载荷 PAYLOAD[video_size(64bit),startPts(48bit),reserve(16bit)]
 	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.VideoSize)
+	binary.Write(&binBuf, binary.BigEndian, uint48Bytes(uint64(pMediaFileIndex.StartPts)))
 
 	// 保留位
-	binary.Write(&binBuf, binary.BigEndian, uint64(0))
+	binary.Write(&binBuf, binary.BigEndian, uint16(0))
 
 	// ENDFLAG
 	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
 
 	// ========= 写入帧数据信息 END =========
 
+	// ========= 写入视频编码信息 START=========
+	if pMediaFileIndex.VideoCodec.StreamType != 0 {
+		codec := pMediaFileIndex.VideoCodec
+
+		// 头信息 HEADER[0xf(4bit),type=5(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xF5))
+
+		// 载荷 PAYLOAD[streamType(8bit),profileSpace(2bit),tierFlag(1bit),profileIDC(5bit),
+		// compatibilityFlags(32bit),constraintFlags(48bit),levelIDC(8bit),reserve(24bit)]
+		binary.Write(&binBuf, binary.BigEndian, codec.StreamType)
+		if codec.StreamType == StreamTypeH264 {
+			binary.Write(&binBuf, binary.BigEndian, codec.ProfileIDC)
+		} else {
+			binary.Write(&binBuf, binary.BigEndian, codec.ProfileSpace<<6|codec.TierFlag<<5|codec.ProfileIDC&0x1f)
+		}
+		binary.Write(&binBuf, binary.BigEndian, codec.CompatibilityFlags)
+		binary.Write(&binBuf, binary.BigEndian, uint16(codec.ConstraintFlags>>32))
+		binary.Write(&binBuf, binary.BigEndian, uint32(codec.ConstraintFlags))
+		binary.Write(&binBuf, binary.BigEndian, codec.LevelIDC)
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint16(0))
+		binary.Write(&binBuf, binary.BigEndian, uint8(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入视频编码信息 END =========
+
+	// ========= 写入音频编码信息 START=========
+	if pMediaFileIndex.AudioCodec.StreamType != 0 {
+
+		// 头信息 HEADER[0xf(4bit),type=6(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xF6))
+
+		// 载荷 PAYLOAD[streamType(8bit),layer(8bit),reserve(112bit)]
+		binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.AudioCodec.StreamType)
+		binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.AudioCodec.Layer)
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint64(0))
+		binary.Write(&binBuf, binary.BigEndian, uint32(0))
+		binary.Write(&binBuf, binary.BigEndian, uint16(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入音频编码信息 END =========
+
+	// ========= 写入媒体格式 START=========
+	// 头信息 HEADER[0xf(4bit),type=15(4bit)]
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+	// 载荷 PAYLOAD[extType(8bit),width(16bit),height(16bit),frameRate(32bit),audioObjectType(8bit),sampleRate(24bit),channels(8bit),reserve(16bit)]
+	binary.Write(&binBuf, binary.BigEndian, extTypeMediaFormat)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.VideoCodec.Width)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.VideoCodec.Height)
+	binary.Write(&binBuf, binary.BigEndian, uint32(pMediaFileIndex.VideoCodec.FrameRate*1000+0.5))
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.AudioCodec.ObjectType)
+	binary.Write(&binBuf, binary.BigEndian, uint24Bytes(pMediaFileIndex.AudioCodec.SampleRate))
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.AudioCodec.Channels)
+
+	// 保留位
+	binary.Write(&binBuf, binary.BigEndian, uint16(0))
+
+	// ENDFLAG
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+	// ========= 写入媒体格式 END =========
+
+	// ========= 写入节目PID START=========
+	// 头信息 HEADER[0xf(4bit),type=15(4bit)]
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+	// 载荷 PAYLOAD[extType(8bit),pmtPID(16bit),pcrPID(16bit),videoPID(16bit),reserve(72bit)]
+	binary.Write(&binBuf, binary.BigEndian, extTypeProgramPIDs)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.PmtPID)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.PcrPID)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.VideoPID)
+
+	// 保留位
+	binary.Write(&binBuf, binary.BigEndian, uint64(0))
+	binary.Write(&binBuf, binary.BigEndian, uint8(0))
+
+	// ENDFLAG
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+	// ========= 写入节目PID END =========
+
+	// ========= 写入pat、pmt段 START=========
+	writeTextRecords(&binBuf, textTypePatSection, string(pMediaFileIndex.PatSection))
+	writeTextRecords(&binBuf, textTypePmtSection, string(pMediaFileIndex.PmtSection))
+
+	// ========= 写入pat、pmt段 END =========
+
+	// ========= 写入音频轨道 START=========
+	for _, track := range pMediaFileIndex.AudioTracks {
+
+		// 头信息 HEADER[0xf(4bit),type=15(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+		// 载荷 PAYLOAD[extType(8bit),PID(16bit),streamType(8bit),language(24bit),audioType(8bit),reserve(64bit)]
+		var language [3]byte
+		copy(language[:], track.Language)
+		binary.Write(&binBuf, binary.BigEndian, extTypeAudioTrack)
+		binary.Write(&binBuf, binary.BigEndian, track.PID)
+		binary.Write(&binBuf, binary.BigEndian, track.StreamType)
+		binary.Write(&binBuf, binary.BigEndian, language)
+		binary.Write(&binBuf, binary.BigEndian, track.AudioType)
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint64(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入音频轨道 END =========
+
+	// ========= 写入视频关键帧 START=========
+	for _, keyFrame := range pMediaFileIndex.KeyFrames {
+
+		// 头信息 HEADER[0xf(4bit),type=15(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+		// 载荷 PAYLOAD[extType(8bit),time(40bit),startOffset(48bit),size(24bit),discontinuity(8bit)]
+		binary.Write(&binBuf, binary.BigEndian, extTypeKeyFrame)
+		binary.Write(&binBuf, binary.BigEndian, uint40Bytes(uint64(keyFrame.Time)))
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(keyFrame.StartOffset))
+		binary.Write(&binBuf, binary.BigEndian, uint24Bytes(keyFrame.Size))
+		if keyFrame.Discontinuity {
+			binary.Write(&binBuf, binary.BigEndian, uint8(1))
+		} else {
+			binary.Write(&binBuf, binary.BigEndian, uint8(0))
+		}
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入视频关键帧 END =========
+
+	// ========= 写入统计信息 START=========
+	// 头信息 HEADER[0xf(4bit),type=7(4bit)]
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xF7))
+
+	// 载荷 PAYLOAD[crcErrorCount(32bit),errorPacketCount(32bit),sectionErrorCount(32bit),reserve(32bit)]
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Statistics.CRCErrorCount)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Statistics.ErrorPacketCount)
+	binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Statistics.SectionErrorCount)
+
+	// 保留位
+	binary.Write(&binBuf, binary.BigEndian, uint32(0))
+
+	// ENDFLAG
+	binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+	// ========= 写入统计信息 END =========
+
+	// ========= 写入PID传输错误统计 START=========
+	for _, pidStatistics := range pMediaFileIndex.Statistics.PIDs {
+
+		// 头信息 HEADER[0xf(4bit),type=11(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFB))
+
+		// 载荷 PAYLOAD[PID(16bit),ccErrorCount(32bit),scrambledCount(32bit),duplicateCount(24bit),teiCount(24bit)]
+		binary.Write(&binBuf, binary.BigEndian, pidStatistics.PID)
+		binary.Write(&binBuf, binary.BigEndian, pidStatistics.CCErrorCount)
+		binary.Write(&binBuf, binary.BigEndian, pidStatistics.ScrambledCount)
+		binary.Write(&binBuf, binary.BigEndian, uint24Bytes(pidStatistics.DuplicateCount))
+		binary.Write(&binBuf, binary.BigEndian, uint24Bytes(pidStatistics.TEICount))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入PID传输错误统计 END =========
+
+	// ========= 写入业务描述信息 START=========
+	if pMediaFileIndex.Service.ServiceID != 0 {
+
+		// 头信息 HEADER[0xf(4bit),type=8(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xF8))
+
+		// 载荷 PAYLOAD[serviceID(16bit),serviceType(8bit),reserve(104bit)]
+		binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Service.ServiceID)
+		binary.Write(&binBuf, binary.BigEndian, pMediaFileIndex.Service.ServiceType)
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint64(0))
+		binary.Write(&binBuf, binary.BigEndian, uint32(0))
+		binary.Write(&binBuf, binary.BigEndian, uint8(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+		// 名称按文本块依次写入
+		writeTextRecords(&binBuf, textTypeProviderName, pMediaFileIndex.Service.ProviderName)
+		writeTextRecords(&binBuf, textTypeServiceName, pMediaFileIndex.Service.ServiceName)
+	}
+
+	// ========= 写入业务描述信息 END =========
+
+	// ========= 写入损坏数据区间 START=========
+	for _, corruptedRange := range pMediaFileIndex.CorruptedRanges {
+
+		// 头信息 HEADER[0xf(4bit),type=10(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFA))
+
+		// 载荷 PAYLOAD[startOffset(64bit),size(64bit)]
+		binary.Write(&binBuf, binary.BigEndian, corruptedRange.StartOffset)
+		binary.Write(&binBuf, binary.BigEndian, corruptedRange.Size)
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入损坏数据区间 END =========
+
+	// ========= 写入字幕轨道 START=========
+	for _, track := range pMediaFileIndex.SubtitleTracks {
+
+		// 头信息 HEADER[0xf(4bit),type=12(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFC))
+
+		// 载荷 PAYLOAD[PID(16bit),subtitleType(8bit),hearingImpaired(8bit),page(16bit),language(24bit),reserve(56bit)]
+		var language [3]byte
+		copy(language[:], track.Language)
+		var hearingImpaired uint8
+		if track.HearingImpaired {
+			hearingImpaired = 1
+		}
+		binary.Write(&binBuf, binary.BigEndian, track.PID)
+		binary.Write(&binBuf, binary.BigEndian, track.Type)
+		binary.Write(&binBuf, binary.BigEndian, hearingImpaired)
+		binary.Write(&binBuf, binary.BigEndian, track.Page)
+		binary.Write(&binBuf, binary.BigEndian, language)
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint32(0))
+		binary.Write(&binBuf, binary.BigEndian, uint16(0))
+		binary.Write(&binBuf, binary.BigEndian, uint8(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+	}
+
+	// ========= 写入字幕轨道 END =========
+
+	// ========= 写入字幕条目 START=========
+	for _, cue := range pMediaFileIndex.SubtitleCues {
+
+		// 头信息 HEADER[0xf(4bit),type=13(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFD))
+
+		// 载荷 PAYLOAD[startTime(48bit),endTime(48bit),track(8bit),reserve(24bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(uint64(cue.StartTime)))
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(uint64(cue.EndTime)))
+		binary.Write(&binBuf, binary.BigEndian, uint8(cue.Track))
+
+		// 保留位
+		binary.Write(&binBuf, binary.BigEndian, uint16(0))
+		binary.Write(&binBuf, binary.BigEndian, uint8(0))
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+		// 字幕文本按文本块依次写入
+		writeTextRecords(&binBuf, textTypeCue, cue.Text)
+	}
+
+	// ========= 写入字幕条目 END =========
+
+	// ========= 写入SCTE-35切点 START=========
+	for _, point := range pMediaFileIndex.SplicePoints {
+
+		// 头信息 HEADER[0xf(4bit),type=14(4bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFE))
+
+		// 载荷 PAYLOAD[time(48bit),duration(32bit),eventID(32bit),spliceType(8bit),commandType(8bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(uint64(point.Time)))
+		binary.Write(&binBuf, binary.BigEndian, uint32(min(point.Duration, 0xFFFFFFFF)))
+		binary.Write(&binBuf, binary.BigEndian, point.EventID)
+		binary.Write(&binBuf, binary.BigEndian, point.Type)
+		binary.Write(&binBuf, binary.BigEndian, point.CommandType)
+
+		// ENDFLAG
+		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+
+		// 原始数据按文本块依次写入
+		writeTextRecords(&binBuf, textTypeSpliceData, string(point.Data))
+	}
+
+	// ========= 写入SCTE-35切点 END =========
+
 	// ========= 写入帧数据信息 START=========
 	var i int
 	for i = 0; i < len(pMediaFileIndex.TimesArray); i++ {
@@ -254,15 +830,29 @@ func writeFile(pMediaFileIndex *MediaFileIndex, indexFileLocalPath string) error
 		// 获得时间片
 		slice := pMediaFileIndex.TimesArray[i]
 
-		// 头信息 HEADER[0xf(4bit),type=1(4bit)]
-		binary.Write(&binBuf, binary.BigEndian, uint8(0xF2))
+		// 不连续点 HEADER[0xf(4bit),type=4(4bit)]
+		if slice.Discontinuity {
+			binary.Write(&binBuf, binary.BigEndian, uint8(0xF4))
+
+			// 载荷 PAYLOAD[startOffset(64bit), reserve(64bit)]
+			binary.Write(&binBuf, binary.BigEndian, slice.StartOffset)
+			binary.Write(&binBuf, binary.BigEndian, uint64(0))
+
+			// ENDFLAG
+			binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
+		}
+
+		// 头信息 HEADER[0xf(4bit),type=2|3(4bit)]
+		if slice.IsKeyFrame {
+			binary.Write(&binBuf, binary.BigEndian, uint8(0xF3))
+		} else {
+			binary.Write(&binBuf, binary.BigEndian, uint8(0xF2))
+		}
 
-		// 载荷 PAYLOAD[mintime(32bit),maxtime(32bit),startOffset(64bit)]]
-		minTimeBits := math.Float32bits(slice.MinTime)
-		binary.Write(&binBuf, binary.BigEndian, minTimeBits)
-		maxTimeBits := math.Float32bits(slice.MaxTime)
-		binary.Write(&binBuf, binary.BigEndian, maxTimeBits)
-		binary.Write(&binBuf, binary.BigEndian, slice.StartOffset)
+		// 载荷 PAYLOAD[mintime(48bit),duration(32bit),startOffset(48bit)]
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(uint64(slice.MinTime)))
+		binary.Write(&binBuf, binary.BigEndian, uint32(slice.MaxTime-slice.MinTime))
+		binary.Write(&binBuf, binary.BigEndian, uint48Bytes(slice.StartOffset))
 
 		// ENDFLAG
 		binary.Write(&binBuf, binary.BigEndian, uint8(0xFF))
@@ -278,9 +868,51 @@ func writeFile(pMediaFileIndex *MediaFileIndex, indexFileLocalPath string) error
 	return nil
 }
 
+// uint48Bytes 转换为48bit大端字节
+func uint48Bytes(value uint64) [6]byte {
+	return [6]byte{byte(value >> 40), byte(value >> 32), byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
+
+// uint40Bytes 转换为40bit大端字节
+func uint40Bytes(value uint64) [5]byte {
+	return [5]byte{byte(value >> 32), byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
+
+// uint24Bytes 转换为24bit大端字节，超出时记为最大值
+func uint24Bytes(value uint32) [3]byte {
+	if value > 0xFFFFFF {
+		value = 0xFFFFFF
+	}
+	return [3]byte{byte(value >> 16), byte(value >> 8), byte(value)}
+}
+
+// writeTextRecords 将文本按块写入索引，每块最多 textChunkSize 字节
+func writeTextRecords(binBuf *bytes.Buffer, textType uint8, text string) {
+
+	var data []byte = []byte(text)
+	var i int
+	for i = 0; i < len(data); i += textChunkSize {
+
+		var chunk [textChunkSize]byte
+		length := copy(chunk[:], data[i:])
+
+		// 头信息 HEADER[0xf(4bit),type=9(4bit)]
+		binary.Write(binBuf, binary.BigEndian, uint8(0xF9))
+
+		// 载荷 PAYLOAD[textType(8bit),length(8bit),text(104bit)]
+		binary.Write(binBuf, binary.BigEndian, textType)
+		binary.Write(binBuf, binary.BigEndian, uint8(length))
+		binary.Write(binBuf, binary.BigEndian, chunk)
+
+		// ENDFLAG
+		binary.Write(binBuf, binary.BigEndian, uint8(0xFF))
+	}
+}
+
 // readIndexFile 从磁盘读取索引文件
 // 	indexFileLocalPath 索引文件本地路径
-func readIndexFile(indexFileLocalPath string) (*MediaFileIndex, error) {
+//	tsFilePath 媒体文件本地路径
+func readIndexFile(indexFileLocalPath string, tsFilePath string) (*MediaFileIndex, error) {
 
 	var file *os.File
 	var err error
@@ -308,13 +940,6 @@ func readIndexFile(indexFileLocalPath string) (*MediaFileIndex, error) {
 		return nil, err
 	}
 
-	// 获取ts文件修改时间
-	tsFilePath, err := getMediaFilePathFromIndexFilePath(indexFileLocalPath)
-	if err != nil {
-		Log.Error("Ts file read failed: " + err.Error())
-		return nil, err
-	}
-
 	// 打开ts文件
 	tsFile, err := os.Open(tsFilePath)
 	if err != nil {
@@ -341,6 +966,13 @@ func readIndexFile(indexFileLocalPath string) (*MediaFileIndex, error) {
 	// 预加载包字节
 	data := make([]byte, 18)
 
+	// 下一个时间片是否从不连续点开始
+	var discontinuity bool = false
+
+	// 分块存储的业务名称
+	var providerName []byte
+	var serviceName []byte
+
 	// 取文件
 	for {
 		_, err := file.Read(data)
@@ -383,36 +1015,181 @@ func readIndexFile(indexFileLocalPath string) (*MediaFileIndex, error) {
 
 			MediaFileIndex.BindWidth = uint32(data[2])<<24 | uint32(data[3])<<16 | uint32(data[4])<<8 | uint32(data[5])
 			MediaFileIndex.Duration = uint32(data[6])<<24 | uint32(data[7])<<16 | uint32(data[8])<<8 | uint32(data[9])
+			MediaFileIndex.ProgramNumber = uint16(data[10])<<8 | uint16(data[11])
+			MediaFileIndex.PkgSize = int(data[12])
 		case 1:
 
 			MediaFileIndex.VideoSize = uint64(data[1])<<56 | uint64(data[2])<<48 | uint64(data[3])<<40 | uint64(data[4])<<32 |
 				uint64(data[5])<<24 | uint64(data[6])<<16 | uint64(data[7])<<8 | uint64(data[8])
+			MediaFileIndex.StartPts = int64(data[9])<<40 | int64(data[10])<<32 | int64(data[11])<<24 | int64(data[12])<<16 |
+				int64(data[13])<<8 | int64(data[14])
 
-		case 2:
+		case 2, 3:
 
 			var slice TimeSlice
-			slice.MinTime = math.Float32frombits(uint32(data[1])<<24 | uint32(data[2])<<16 | uint32(data[3])<<8 | uint32(data[4]))
-			slice.MaxTime = math.Float32frombits(uint32(data[5])<<24 | uint32(data[6])<<16 | uint32(data[7])<<8 | uint32(data[8]))
-			slice.StartOffset = uint64(data[9])<<56 | uint64(data[10])<<48 | uint64(data[11])<<40 | uint64(data[12])<<32 |
-				uint64(data[13])<<24 | uint64(data[14])<<16 | uint64(data[15])<<8 | uint64(data[16])
+			slice.IsKeyFrame = dataType == 3
+			slice.Discontinuity = discontinuity
+			discontinuity = false
+			slice.MinTime = int64(data[1])<<40 | int64(data[2])<<32 | int64(data[3])<<24 | int64(data[4])<<16 | int64(data[5])<<8 | int64(data[6])
+			slice.MaxTime = slice.MinTime + int64(uint32(data[7])<<24|uint32(data[8])<<16|uint32(data[9])<<8|uint32(data[10]))
+			slice.StartOffset = uint64(data[11])<<40 | uint64(data[12])<<32 | uint64(data[13])<<24 | uint64(data[14])<<16 |
+				uint64(data[15])<<8 | uint64(data[16])
 
 			MediaFileIndex.TimesArray = append(MediaFileIndex.TimesArray, slice)
+
+		case 4:
+
+			discontinuity = true
+
+		case 5:
+
+			MediaFileIndex.VideoCodec.StreamType = data[1]
+			if data[1] == StreamTypeH264 {
+				MediaFileIndex.VideoCodec.ProfileIDC = data[2]
+			} else {
+				MediaFileIndex.VideoCodec.ProfileSpace = data[2] >> 6 & 0x3
+				MediaFileIndex.VideoCodec.TierFlag = data[2] >> 5 & 0x1
+				MediaFileIndex.VideoCodec.ProfileIDC = data[2] & 0x1f
+			}
+			MediaFileIndex.VideoCodec.CompatibilityFlags = uint32(data[3])<<24 | uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+			MediaFileIndex.VideoCodec.ConstraintFlags = uint64(data[7])<<40 | uint64(data[8])<<32 | uint64(data[9])<<24 |
+				uint64(data[10])<<16 | uint64(data[11])<<8 | uint64(data[12])
+			MediaFileIndex.VideoCodec.LevelIDC = data[13]
+
+		case 6:
+
+			MediaFileIndex.AudioCodec.StreamType = data[1]
+			MediaFileIndex.AudioCodec.Layer = data[2]
+
+		case 7:
+
+			MediaFileIndex.Statistics.CRCErrorCount = uint32(data[1])<<24 | uint32(data[2])<<16 | uint32(data[3])<<8 | uint32(data[4])
+			MediaFileIndex.Statistics.ErrorPacketCount = uint32(data[5])<<24 | uint32(data[6])<<16 | uint32(data[7])<<8 | uint32(data[8])
+			MediaFileIndex.Statistics.SectionErrorCount = uint32(data[9])<<24 | uint32(data[10])<<16 | uint32(data[11])<<8 | uint32(data[12])
+
+		case 8:
+
+			MediaFileIndex.Service.ServiceID = uint16(data[1])<<8 | uint16(data[2])
+			MediaFileIndex.Service.ServiceType = data[3]
+
+		case 9:
+
+			textLength := int(min(int64(data[2]), textChunkSize))
+			switch data[1] {
+			case textTypeProviderName:
+				providerName = append(providerName, data[3:3+textLength]...)
+			case textTypeServiceName:
+				serviceName = append(serviceName, data[3:3+textLength]...)
+			case textTypeCue:
+				if len(MediaFileIndex.SubtitleCues) > 0 {
+					MediaFileIndex.SubtitleCues[len(MediaFileIndex.SubtitleCues)-1].Text += string(data[3 : 3+textLength])
+				}
+			case textTypeSpliceData:
+				if len(MediaFileIndex.SplicePoints) > 0 {
+					point := &MediaFileIndex.SplicePoints[len(MediaFileIndex.SplicePoints)-1]
+					point.Data = append(point.Data, data[3:3+textLength]...)
+				}
+			case textTypePatSection:
+				MediaFileIndex.PatSection = append(MediaFileIndex.PatSection, data[3:3+textLength]...)
+			case textTypePmtSection:
+				MediaFileIndex.PmtSection = append(MediaFileIndex.PmtSection, data[3:3+textLength]...)
+			}
+
+		case 10:
+
+			var corruptedRange ByteRange
+			corruptedRange.StartOffset = uint64(data[1])<<56 | uint64(data[2])<<48 | uint64(data[3])<<40 | uint64(data[4])<<32 |
+				uint64(data[5])<<24 | uint64(data[6])<<16 | uint64(data[7])<<8 | uint64(data[8])
+			corruptedRange.Size = uint64(data[9])<<56 | uint64(data[10])<<48 | uint64(data[11])<<40 | uint64(data[12])<<32 |
+				uint64(data[13])<<24 | uint64(data[14])<<16 | uint64(data[15])<<8 | uint64(data[16])
+			MediaFileIndex.CorruptedRanges = append(MediaFileIndex.CorruptedRanges, corruptedRange)
+
+		case 11:
+
+			var pidStatistics PIDStatistics
+			pidStatistics.PID = uint16(data[1])<<8 | uint16(data[2])
+			pidStatistics.CCErrorCount = uint32(data[3])<<24 | uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+			pidStatistics.ScrambledCount = uint32(data[7])<<24 | uint32(data[8])<<16 | uint32(data[9])<<8 | uint32(data[10])
+			pidStatistics.DuplicateCount = uint32(data[11])<<16 | uint32(data[12])<<8 | uint32(data[13])
+			pidStatistics.TEICount = uint32(data[14])<<16 | uint32(data[15])<<8 | uint32(data[16])
+			MediaFileIndex.Statistics.PIDs = append(MediaFileIndex.Statistics.PIDs, pidStatistics)
+
+		case 12:
+
+			var track SubtitleTrack
+			track.PID = uint16(data[1])<<8 | uint16(data[2])
+			track.Type = data[3]
+			track.HearingImpaired = data[4] == 1
+			track.Page = uint16(data[5])<<8 | uint16(data[6])
+			track.Language = strings.TrimRight(string(data[7:10]), "\x00")
+			MediaFileIndex.SubtitleTracks = append(MediaFileIndex.SubtitleTracks, track)
+
+		case 13:
+
+			var cue SubtitleCue
+			cue.StartTime = int64(data[1])<<40 | int64(data[2])<<32 | int64(data[3])<<24 | int64(data[4])<<16 | int64(data[5])<<8 | int64(data[6])
+			cue.EndTime = int64(data[7])<<40 | int64(data[8])<<32 | int64(data[9])<<24 | int64(data[10])<<16 | int64(data[11])<<8 | int64(data[12])
+			cue.Track = int(data[13])
+			MediaFileIndex.SubtitleCues = append(MediaFileIndex.SubtitleCues, cue)
+
+		case 14:
+
+			var point SplicePoint
+			point.Time = int64(data[1])<<40 | int64(data[2])<<32 | int64(data[3])<<24 | int64(data[4])<<16 | int64(data[5])<<8 | int64(data[6])
+			point.Duration = int64(uint32(data[7])<<24 | uint32(data[8])<<16 | uint32(data[9])<<8 | uint32(data[10]))
+			point.EventID = uint32(data[11])<<24 | uint32(data[12])<<16 | uint32(data[13])<<8 | uint32(data[14])
+			point.Type = data[15]
+			point.CommandType = data[16]
+			MediaFileIndex.SplicePoints = append(MediaFileIndex.SplicePoints, point)
+
+		case 15:
+
+			// 未知的扩展记录类型忽略
+			switch data[1] {
+			case extTypeMediaFormat:
+				MediaFileIndex.VideoCodec.Width = uint16(data[2])<<8 | uint16(data[3])
+				MediaFileIndex.VideoCodec.Height = uint16(data[4])<<8 | uint16(data[5])
+				MediaFileIndex.VideoCodec.FrameRate = float64(uint32(data[6])<<24|uint32(data[7])<<16|uint32(data[8])<<8|uint32(data[9])) / 1000
+				MediaFileIndex.AudioCodec.ObjectType = data[10]
+				MediaFileIndex.AudioCodec.SampleRate = uint32(data[11])<<16 | uint32(data[12])<<8 | uint32(data[13])
+				MediaFileIndex.AudioCodec.Channels = data[14]
+
+			case extTypeProgramPIDs:
+				MediaFileIndex.PmtPID = uint16(data[2])<<8 | uint16(data[3])
+				MediaFileIndex.PcrPID = uint16(data[4])<<8 | uint16(data[5])
+				MediaFileIndex.VideoPID = uint16(data[6])<<8 | uint16(data[7])
+
+			case extTypeAudioTrack:
+				var track AudioTrack
+				track.PID = uint16(data[2])<<8 | uint16(data[3])
+				track.StreamType = data[4]
+				track.Language = strings.TrimRight(string(data[5:8]), "\x00")
+				track.AudioType = data[8]
+				MediaFileIndex.AudioTracks = append(MediaFileIndex.AudioTracks, track)
+
+			case extTypeKeyFrame:
+				var keyFrame KeyFrame
+				keyFrame.Time = int64(data[2])<<32 | int64(data[3])<<24 | int64(data[4])<<16 | int64(data[5])<<8 | int64(data[6])
+				keyFrame.StartOffset = uint64(data[7])<<40 | uint64(data[8])<<32 | uint64(data[9])<<24 | uint64(data[10])<<16 | uint64(data[11])<<8 | uint64(data[12])
+				keyFrame.Size = uint32(data[13])<<16 | uint32(data[14])<<8 | uint32(data[15])
+				keyFrame.Discontinuity = data[16] == 1
+				MediaFileIndex.KeyFrames = append(MediaFileIndex.KeyFrames, keyFrame)
+			}
 		}
 	}
 
+	MediaFileIndex.Service.ProviderName = string(providerName)
+	MediaFileIndex.Service.ServiceName = string(serviceName)
+	MediaFileIndex.Statistics.sumPIDStatistics()
+
 	return &MediaFileIndex, nil
 }
 
 // createIndexFile 创建索引文件
 //	indexFileLocalPath 索引文件本地路径
-func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIndex, error) {
-
-	// 获取索引对应媒体文件路径
-	tsFilePath, err := getMediaFilePathFromIndexFilePath(indexFileLocalPath)
-	if err != nil {
-		Log.Error("Open ts file failed: " + err.Error())
-		return nil, err
-	}
+//	tsFilePath 媒体文件本地路径
+//	programNumber 节目号，0 表示第一个节目
+func (indexer *Indexer) createIndexFile(indexFileLocalPath string, tsFilePath string, programNumber uint16) (*MediaFileIndex, error) {
 
 	// 标记当前处理正在被别人抢占
 	var waitProcess bool = false
@@ -443,7 +1220,7 @@ func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIn
 		Log.Debug("Wait stop! Retry to read index, indexFileLocalPath:" + indexFileLocalPath)
 
 		// 再次尝试读取索引文件
-		pMediaFileIndex, err := readIndexFile(indexFileLocalPath)
+		pMediaFileIndex, err := readIndexFile(indexFileLocalPath, tsFilePath)
 
 		// 获取成功
 		if err == nil {
@@ -459,6 +1236,8 @@ func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIn
 	// 初始化成员变量
 	indexer.minTime = -1
 	indexer.maxTime = -1
+	indexer.lastPts = -1
+	indexer.wrapOffset = 0
 	indexer.frameArray = make([]Frame, 0)
 
 	Log.Debug("Open ts file: " + tsFilePath)
@@ -478,59 +1257,92 @@ func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIn
 		return nil, err
 	}
 
-	// 预加载ts包字节 切片
-	preLoadData := make([]byte, min(int64(TsPkgSize*TsReloadNum), fileStat.Size()))
-	var curOffset int64 = 0
-
-	// 创建解封装器
-	var d Demuxer
-
-	// 初始化解封装器
-	d.Init()
+	// 创建解封装器，损坏的数据将被跳过
+	d := NewDemuxer(file)
+	d.SelectProgram(programNumber)
 
-	// 取ts文件
-	for {
-		n, err := file.Read(preLoadData)
+	// 更新处理进度
+	var pkgCount int = 0
+	d.OnPacket = func(pkt *Packet) {
+		pkgCount++
+		if pkgCount%TsReloadNum == 0 {
+			updateProcess(tsFilePath, int64(pkt.Offset), fileStat.Size())
+		}
+	}
 
-		// 读取文件失败
-		if err != nil {
-			if err != io.EOF {
-				Log.Error("Open ts file failed: " + err.Error())
-				return nil, err
+	// 字幕轨道，每个字幕流一个解析器
+	var subtitleTracks []SubtitleTrack
+	var teletextDecoders map[uint16]*teletextDecoder = make(map[uint16]*teletextDecoder)
+	d.OnPmt = func(pmt *Pmt) {
+		subtitleTracks = getSubtitleTracks(pmt)
+		for i, track := range subtitleTracks {
+			if teletextDecoders[track.PID] == nil {
+				teletextDecoders[track.PID] = &teletextDecoder{}
 			}
-			break
+			teletextDecoders[track.PID].addPage(i, track.Page)
 		}
+	}
 
-		curOffset += int64(n)
-		updateProcess(tsFilePath, curOffset, fileStat.Size())
+	// SCTE-35 切点，按索引时间记录
+	var splicePoints []SplicePoint
+	d.OnSplice = func(event *SpliceEvent) {
+		indexer.addSplicePoint(&splicePoints, event)
+	}
 
-		// 解封装
-		var i int
-		for i = 0; i < TsReloadNum; i++ {
+	// 索引流的pes作为帧加入索引，字幕流按索引时间解析字幕
+	d.OnPes = func(pes *Pes) {
+		if int(pes.PID) == d.IndexPID() {
+			indexer.feedPes(pes)
+			return
+		}
 
-			if (len(preLoadData) - i*188) < 188 {
-				Log.Debug("Wrong ts file length!")
-				break
+		if decoder, ok := teletextDecoders[pes.PID]; ok && pes.PtsDtsFlags&0x2 != 0 {
+			if time, ok := indexer.mapPts(pes.PTS); ok {
+				decoder.feed(pes.Payload, time)
 			}
+		}
+	}
 
-			var pKgBuf []byte = preLoadData[i*188 : (i*188 + 188)]
-			pes, err := d.DemuxPkg(pKgBuf)
+	err = d.Run()
+	if err != nil {
+		Log.Error("Open ts file failed: " + err.Error())
+		return nil, err
+	}
+	updateProcess(tsFilePath, fileStat.Size(), fileStat.Size())
 
-			// 解封装失败 TODO
-			if err != nil {
-				Log.Error("Demux ts file failed: " + err.Error())
-				return nil, err
-			}
-			if pes != nil {
-				indexer.feedFrame(pes.PTS, pes.PkgOffset)
-			}
-		}
+	// 损坏的数据超过一半时，认为文件不可读
+	corruptedSize := d.SkippedSize() + uint64(d.GetStatistics().ErrorPacketCount)*uint64(d.PkgSize())
+	if float64(corruptedSize) > float64(fileStat.Size())*maxCorruptedRatio {
+		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "Media file is mostly unreadable, corrupted size: "+fmt.Sprint(corruptedSize))
+		return nil, err
+	}
+
+	// 未找到可建立索引的流
+	pmt, _ := d.GetPmt()
+	if d.IndexPID() == -1 {
+		statistics := d.GetStatistics()
+		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "No stream can be indexed, program: "+fmt.Sprint(programNumber)+
+			", crc error count: "+fmt.Sprint(statistics.CRCErrorCount)+", section error count: "+fmt.Sprint(statistics.SectionErrorCount))
+		return nil, err
 	}
 
 	// 索引对象
 	var mediaFileIndex MediaFileIndex
 	mediaFileIndex.VideoSize = common.GetFileSize(tsFilePath)
-	mediaFileIndex.Duration = uint32(indexer.maxTime-indexer.minTime) / 1000
+	mediaFileIndex.ProgramNumber = pmt.ProgramNumber
+	pat, _ := d.GetPat()
+	mediaFileIndex.PmtPID = getPmtPID(&pat, pmt.ProgramNumber)
+	mediaFileIndex.PcrPID = pmt.PcrPID
+	if d.curVideoPID != -1 {
+		mediaFileIndex.VideoPID = uint16(d.curVideoPID)
+	}
+
+	// 根据pat、pmt表重新生成段，分片开始处插入
+	mediaFileIndex.PatSection = muxPatSection(&pat)
+	mediaFileIndex.PmtSection = muxPmtSection(&pmt)
+	mediaFileIndex.PkgSize = d.PkgSize()
+	// 总时长包含最后一帧的显示时长
+	mediaFileIndex.Duration = uint32((indexer.maxTime + indexer.frameInterval - indexer.minTime) / TimeScale)
 
 	// 预防时长为 0 
 	if mediaFileIndex.Duration == 0 {
@@ -539,54 +1351,129 @@ func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIn
 	}
 
 	mediaFileIndex.BindWidth = uint32(mediaFileIndex.VideoSize / uint64(mediaFileIndex.Duration))
+
+	// 视频编码信息，未解析到参数集时仅记录流类型
+	mediaFileIndex.VideoCodec = d.videoCodec
+	if mediaFileIndex.VideoCodec.StreamType == 0 {
+		mediaFileIndex.VideoCodec.StreamType = d.curVideoType
+	}
+
+	// 参数集中不包含帧率时，按视频帧间隔计算
+	if mediaFileIndex.VideoCodec.StreamType != 0 && mediaFileIndex.VideoCodec.FrameRate == 0 && indexer.frameInterval > 0 {
+		mediaFileIndex.VideoCodec.FrameRate = float64(TimeScale) / float64(indexer.frameInterval)
+	}
+
+	// 音频编码信息，未解析到帧头时仅记录流类型
+	mediaFileIndex.AudioCodec = d.audioCodec
+	mediaFileIndex.AudioCodec.StreamType = d.curAudioType
+	mediaFileIndex.AudioTracks = getAudioTracks(&pmt)
+
+	// 统计信息，存在损坏的数据时标记
+	mediaFileIndex.Statistics = d.GetStatistics()
+	mediaFileIndex.CorruptedRanges = d.SkippedRanges()
+	if mediaFileIndex.IsDamaged() {
+		Log.Warn("Media file is damaged, crc error count: " + fmt.Sprint(mediaFileIndex.Statistics.CRCErrorCount) +
+			", section error count: " + fmt.Sprint(mediaFileIndex.Statistics.SectionErrorCount) +
+			", error packet count: " + fmt.Sprint(mediaFileIndex.Statistics.ErrorPacketCount) +
+			", cc error count: " + fmt.Sprint(mediaFileIndex.Statistics.CCErrorCount) +
+			", tei count: " + fmt.Sprint(mediaFileIndex.Statistics.TEICount) +
+			", skipped size: " + fmt.Sprint(d.SkippedSize()) + ", file: " + tsFilePath)
+	}
+
+	// 业务描述信息
+	mediaFileIndex.Service, _ = d.GetServiceInfo(mediaFileIndex.ProgramNumber)
+
+	// 媒体开始时间对应的时间戳
+	mediaFileIndex.StartPts = (indexer.minTime%ptsWrap + ptsWrap) % ptsWrap
+
+	// 字幕条目，时间从媒体开始时计时，正在显示的字幕在媒体结束时结束
+	mediaFileIndex.SubtitleTracks = subtitleTracks
+	mediaFileIndex.SubtitleCues = make([]SubtitleCue, 0)
+	for _, decoder := range teletextDecoders {
+		decoder.flush(indexer.maxTime + indexer.frameInterval)
+		for _, cue := range decoder.cues {
+			cue.StartTime = max(cue.StartTime-indexer.minTime, 0)
+			cue.EndTime = cue.EndTime - indexer.minTime
+			if cue.EndTime > cue.StartTime {
+				mediaFileIndex.SubtitleCues = append(mediaFileIndex.SubtitleCues, cue)
+			}
+		}
+	}
+	sort.SliceStable(mediaFileIndex.SubtitleCues, func(i, j int) bool {
+		if mediaFileIndex.SubtitleCues[i].StartTime != mediaFileIndex.SubtitleCues[j].StartTime {
+			return mediaFileIndex.SubtitleCues[i].StartTime < mediaFileIndex.SubtitleCues[j].StartTime
+		}
+		return mediaFileIndex.SubtitleCues[i].Track < mediaFileIndex.SubtitleCues[j].Track
+	})
+
+	// 切点时间从媒体开始时计时，媒体结束之后的切点被忽略
+	mediaFileIndex.SplicePoints = make([]SplicePoint, 0)
+	for _, point := range splicePoints {
+		point.Time = max(point.Time-indexer.minTime, 0)
+		if point.Time < indexer.maxTime+indexer.frameInterval-indexer.minTime {
+			mediaFileIndex.SplicePoints = append(mediaFileIndex.SplicePoints, point)
+		}
+	}
+	sort.SliceStable(mediaFileIndex.SplicePoints, func(i, j int) bool {
+		return mediaFileIndex.SplicePoints[i].Time < mediaFileIndex.SplicePoints[j].Time
+	})
 	mediaFileIndex.TimesArray = make([]TimeSlice, 0)
 
-	// 整理切片时间,time单位为秒，改为每秒一个切片
+	// 整理切片时间,time单位为 TimeScale，按解码时间每秒一个切片
+	// 有视频时遇到关键帧即开始新切片，保证关键帧可作为分片起点
+	// 纯音频时每帧都是关键帧，只在当前切片不以关键帧开始时才按关键帧切分
 	var i int
 
-	// 帧真实时长
+	// 分片开始时间取该帧及之后所有帧的最小显示时间，B帧乱序时分片时间仍连续且单调
+	// 最后一帧之后为媒体结束时间
+	var startTimes []int64 = make([]int64, len(indexer.frameArray)+1)
+	startTimes[len(indexer.frameArray)] = indexer.maxTime + indexer.frameInterval - indexer.minTime
+	for i = len(indexer.frameArray) - 1; i >= 0; i-- {
+		startTimes[i] = min(indexer.frameArray[i].Time-indexer.minTime, startTimes[i+1])
+	}
+
 	var newSlice bool = true
-	var lastSliceMaxTime float32 = 0
-	var sliceOffset uint64
-	var sliceMaxTime float32 = 0
 	var slice TimeSlice
+	var sliceDecodeTime int64
 	for i = 0; i < len(indexer.frameArray); i++ {
 
-		// offset
-		if newSlice {
-			sliceOffset = indexer.frameArray[i].StartOffset
-			slice.MinTime = lastSliceMaxTime
-			slice.StartOffset = sliceOffset
-			newSlice = false
-		}
-
-		slice.MaxTime = sliceMaxTime
+		frame := indexer.frameArray[i]
 
-		// 当前帧的真实时间
-		curFrameTime := (indexer.frameArray[i].Time - float32(indexer.minTime)) / 1000
-		if sliceMaxTime < curFrameTime {
-			sliceMaxTime = curFrameTime
-		}
+		// 当前帧的解码时间
+		curDecodeTime := frame.DecodeTime - indexer.minTime
 
-		// 计算预计时长
-		nextSliceDuration := sliceMaxTime - lastSliceMaxTime
+		// 遇到关键帧、不连续点或分片时长超过一秒，结束当前分片
+		keyFrameCut := frame.IsKeyFrame && (mediaFileIndex.VideoPID != 0 || !slice.IsKeyFrame)
+		if !newSlice && (keyFrameCut || frame.Discontinuity || curDecodeTime-sliceDecodeTime > TimeScale) {
 
-		// 分片时长超过一秒了
-		if nextSliceDuration > 1 {
+			// 分片结束时间即下一分片开始时间
+			slice.MaxTime = startTimes[i]
 
 			// 插入分片
 			mediaFileIndex.TimesArray = append(mediaFileIndex.TimesArray, slice)
-
-			// 重置分片信息
-			lastSliceMaxTime = slice.MaxTime
-			sliceMaxTime = -1
 			newSlice = true
 		}
+
+		// 以当前帧开始新分片
+		if newSlice {
+			slice.MinTime = startTimes[i]
+			slice.StartOffset = frame.StartOffset
+			slice.IsKeyFrame = frame.IsKeyFrame
+			slice.Discontinuity = frame.Discontinuity
+			sliceDecodeTime = curDecodeTime
+			newSlice = false
+		}
 	}
 
 	// 最后一个分片
+	slice.MaxTime = startTimes[len(indexer.frameArray)]
 	mediaFileIndex.TimesArray = append(mediaFileIndex.TimesArray, slice)
 
+	// 视频关键帧，用于生成I帧播放列表
+	if mediaFileIndex.VideoPID != 0 {
+		mediaFileIndex.KeyFrames = getKeyFrames(indexer.frameArray, indexer.minTime)
+	}
+
 	// 写索引文件
 	fileWriteErr := writeFile(&mediaFileIndex, indexFileLocalPath)
 	if fileWriteErr != nil {
@@ -596,37 +1483,107 @@ func (indexer *Indexer) createIndexFile(indexFileLocalPath string) (*MediaFileIn
 	return &mediaFileIndex, nil
 }
 
+// getKeyFrames 从帧列表中提取关键帧，关键帧之前出现的不连续点记录在关键帧上
+func getKeyFrames(frameArray []Frame, minTime int64) []KeyFrame {
+
+	var keyFrames []KeyFrame
+	var discontinuity bool = false
+	for _, frame := range frameArray {
+
+		discontinuity = discontinuity || frame.Discontinuity
+		if !frame.IsKeyFrame || frame.Size == 0 {
+			continue
+		}
+
+		var size uint32 = 0xFFFFFF
+		if frame.Size < uint64(size) {
+			size = uint32(frame.Size)
+		}
+		keyFrames = append(keyFrames, KeyFrame{
+			Time:          frame.Time - minTime,
+			StartOffset:   frame.StartOffset,
+			Size:          size,
+			Discontinuity: discontinuity && len(keyFrames) > 0,
+		})
+		discontinuity = false
+	}
+
+	return keyFrames
+}
+
+// getPmtPID 获取节目的pmt所在的PID，未找到时返回0
+func getPmtPID(pat *Pat, programNumber uint16) uint16 {
+
+	for _, program := range pat.Programs {
+		if program.ProgramNumber == programNumber {
+			return program.PID
+		}
+	}
+
+	return 0
+}
+
 // getIndexFilePath 根据索引文件url计算真正的索引路径
-func getIndexFilePath(baseFileURINoSuffix string) string {
+// 非默认节目的索引文件名追加 @节目号
+func getIndexFilePath(baseFileURINoSuffix string, programNumber uint16) string {
 	Log.Debug("getIndexFilePath start, baseFileURINoSuffix:" + baseFileURINoSuffix)
 	indexFileLocalPath := path.IndexFileFolder + baseFileURINoSuffix + ".tsidx"
+	if programNumber != 0 {
+		indexFileLocalPath = path.IndexFileFolder + baseFileURINoSuffix + "@" + strconv.Itoa(int(programNumber)) + ".tsidx"
+	}
 	Log.Debug("getIndexFilePath finish, indexFileLocalPath:" + indexFileLocalPath)
 	return indexFileLocalPath
 }
 
-// getMediaFilePathFromIndexFilePath 根据索引文件计算真正的媒体路径
-func getMediaFilePathFromIndexFilePath(indexFileLocalPath string) (string, error) {
-	Log.Debug("getMediaFilePathFromIndexFilePath start, indexFileLocalPath:" + indexFileLocalPath)
+// GetMediaFilePath 根据请求路径计算真正的媒体路径
+// 依次查找 mediaFileSuffixes 中的后缀，均不存在时返回 .ts 文件路径
+func GetMediaFilePath(baseFileURINoSuffix string) (string, error) {
+	Log.Debug("GetMediaFilePath start, baseFileURINoSuffix:" + baseFileURINoSuffix)
 
-	mediaFileURI := strings.TrimSuffix(strings.Replace(indexFileLocalPath, path.IndexFileFolder, "", 1), ".tsidx") + ".ts"
+	if strings.Index(baseFileURINoSuffix, "/") < 0 {
+		Log.Error("Can't get group_name from url!")
+		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "GetMediaFilePath failed, can't get group_name from url.")
+		return "", err
+	}
 
-	groupName := mediaFileURI[0:strings.Index(mediaFileURI, "/")]
+	groupName := baseFileURINoSuffix[0:strings.Index(baseFileURINoSuffix, "/")]
 
-	fileURI := mediaFileURI[strings.Index(mediaFileURI, "/")+1 : len(mediaFileURI)]
+	fileURINoSuffix := baseFileURINoSuffix[strings.Index(baseFileURINoSuffix, "/")+1 : len(baseFileURINoSuffix)]
 
-	Log.Debug("groupName:" + groupName + ", fileURI:" + fileURI)
+	Log.Debug("groupName:" + groupName + ", fileURINoSuffix:" + fileURINoSuffix)
 
 	if _, ok := path.MediaFileFolders[groupName]; !ok {
 		Log.Error("Can't get group_info from config!")
-		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "getMediaFilePathFromIndexFilePath failed, can't get group_info from config.")
+		err := errors.NewError(errors.ErrorCodeGetIndexFailed, "GetMediaFilePath failed, can't get group_info from config.")
 		return "", err
 	}
 
-	mediaFileLocalPath := path.MediaFileFolders[groupName].LocalPath + fileURI
-	Log.Debug("getMediaFilePathFromIndexFilePath finish, mediaFileLocalPath:" + mediaFileLocalPath)
+	localPathNoSuffix := path.MediaFileFolders[groupName].LocalPath + fileURINoSuffix
+	mediaFileLocalPath := localPathNoSuffix + mediaFileSuffixes[0]
+
+	// 按后缀查找存在的媒体文件
+	for _, suffix := range mediaFileSuffixes {
+		if _, err := os.Stat(localPathNoSuffix + suffix); err == nil {
+			mediaFileLocalPath = localPathNoSuffix + suffix
+			break
+		}
+	}
+
+	Log.Debug("GetMediaFilePath finish, mediaFileLocalPath:" + mediaFileLocalPath)
 	return mediaFileLocalPath, nil
 }
 
+// TrimMediaFileSuffix 去除文件名中支持的媒体文件后缀，不是支持的媒体文件时返回 false
+func TrimMediaFileSuffix(fileName string) (string, bool) {
+
+	for _, suffix := range mediaFileSuffixes {
+		if strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix), true
+		}
+	}
+	return fileName, false
+}
+
 // min
 func min(x int64, y int64) int64 {
 	if x < y {
@@ -634,3 +1591,11 @@ func min(x int64, y int64) int64 {
 	}
 	return y
 }
+
+// max
+func max(x int64, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
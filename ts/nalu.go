@@ -0,0 +1,456 @@
+package ts
+
+// H.264 NAL单元类型
+const (
+	h264NalTypeSlice uint8 = 1 // 非IDR图像片
+	h264NalTypeIDR   uint8 = 5 // IDR图像片
+	h264NalTypeSPS   uint8 = 7 // 序列参数集
+	h264NalTypePPS   uint8 = 8 // 图像参数集
+	h264NalTypeAUD   uint8 = 9 // 访问单元分隔符
+)
+
+// HEVC NAL单元类型
+const (
+	hevcNalTypeIRAPMin uint8 = 16 // BLA_W_LP，随机接入图像(IRAP)起始
+	hevcNalTypeIRAPMax uint8 = 23 // RSV_IRAP_VCL23，随机接入图像(IRAP)结束
+	hevcNalTypeSPS     uint8 = 33 // 序列参数集
+)
+
+// VideoCodecInfo 视频编码信息
+type VideoCodecInfo struct {
+	StreamType         uint8   //8 流类型，0 表示未知
+	ProfileSpace       uint8   //2 HEVC general_profile_space
+	TierFlag           uint8   //1 HEVC general_tier_flag
+	ProfileIDC         uint8   //8 profile_idc，HEVC 为 general_profile_idc(5bit)
+	CompatibilityFlags uint32  //32 HEVC general_profile_compatibility_flags
+	ConstraintFlags    uint64  //48 约束标志，H.264 为 constraint_set 标志(8bit)
+	LevelIDC           uint8   //8 level_idc
+	Width              uint16  //16 图像宽度，去除裁剪区域，0 表示未知
+	Height             uint16  //16 图像高度，去除裁剪区域，0 表示未知
+	FrameRate          float64 // 帧率，0 表示未知
+}
+
+// bitReader 按位读取RBSP数据，超出数据长度时读取结果为0并记录错误
+type bitReader struct {
+	data []byte // 数据
+	pos  int    // 当前位置(bit)
+	err  bool   // 是否超出数据长度
+}
+
+// readBits 读取 n 位无符号整数，n 不超过32
+func (r *bitReader) readBits(n int) uint32 {
+
+	var value uint32
+	var i int
+	for i = 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = true
+			return 0
+		}
+		value = value<<1 | uint32(r.data[r.pos/8]>>(7-uint(r.pos%8))&0x1)
+		r.pos++
+	}
+
+	return value
+}
+
+// readFlag 读取1位标志
+func (r *bitReader) readFlag() bool {
+	return r.readBits(1) == 1
+}
+
+// readUE 读取无符号指数哥伦布编码 ue(v)
+func (r *bitReader) readUE() uint32 {
+
+	var leadingZeros int
+	for !r.readFlag() {
+		if r.err || leadingZeros >= 32 {
+			r.err = true
+			return 0
+		}
+		leadingZeros++
+	}
+
+	return (1<<uint(leadingZeros) - 1) + r.readBits(leadingZeros)
+}
+
+// readSE 读取有符号指数哥伦布编码 se(v)
+func (r *bitReader) readSE() int32 {
+
+	value := r.readUE()
+	if value%2 == 1 {
+		return int32((value + 1) / 2)
+	}
+	return -int32(value / 2)
+}
+
+// skipBits 跳过 n 位
+func (r *bitReader) skipBits(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.err = true
+	}
+}
+
+// splitNalUnits 按起始码(0x000001/0x00000001)拆分Annex B格式的ES数据
+// 返回的NAL单元不包含起始码
+func splitNalUnits(data []byte) [][]byte {
+
+	var nalUnits [][]byte = make([][]byte, 0)
+
+	// 当前NAL单元起始位置，-1 表示尚未找到起始码
+	var start int = -1
+
+	var i int
+	for i = 0; i+2 < len(data); i++ {
+
+		// 找到起始码
+		if data[i] == 0x00 && data[i+1] == 0x00 && data[i+2] == 0x01 {
+
+			if start >= 0 {
+
+				// 四字节起始码的前导0不属于上一个NAL单元
+				end := i
+				for end > start && data[end-1] == 0x00 {
+					end--
+				}
+				nalUnits = append(nalUnits, data[start:end])
+			}
+
+			start = i + 3
+			i += 2
+		}
+	}
+
+	// 最后一个NAL单元
+	if start >= 0 && start < len(data) {
+		nalUnits = append(nalUnits, data[start:])
+	}
+
+	return nalUnits
+}
+
+// removeEmulationPrevention 去除NAL单元中的防竞争字节(0x000003中的0x03)
+func removeEmulationPrevention(nal []byte) []byte {
+
+	var rbsp []byte = make([]byte, 0, len(nal))
+	var zeroCount int = 0
+
+	for _, b := range nal {
+		if zeroCount >= 2 && b == 0x03 {
+			zeroCount = 0
+			continue
+		}
+
+		if b == 0x00 {
+			zeroCount++
+		} else {
+			zeroCount = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
+
+// GetH264ParameterSets 获取H.264帧中的第一个序列参数集及图像参数集，不存在时返回nil
+func GetH264ParameterSets(es []byte) ([]byte, []byte) {
+
+	var sps []byte
+	var pps []byte
+	for _, nal := range splitNalUnits(es) {
+		if len(nal) == 0 {
+			continue
+		}
+
+		switch h264NalType(nal) {
+		case h264NalTypeSPS:
+			if sps == nil {
+				sps = nal
+			}
+		case h264NalTypePPS:
+			if pps == nil {
+				pps = nal
+			}
+		}
+	}
+
+	return sps, pps
+}
+
+// ConvertH264Sample 将Annex B格式的H.264帧转换为4字节长度前缀的格式(ISO/IEC 14496-15)
+// 去除访问单元分隔符，参数集保留在样本中(avc3)，参数集在媒体中途变化时仍可解码
+func ConvertH264Sample(es []byte) []byte {
+
+	var sample []byte = make([]byte, 0, len(es))
+	for _, nal := range splitNalUnits(es) {
+		if len(nal) == 0 {
+			continue
+		}
+
+		switch h264NalType(nal) {
+		case h264NalTypeAUD:
+			continue
+		}
+
+		sample = append(sample, byte(len(nal)>>24), byte(len(nal)>>16), byte(len(nal)>>8), byte(len(nal)))
+		sample = append(sample, nal...)
+	}
+
+	return sample
+}
+
+// h264NalType H.264 NAL单元类型
+func h264NalType(nal []byte) uint8 {
+	return nal[0] & 0x1f
+}
+
+// hevcNalType HEVC NAL单元类型
+func hevcNalType(nal []byte) uint8 {
+	return nal[0] >> 1 & 0x3f
+}
+
+// parseHevcSps 从HEVC序列参数集中提取 profile_tier_level
+func parseHevcSps(nal []byte) (VideoCodecInfo, bool) {
+
+	var info VideoCodecInfo
+	rbsp := removeEmulationPrevention(nal)
+
+	// NAL头(16bit),sps_video_parameter_set_id(4bit),sps_max_sub_layers_minus1(3bit),
+	// sps_temporal_id_nesting_flag(1bit),general_profile_tier_level(96bit)
+	if len(rbsp) < 15 {
+		return info, false
+	}
+
+	info.StreamType = StreamTypeHEVC
+	info.ProfileSpace = rbsp[3] >> 6 & 0x3
+	info.TierFlag = rbsp[3] >> 5 & 0x1
+	info.ProfileIDC = rbsp[3] & 0x1f
+	info.CompatibilityFlags = uint32(rbsp[4])<<24 | uint32(rbsp[5])<<16 | uint32(rbsp[6])<<8 | uint32(rbsp[7])
+	info.ConstraintFlags = uint64(rbsp[8])<<40 | uint64(rbsp[9])<<32 | uint64(rbsp[10])<<24 |
+		uint64(rbsp[11])<<16 | uint64(rbsp[12])<<8 | uint64(rbsp[13])
+	info.LevelIDC = rbsp[14]
+
+	// sub_layer_profile_present_flag(1),sub_layer_level_present_flag(1)，共 sps_max_sub_layers_minus1 个，不足8个时以2bit对齐
+	r := bitReader{data: rbsp, pos: 15 * 8}
+	maxSubLayersMinus1 := int(rbsp[2] >> 1 & 0x7)
+	var subLayerProfilePresent [8]bool
+	var subLayerLevelPresent [8]bool
+	var i int
+	for i = 0; i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i] = r.readFlag()
+		subLayerLevelPresent[i] = r.readFlag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skipBits((8 - maxSubLayersMinus1) * 2)
+	}
+	for i = 0; i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] {
+			r.skipBits(88)
+		}
+		if subLayerLevelPresent[i] {
+			r.skipBits(8)
+		}
+	}
+
+	// sps_seq_parameter_set_id,chroma_format_idc,[separate_colour_plane_flag],
+	// pic_width_in_luma_samples,pic_height_in_luma_samples,conformance_window_flag,[conf_win_offset...]
+	r.readUE()
+	chromaFormatIDC := r.readUE()
+	if chromaFormatIDC == 3 {
+		r.readFlag()
+	}
+	width := r.readUE()
+	height := r.readUE()
+
+	if r.readFlag() {
+		cropUnitX, cropUnitY := getCropUnit(chromaFormatIDC, true)
+		width -= (r.readUE() + r.readUE()) * cropUnitX
+		height -= (r.readUE() + r.readUE()) * cropUnitY
+	}
+
+	// 图像尺寸解析失败时仍保留编码信息
+	if !r.err && width <= 0xFFFF && height <= 0xFFFF {
+		info.Width = uint16(width)
+		info.Height = uint16(height)
+	}
+
+	return info, true
+}
+
+// parseH264Sps 从H.264序列参数集中提取 profile、level、图像尺寸及帧率
+func parseH264Sps(nal []byte) (VideoCodecInfo, bool) {
+
+	var info VideoCodecInfo
+	rbsp := removeEmulationPrevention(nal)
+
+	// NAL头(8bit),profile_idc(8bit),constraint_set_flags(8bit),level_idc(8bit)
+	if len(rbsp) < 4 {
+		return info, false
+	}
+
+	info.StreamType = StreamTypeH264
+	info.ProfileIDC = rbsp[1]
+	info.ConstraintFlags = uint64(rbsp[2])
+	info.LevelIDC = rbsp[3]
+
+	r := bitReader{data: rbsp, pos: 4 * 8}
+
+	// seq_parameter_set_id
+	r.readUE()
+
+	// High 及以上 profile 包含色度格式、位深及缩放矩阵
+	var chromaFormatIDC uint32 = 1
+	switch info.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIDC = r.readUE()
+		if chromaFormatIDC == 3 {
+			r.readFlag()
+		}
+		r.readUE()
+		r.readUE()
+		r.readFlag()
+
+		// seq_scaling_matrix_present_flag
+		if r.readFlag() {
+			var count int = 8
+			if chromaFormatIDC == 3 {
+				count = 12
+			}
+			var i int
+			for i = 0; i < count; i++ {
+				if r.readFlag() {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(&r, size)
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4,pic_order_cnt_type
+	r.readUE()
+	picOrderCntType := r.readUE()
+	if picOrderCntType == 0 {
+		r.readUE()
+	} else if picOrderCntType == 1 {
+		r.readFlag()
+		r.readSE()
+		r.readSE()
+		numRefFramesInPicOrderCntCycle := r.readUE()
+		var i uint32
+		for i = 0; i < numRefFramesInPicOrderCntCycle && !r.err; i++ {
+			r.readSE()
+		}
+	}
+
+	// max_num_ref_frames,gaps_in_frame_num_value_allowed_flag
+	r.readUE()
+	r.readFlag()
+
+	widthInMbs := r.readUE() + 1
+	heightInMapUnits := r.readUE() + 1
+	frameMbsOnly := r.readFlag()
+	if !frameMbsOnly {
+		r.readFlag()
+	}
+
+	// direct_8x8_inference_flag
+	r.readFlag()
+
+	var frameHeightFactor uint32 = 1
+	if !frameMbsOnly {
+		frameHeightFactor = 2
+	}
+	width := widthInMbs * 16
+	height := frameHeightFactor * heightInMapUnits * 16
+
+	// frame_cropping_flag
+	if r.readFlag() {
+		cropUnitX, cropUnitY := getCropUnit(chromaFormatIDC, frameMbsOnly)
+		width -= (r.readUE() + r.readUE()) * cropUnitX
+		height -= (r.readUE() + r.readUE()) * cropUnitY
+	}
+
+	if r.err || width > 0xFFFF || height > 0xFFFF {
+		return info, true
+	}
+	info.Width = uint16(width)
+	info.Height = uint16(height)
+
+	// vui_parameters_present_flag
+	if !r.readFlag() {
+		return info, true
+	}
+
+	// aspect_ratio_info_present_flag,aspect_ratio_idc(8)，255 时为 sar_width(16),sar_height(16)
+	if r.readFlag() && r.readBits(8) == 255 {
+		r.skipBits(32)
+	}
+
+	// overscan_info_present_flag,overscan_appropriate_flag
+	if r.readFlag() {
+		r.readFlag()
+	}
+
+	// video_signal_type_present_flag,video_format(3),video_full_range_flag(1),colour_description_present_flag(1),颜色描述(24)
+	if r.readFlag() {
+		r.skipBits(4)
+		if r.readFlag() {
+			r.skipBits(24)
+		}
+	}
+
+	// chroma_loc_info_present_flag
+	if r.readFlag() {
+		r.readUE()
+		r.readUE()
+	}
+
+	// timing_info_present_flag,num_units_in_tick(32),time_scale(32)，每帧为两个 tick
+	if r.readFlag() {
+		numUnitsInTick := r.readBits(32)
+		timeScale := r.readBits(32)
+		if !r.err && numUnitsInTick > 0 {
+			info.FrameRate = float64(timeScale) / float64(2*numUnitsInTick)
+		}
+	}
+
+	return info, true
+}
+
+// skipScalingList 跳过H.264缩放矩阵
+func skipScalingList(r *bitReader, size int) {
+
+	var lastScale int32 = 8
+	var nextScale int32 = 8
+	var i int
+	for i = 0; i < size && !r.err; i++ {
+		if nextScale != 0 {
+			nextScale = (lastScale + r.readSE() + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
+
+// getCropUnit 裁剪区域的单位，与色度格式及场编码有关
+func getCropUnit(chromaFormatIDC uint32, frameMbsOnly bool) (uint32, uint32) {
+
+	var cropUnitX, cropUnitY uint32 = 1, 1
+	switch chromaFormatIDC {
+	case 1:
+		cropUnitX, cropUnitY = 2, 2
+	case 2:
+		cropUnitX, cropUnitY = 2, 1
+	}
+
+	if !frameMbsOnly {
+		cropUnitY *= 2
+	}
+
+	return cropUnitX, cropUnitY
+}
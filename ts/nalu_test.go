@@ -0,0 +1,55 @@
+package ts
+
+import "testing"
+
+// TestParseH264Sps 使用已知的序列参数集校验 profile、level、图像尺寸及帧率
+func TestParseH264Sps(t *testing.T) {
+
+	var cases = []struct {
+		name string
+		nal  []byte
+		ok   bool
+		want VideoCodecInfo
+	}{
+		{
+			// High 4.0，120x68 宏块，底部裁剪8行，VUI 中 time_scale 50、num_units_in_tick 1，包含防竞争字节
+			name: "high 1080p25",
+			nal: []byte{0x67, 0x64, 0x00, 0x28, 0xAC, 0xD9, 0x40, 0x78, 0x02, 0x27, 0xE5, 0xC0,
+				0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xCA, 0x10},
+			ok: true,
+			want: VideoCodecInfo{StreamType: StreamTypeH264, ProfileIDC: 100, ConstraintFlags: 0x00, LevelIDC: 40,
+				Width: 1920, Height: 1080, FrameRate: 25},
+		},
+		{
+			// Constrained Baseline 3.0，40x30 宏块，无 VUI
+			name: "baseline 480p",
+			nal:  []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x02, 0x80, 0xF6, 0x40},
+			ok:   true,
+			want: VideoCodecInfo{StreamType: StreamTypeH264, ProfileIDC: 66, ConstraintFlags: 0xC0, LevelIDC: 30,
+				Width: 640, Height: 480},
+		},
+		{
+			// 截断的序列参数集只能取得 profile 及 level
+			name: "truncated",
+			nal:  []byte{0x67, 0x64, 0x00, 0x28, 0xAC},
+			ok:   true,
+			want: VideoCodecInfo{StreamType: StreamTypeH264, ProfileIDC: 100, ConstraintFlags: 0x00, LevelIDC: 40},
+		},
+		{
+			name: "too short",
+			nal:  []byte{0x67, 0x64, 0x00},
+			ok:   false,
+		},
+	}
+
+	for _, c := range cases {
+		got, ok := parseH264Sps(c.nal)
+		if ok != c.ok {
+			t.Errorf("%s: ok = %v, want %v", c.name, ok, c.ok)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: parseH264Sps = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package ts
+
+// ProgramPsi 节目的pat、pmt段，在分片开始处插入，使每个分片都可以独立解析
+type ProgramPsi struct {
+	PmtPID     uint16 // pmt所在的PID
+	PatSection []byte // pat段，包含CRC
+	PmtSection []byte // pmt段，包含CRC
+}
+
+// muxPatSection 根据pat表生成pat段
+// table_id(8),section_syntax_indicator(1),'0'(1),reserved(2),section_length(12),transport_stream_id(16),
+// reserved(2),version_number(5),current_next_indicator(1),section_number(8),last_section_number(8),
+// {program_number(16),reserved(3),PID(13)}...,CRC_32(32)
+func muxPatSection(pat *Pat) []byte {
+
+	var loopData []byte
+
+	// 网络信息表使用节目号 0
+	if pat.NetworkPID != 0 {
+		loopData = append(loopData, 0x00, 0x00, 0xE0|byte(pat.NetworkPID>>8&0x1F), byte(pat.NetworkPID))
+	}
+
+	for _, program := range pat.Programs {
+		if program.ProgramNumber == 0 {
+			continue
+		}
+		loopData = append(loopData, byte(program.ProgramNumber>>8), byte(program.ProgramNumber),
+			0xE0|byte(program.PID>>8&0x1F), byte(program.PID))
+	}
+
+	return muxSection(0x00, pat.TransportstreamID, pat.VersionNumber, loopData)
+}
+
+// muxPmtSection 根据pmt表生成pmt段，节目描述信息未保存，program_info_length 为 0
+// table_id(8),section_syntax_indicator(1),'0'(1),reserved(2),section_length(12),program_number(16),
+// reserved(2),version_number(5),current_next_indicator(1),section_number(8),last_section_number(8),
+// reserved(3),PCR_PID(13),reserved(4),program_info_length(12),
+// {stream_type(8),reserved(3),elementary_PID(13),reserved(4),ES_info_length(12),descriptor()}...,CRC_32(32)
+func muxPmtSection(pmt *Pmt) []byte {
+
+	var loopData []byte
+	loopData = append(loopData, 0xE0|byte(pmt.PcrPID>>8&0x1F), byte(pmt.PcrPID), 0xF0, 0x00)
+
+	for _, s := range pmt.Streams {
+		loopData = append(loopData, s.StreamType, 0xE0|byte(s.ElementaryPID>>8&0x1F), byte(s.ElementaryPID),
+			0xF0|byte(len(s.Descriptors)>>8&0x0F), byte(len(s.Descriptors)))
+		loopData = append(loopData, s.Descriptors...)
+	}
+
+	return muxSection(0x02, pmt.ProgramNumber, pmt.VersionNumber, loopData)
+}
+
+// filterPmtSection 根据pmt段生成只包含指定基本流的pmt段，PCR_PID 及节目描述信息保持不变
+//
+//	PIDs 保留的基本流PID
+func filterPmtSection(section []byte, PIDs []uint16) []byte {
+
+	if len(section) < 16 {
+		return nil
+	}
+
+	// 段头之后为 PCR_PID(16),program_info_length(16),节目描述信息
+	programInfoLength := int(section[10]&0x0F)<<8 | int(section[11])
+	if 12+programInfoLength > len(section)-4 {
+		return nil
+	}
+
+	var pidMap map[uint16]bool = make(map[uint16]bool)
+	for _, PID := range PIDs {
+		pidMap[PID] = true
+	}
+
+	var loopData []byte = append([]byte(nil), section[8:12+programInfoLength]...)
+
+	var pos int = 12 + programInfoLength
+	for pos+5 <= len(section)-4 {
+
+		elementaryPID := uint16(section[pos+1]&0x1F)<<8 | uint16(section[pos+2])
+		esInfoLength := int(section[pos+3]&0x0F)<<8 | int(section[pos+4])
+		if pos+5+esInfoLength > len(section)-4 {
+			return nil
+		}
+
+		if pidMap[elementaryPID] {
+			loopData = append(loopData, section[pos:pos+5+esInfoLength]...)
+		}
+		pos += 5 + esInfoLength
+	}
+
+	tableIDExtension := uint16(section[3])<<8 | uint16(section[4])
+	versionNumber := section[5] >> 1 & 0x1F
+	return muxSection(0x02, tableIDExtension, versionNumber, loopData)
+}
+
+// muxSection 生成单个分段的长格式段，计算段长度及CRC
+func muxSection(tableID uint8, tableIDExtension uint16, versionNumber uint8, loopData []byte) []byte {
+
+	// 段长度包含段头剩余的5个字节及CRC
+	sectionLength := 5 + len(loopData) + 4
+
+	var section []byte = make([]byte, 0, 3+sectionLength)
+	section = append(section, tableID, 0xB0|byte(sectionLength>>8&0x0F), byte(sectionLength))
+	section = append(section, byte(tableIDExtension>>8), byte(tableIDExtension), 0xC1|(versionNumber&0x1F)<<1, 0x00, 0x00)
+	section = append(section, loopData...)
+
+	crc := crc32Mpeg2(section)
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// CreatePsiPackets 创建分片开始处插入的pat、pmt包
+// 连续计数器按分片中同一PID的第一个包倒推，插入的包与分片中的包保持连续
+//
+//	segment 分片开始部分的数据
+//	pkgSize 分片的包大小，M2TS 包的时间戳取自分片的第一个包，204字节包的校验码填充为0
+func CreatePsiPackets(psi *ProgramPsi, segment []byte, pkgSize int) []byte {
+
+	if psi == nil || len(psi.PatSection) == 0 || len(psi.PmtSection) == 0 {
+		return nil
+	}
+
+	// M2TS 包的同步字节位于4字节时间戳之后
+	var syncOffset int = 0
+	if pkgSize == M2tsPkgSize {
+		syncOffset = pkgSize - TsPkgSize
+	}
+
+	var patPackets [][]byte = packetizeSection(0x0, psi.PatSection, findContinuityCounter(segment, pkgSize, syncOffset, 0x0))
+	var pmtPackets [][]byte = packetizeSection(psi.PmtPID, psi.PmtSection, findContinuityCounter(segment, pkgSize, syncOffset, psi.PmtPID))
+
+	var result []byte
+	for _, pKgBuf := range append(patPackets, pmtPackets...) {
+
+		if syncOffset > 0 && len(segment) >= syncOffset {
+			result = append(result, segment[0:syncOffset]...)
+		}
+		result = append(result, pKgBuf...)
+		if pkgSize > TsPkgSize+syncOffset {
+			result = append(result, make([]byte, pkgSize-TsPkgSize-syncOffset)...)
+		}
+	}
+
+	return result
+}
+
+// packetizeSection 将段打包为ts包，最后一个包的连续计数器为 nextCC 的前一个值
+func packetizeSection(PID uint16, section []byte, nextCC uint8) [][]byte {
+
+	// 第一个包包含 pointer_field
+	var payload []byte = append([]byte{0x00}, section...)
+	var packetCount int = (len(payload) + TsPkgSize - 5) / (TsPkgSize - 4)
+
+	var packets [][]byte
+	var cc uint8 = (nextCC - uint8(packetCount)) & 0x0F
+	for i := 0; i < packetCount; i++ {
+
+		var pKgBuf []byte = make([]byte, TsPkgSize)
+		pKgBuf[0] = 0x47
+		pKgBuf[1] = byte(PID >> 8 & 0x1F)
+		if i == 0 {
+			pKgBuf[1] |= 0x40
+		}
+		pKgBuf[2] = byte(PID)
+		pKgBuf[3] = 0x10 | cc
+		cc = (cc + 1) & 0x0F
+
+		// 段之后的剩余部分填充 0xFF
+		n := copy(pKgBuf[4:], payload)
+		payload = payload[n:]
+		for j := 4 + n; j < TsPkgSize; j++ {
+			pKgBuf[j] = 0xFF
+		}
+		packets = append(packets, pKgBuf)
+	}
+
+	return packets
+}
+
+// findContinuityCounter 查找分片中指定PID第一个带有效载荷的包的连续计数器，不存在时返回0
+func findContinuityCounter(segment []byte, pkgSize int, syncOffset int, PID uint16) uint8 {
+
+	var pos int
+	for pos = syncOffset; pos+TsPkgSize <= len(segment); pos += pkgSize {
+
+		pKgBuf := segment[pos : pos+TsPkgSize]
+		if pKgBuf[0] != 0x47 {
+			continue
+		}
+
+		if uint16(pKgBuf[1]&0x1F)<<8|uint16(pKgBuf[2]) == PID && pKgBuf[3]&0x10 != 0 {
+			return pKgBuf[3] & 0x0F
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,349 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+)
+
+// resyncLookahead 重新同步时需要连续出现同步字节的包数
+const resyncLookahead int = 3
+
+// detectLookahead 识别包大小时需要连续出现同步字节的包数
+const detectLookahead int = 5
+
+// pkgSizes 支持的包大小，按识别顺序排列
+var pkgSizes = []int{TsPkgSize, M2tsPkgSize, FecPkgSize}
+
+// ByteRange 媒体文件中的字节区间
+type ByteRange struct {
+	StartOffset uint64 // 开始偏移量
+	Size        uint64 // 字节数
+}
+
+// packetReader 从媒体文件中逐个读取ts包
+// 自动识别包大小(188/192/204)，遇到损坏的数据时向后查找同步字节重新同步，并记录跳过的字节区间
+type packetReader struct {
+	reader        io.Reader
+	pkgSize       int         // 包大小，0 表示尚未识别
+	syncOffset    int         // 同步字节在包中的位置
+	buf           []byte      // 预加载缓冲区
+	start         int         // 未读数据起始位置
+	end           int         // 未读数据结束位置
+	eof           bool        // 是否已读到文件结尾
+	offset        uint64      // buf[start] 在文件中的偏移量
+	inSync        bool        // 是否处于同步状态
+	skippedRanges []ByteRange // 跳过的损坏数据区间
+}
+
+// newPacketReader 创建ts包读取器
+//
+//	bufferSize 预加载缓冲区大小
+func newPacketReader(reader io.Reader, bufferSize int) *packetReader {
+
+	// 缓冲区至少能容纳识别包大小需要检查的包
+	if bufferSize < FecPkgSize*(detectLookahead+1) {
+		bufferSize = FecPkgSize * (detectLookahead + 1)
+	}
+
+	var r packetReader
+	r.reader = reader
+	r.buf = make([]byte, bufferSize)
+	return &r
+}
+
+// ReadPacket 读取下一个ts包，返回188字节的ts包数据及原始包在文件中的偏移量
+// 返回的包数据在下次读取前有效，文件结束时返回 io.EOF
+func (r *packetReader) ReadPacket() ([]byte, uint64, error) {
+
+	// 当前跳过区间的起始偏移量，-1 表示未跳过数据
+	var skipStart int64 = -1
+
+	for {
+		err := r.fill(FecPkgSize * detectLookahead)
+		if err != nil {
+			return nil, r.offset, err
+		}
+
+		// 已读到文件结尾
+		if r.end == r.start {
+			r.addSkippedRange(skipStart)
+			return nil, r.offset, io.EOF
+		}
+
+		// 识别包大小后检查同步字节
+		if (r.pkgSize != 0 || r.detectPkgSize()) && r.checkSync(r.start+r.syncOffset) {
+
+			// 文件结尾不足一个包的数据视为损坏
+			if r.end-r.start >= r.pkgSize {
+				break
+			}
+		}
+
+		// 失去同步，逐字节向后查找
+		if skipStart < 0 {
+			skipStart = int64(r.offset)
+		}
+		r.inSync = false
+		r.start++
+		r.offset++
+	}
+
+	r.addSkippedRange(skipStart)
+	r.inSync = true
+
+	pKgBuf := r.buf[r.start+r.syncOffset : r.start+r.syncOffset+TsPkgSize]
+	pKgOffset := r.offset
+	r.start += r.pkgSize
+	r.offset += uint64(r.pkgSize)
+
+	return pKgBuf, pKgOffset, nil
+}
+
+// PkgSize 识别到的包大小，尚未识别时为0
+func (r *packetReader) PkgSize() int {
+	return r.pkgSize
+}
+
+// detectPkgSize 识别包大小，当前位置起连续 detectLookahead 个包的同步字节间隔一致时识别成功
+func (r *packetReader) detectPkgSize() bool {
+
+	for _, pkgSize := range pkgSizes {
+
+		// M2TS 同步字节之前为4字节的时间戳
+		syncOffset := 0
+		if pkgSize == M2tsPkgSize {
+			syncOffset = m2tsSyncOffset
+		}
+		if r.start+syncOffset >= r.end {
+			continue
+		}
+
+		var i int
+		for i = 0; i < detectLookahead; i++ {
+			if !r.isSyncByte(r.start + syncOffset + pkgSize*i) {
+				break
+			}
+		}
+
+		if i == detectLookahead {
+			r.pkgSize = pkgSize
+			r.syncOffset = syncOffset
+			Log.Debug("Detect packet size: " + fmt.Sprint(pkgSize) + ", offset: " + fmt.Sprint(r.offset))
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConvertToTs 将媒体数据转换为188字节的ts包写出，去除M2TS时间戳及 Reed-Solomon 校验码，损坏的数据将被跳过
+func ConvertToTs(reader io.Reader, writer io.Writer) error {
+
+	r := newPacketReader(reader, TsPkgSize*TsReloadNum/100)
+	for {
+		pKgBuf, _, err := r.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		_, err = writer.Write(pKgBuf)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// TsFilter ts包过滤条件
+type TsFilter struct {
+	PIDs     []uint16 // 需要输出的PID
+	Size     uint64   // 需要输出的源数据字节数
+	AlignPID int      // 按该PID的pes边界对齐，-1 表示不对齐
+	PcrPID   int      // 只输出PCR的PID，该PID中带PCR的包去掉有效载荷后输出，-1 表示不输出
+}
+
+// maxAlignSize 按pes边界对齐时，超出输出范围后最多继续读取的字节数
+const maxAlignSize uint64 = 4 * 1024 * 1024
+
+// FilterTs 将媒体数据中指定PID的包转换为188字节的ts包写出，损坏的数据将被跳过
+// 按pes边界对齐时，丢弃对齐流第一个pes起始包之前的数据，并继续输出对齐流至范围之后的第一个pes起始包，
+// 连续的范围依次过滤时，对齐流的每个pes只出现在一个范围中
+func FilterTs(reader io.Reader, writer io.Writer, filter *TsFilter) error {
+
+	var pidMap map[uint16]bool = make(map[uint16]bool)
+	for _, PID := range filter.PIDs {
+		pidMap[PID] = true
+	}
+
+	// 不对齐时从第一个包开始输出
+	var started bool = filter.AlignPID < 0
+
+	r := newPacketReader(reader, TsPkgSize*TsReloadNum/100)
+	for {
+		pKgBuf, offset, err := r.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		PID := int(pKgBuf[1]&0x1f)<<8 | int(pKgBuf[2])
+		payloadUnitStart := pKgBuf[1]&0x40 != 0
+
+		if offset >= filter.Size {
+
+			// 超出范围后只继续输出对齐流，遇到下一个pes起始包时结束
+			if !started || filter.AlignPID < 0 || offset >= filter.Size+maxAlignSize {
+				return nil
+			}
+			if PID != filter.AlignPID {
+				continue
+			}
+			if payloadUnitStart {
+				return nil
+			}
+		}
+
+		// 对齐流第一个pes起始包之前的数据属于上一个范围
+		if !started {
+			if PID == filter.AlignPID && payloadUnitStart {
+				started = true
+			} else if PID == filter.AlignPID {
+				continue
+			}
+		}
+
+		if PID == filter.PcrPID && !pidMap[uint16(PID)] {
+			pKgBuf = getPcrPacket(pKgBuf)
+			if pKgBuf == nil {
+				continue
+			}
+		} else if !pidMap[uint16(PID)] {
+			continue
+		}
+
+		_, err = writer.Write(pKgBuf)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getPcrPacket 带PCR的包去掉有效载荷，生成只包含适配域的包，不带PCR时返回nil
+// 只有适配域的包连续计数器不增加，固定为0；随机访问及优先级标志属于去掉的基本流，一并清除
+func getPcrPacket(pKgBuf []byte) []byte {
+
+	// adaptation_field_control 包含适配域，adaptation_field_length(8),flags(8)，PCR_flag 为 0x10
+	if pKgBuf[3]&0x20 == 0 || pKgBuf[4] == 0 || pKgBuf[5]&0x10 == 0 {
+		return nil
+	}
+
+	adaptationLength := int(pKgBuf[4])
+	if adaptationLength > TsPkgSize-5 {
+		return nil
+	}
+
+	var pcrPkgBuf []byte = make([]byte, 0, TsPkgSize)
+	pcrPkgBuf = append(pcrPkgBuf, pKgBuf[0], pKgBuf[1]&^0x40, pKgBuf[2], pKgBuf[3]&0xC0|0x20, byte(TsPkgSize-5))
+	pcrPkgBuf = append(pcrPkgBuf, pKgBuf[5]&^0x60)
+	pcrPkgBuf = append(pcrPkgBuf, pKgBuf[6:5+adaptationLength]...)
+	for len(pcrPkgBuf) < TsPkgSize {
+		pcrPkgBuf = append(pcrPkgBuf, 0xFF)
+	}
+
+	return pcrPkgBuf
+}
+
+// Offset 已读取的字节数
+func (r *packetReader) Offset() uint64 {
+	return r.offset
+}
+
+// SkippedRanges 跳过的损坏数据区间
+func (r *packetReader) SkippedRanges() []ByteRange {
+	return r.skippedRanges
+}
+
+// SkippedSize 跳过的损坏数据总字节数
+func (r *packetReader) SkippedSize() uint64 {
+	var size uint64
+	for _, skippedRange := range r.skippedRanges {
+		size += skippedRange.Size
+	}
+	return size
+}
+
+// fill 保证缓冲区中至少有 size 字节未读数据，文件结尾时可能不足
+func (r *packetReader) fill(size int) error {
+
+	if r.end-r.start >= size || r.eof {
+		return nil
+	}
+
+	// 未读数据移动到缓冲区头部
+	if r.start > 0 {
+		copy(r.buf, r.buf[r.start:r.end])
+		r.end -= r.start
+		r.start = 0
+	}
+
+	for r.end < size && !r.eof {
+		n, err := r.reader.Read(r.buf[r.end:])
+		r.end += n
+
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			r.eof = true
+		}
+	}
+
+	return nil
+}
+
+// checkSync 检查 pos 处是否为有效的ts包起始
+// 已同步时，后续两个包之一的同步字节正确即可，避免单个同步字节损坏丢弃前一个包
+// 未同步时，需要连续 resyncLookahead 个包的同步字节正确
+func (r *packetReader) checkSync(pos int) bool {
+
+	if r.buf[pos] != 0x47 {
+		return false
+	}
+
+	if r.inSync {
+		return r.isSyncByte(pos+r.pkgSize) || r.isSyncByte(pos+r.pkgSize*2)
+	}
+
+	var i int
+	for i = 1; i < resyncLookahead; i++ {
+		if !r.isSyncByte(pos + r.pkgSize*i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSyncByte 缓冲区 pos 处是否为同步字节，超出文件结尾时视为正确
+func (r *packetReader) isSyncByte(pos int) bool {
+	if pos >= r.end {
+		return true
+	}
+	return r.buf[pos] == 0x47
+}
+
+// addSkippedRange 记录从 skipStart 到当前偏移量之间跳过的数据
+func (r *packetReader) addSkippedRange(skipStart int64) {
+
+	if skipStart < 0 || uint64(skipStart) == r.offset {
+		return
+	}
+
+	skippedRange := ByteRange{StartOffset: uint64(skipStart), Size: r.offset - uint64(skipStart)}
+	r.skippedRanges = append(r.skippedRanges, skippedRange)
+	Log.Warn("Skip corrupted data, offset: " + fmt.Sprint(skippedRange.StartOffset) + ", size: " + fmt.Sprint(skippedRange.Size))
+}
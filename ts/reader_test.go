@@ -0,0 +1,66 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testPacket 生成测试用的ts包，pcr 为 true 时包含带PCR的适配域
+func testPacket(PID uint16, cc uint8, pcr bool, payloadStart bool) []byte {
+
+	var pKgBuf []byte = []byte{0x47, byte(PID >> 8 & 0x1F), byte(PID), 0x10 | cc&0x0F}
+	if payloadStart {
+		pKgBuf[1] |= 0x40
+	}
+	if pcr {
+		// adaptation_field_length 7，random_access_indicator 及 PCR_flag，PCR 为 1
+		pKgBuf[3] |= 0x20
+		pKgBuf = append(pKgBuf, 0x07, 0x50, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00)
+	}
+	for len(pKgBuf) < TsPkgSize {
+		pKgBuf = append(pKgBuf, byte(PID))
+	}
+	return pKgBuf
+}
+
+// TestFilterTsPcrPID 校验PCR所在的流未保留时只输出其中带PCR的适配域
+func TestFilterTsPcrPID(t *testing.T) {
+
+	var input []byte
+	input = append(input, testPacket(0x100, 0, true, true)...)
+	input = append(input, testPacket(0x101, 0, false, true)...)
+	input = append(input, testPacket(0x100, 1, false, false)...)
+	input = append(input, testPacket(0x100, 2, true, false)...)
+	input = append(input, testPacket(0x101, 1, false, false)...)
+
+	// 只包含适配域，adaptation_field_length 183，清除随机访问标志，连续计数器为0
+	var pcrPacket []byte = []byte{0x47, 0x01, 0x00, 0x20, 0xB7, 0x10, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00}
+	pcrPacket = append(pcrPacket, bytes.Repeat([]byte{0xFF}, TsPkgSize-len(pcrPacket))...)
+
+	var cases = []struct {
+		name   string
+		filter TsFilter
+		want   [][]byte
+	}{
+		{
+			name:   "pcr on filtered video",
+			filter: TsFilter{PIDs: []uint16{0x101}, Size: uint64(len(input)), AlignPID: -1, PcrPID: 0x100},
+			want:   [][]byte{pcrPacket, input[188*1 : 188*2], pcrPacket, input[188*4 : 188*5]},
+		},
+		{
+			name:   "pcr on kept stream",
+			filter: TsFilter{PIDs: []uint16{0x100}, Size: uint64(len(input)), AlignPID: -1, PcrPID: -1},
+			want:   [][]byte{input[0:188], input[188*2 : 188*3], input[188*3 : 188*4]},
+		},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := FilterTs(bytes.NewReader(input), &buf, &c.filter); err != nil {
+			t.Fatalf("%s: FilterTs: %v", c.name, err)
+		}
+		if want := bytes.Join(c.want, nil); !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: output mismatch\ngot  % X\nwant % X", c.name, buf.Bytes(), want)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package ts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+
+	errors "../errors"
+)
+
+// SAMPLE-AES 加密后的流类型(Apple MPEG-2 Stream Encryption Format for HTTP Live Streaming)
+const (
+	StreamTypeH264SampleAes uint8 = 0xdb // SAMPLE-AES 加密的 h.264
+	StreamTypeAACSampleAes  uint8 = 0xcf // SAMPLE-AES 加密的 ADTS aac
+)
+
+// 描述符标签
+const (
+	descriptorTagRegistration         uint8 = 0x05 // registration_descriptor
+	descriptorTagPrivateDataIndicator uint8 = 0x0f // private_data_indicator_descriptor
+)
+
+// SAMPLE-AES 加密范围
+const (
+	sampleAesNalMinSize    int = 48  // 不超过该长度的NAL单元不加密
+	sampleAesNalClearSize  int = 32  // NAL单元开始部分不加密的字节数
+	sampleAesNalSkipSize   int = 144 // 每个加密块之后不加密的字节数
+	sampleAesAudioLeadSize int = 16  // 音频帧头之后不加密的字节数
+)
+
+// SampleAes SAMPLE-AES 加密参数，只加密 H.264 的 slice 及 AAC 帧的数据，ts及pes头保持明文
+type SampleAes struct {
+	Key        []byte   // AES-128 密钥
+	IV         []byte   // 初始向量，每个NAL单元及音频帧重新开始
+	PmtPID     uint16   // pmt所在的PID
+	PmtSection []byte   // 节目的pmt段，加密后替换流类型并追加描述符
+	VideoPID   uint16   // H.264 视频流PID，0 表示不存在
+	AudioPIDs  []uint16 // ADTS aac 音频流PID
+	AudioSetup []byte   // 音频的 AudioSpecificConfig，写入pmt的 audio_setup_information
+}
+
+// sampleAesPes 正在收集的pes
+type sampleAesPes struct {
+	indexes []int    // pes所在的ts包在分片中的序号
+	packets [][]byte // pes所在的ts包
+	started bool     // 是否从pes起始包开始
+}
+
+// EncryptSampleAes 以 SAMPLE-AES 方式加密188字节ts包组成的分片
+func EncryptSampleAes(segment []byte, sampleAes *SampleAes) ([]byte, error) {
+
+	var buf bytes.Buffer
+	writer, err := NewSampleAesWriter(&buf, sampleAes)
+	if err != nil {
+		return nil, err
+	}
+	writer.Write(segment)
+	writer.Close()
+
+	return buf.Bytes(), nil
+}
+
+// SampleAesWriter 以 SAMPLE-AES 方式流式加密188字节ts包组成的分片
+// pmt替换为加密后的流类型，视频及音频pes重新打包后按原有的包顺序输出，包数变化时连续计数器顺延
+// 分片开始处不完整的pes保持明文；只缓存尚未结束的pes及其之后的包，结束的pes加密后立即写出
+type SampleAesWriter struct {
+	writer     io.Writer
+	sampleAes  *SampleAes
+	block      cipher.Block
+	pmtSection []byte                   // 加密后的pmt段，nil 表示不替换
+	isVideo    map[uint16]bool          // 是否为视频流
+	pesMap     map[uint16]*sampleAesPes // 正在收集的pes
+	ccMap      map[uint16]uint8         // 重新打包的PID的连续计数器
+	outputs    [][][]byte               // 尚未写出的包，每个包替换后输出的包，nil 表示丢弃
+	base       int                      // outputs 中第一个包在分片中的序号
+	remain     []byte                   // 不足一个包的剩余数据
+}
+
+// NewSampleAesWriter 创建 SAMPLE-AES 流式加密
+func NewSampleAesWriter(writer io.Writer, sampleAes *SampleAes) (*SampleAesWriter, error) {
+
+	block, err := aes.NewCipher(sampleAes.Key)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrorCodeGetStreamFailed, "EncryptSampleAes failed, "+err.Error())
+	}
+
+	w := &SampleAesWriter{
+		writer:     writer,
+		sampleAes:  sampleAes,
+		block:      block,
+		pmtSection: createSampleAesPmtSection(sampleAes),
+		isVideo:    make(map[uint16]bool),
+		pesMap:     make(map[uint16]*sampleAesPes),
+		ccMap:      make(map[uint16]uint8),
+	}
+	if sampleAes.VideoPID != 0 {
+		w.isVideo[sampleAes.VideoPID] = true
+		w.pesMap[sampleAes.VideoPID] = &sampleAesPes{}
+	}
+	for _, PID := range sampleAes.AudioPIDs {
+		w.pesMap[PID] = &sampleAesPes{}
+	}
+
+	return w, nil
+}
+
+// Write 按ts包处理数据，写出已结束的pes之前的包，不足一个包的数据留到下一次写入
+func (w *SampleAesWriter) Write(data []byte) (int, error) {
+
+	w.remain = append(w.remain, data...)
+
+	var pos int = 0
+	for pos+TsPkgSize <= len(w.remain) {
+		w.addPacket(append([]byte(nil), w.remain[pos:pos+TsPkgSize]...))
+		pos += TsPkgSize
+	}
+	w.remain = append(w.remain[0:0], w.remain[pos:]...)
+
+	if err := w.writeOutputs(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close 加密分片结尾的pes并写出剩余的包，不完整的音频帧保持明文，不足一个包的数据丢弃
+func (w *SampleAesWriter) Close() error {
+
+	for PID := range w.pesMap {
+		w.flush(PID)
+	}
+	return w.writeOutputs()
+}
+
+// addPacket 处理一个ts包
+func (w *SampleAesWriter) addPacket(pKgBuf []byte) {
+
+	index := w.base + len(w.outputs)
+	w.outputs = append(w.outputs, [][]byte{pKgBuf})
+
+	PID := uint16(pKgBuf[1]&0x1f)<<8 | uint16(pKgBuf[2])
+	payloadUnitStart := pKgBuf[1]&0x40 != 0
+
+	// pmt 起始包替换为加密后的pmt段，后续包丢弃
+	if PID == w.sampleAes.PmtPID && w.pmtSection != nil {
+		w.outputs[index-w.base] = nil
+		if payloadUnitStart && pKgBuf[0] == 0x47 {
+			w.outputs[index-w.base] = packetizeSection(PID, w.pmtSection, pKgBuf[3]&0x0F)
+		}
+		return
+	}
+
+	// 不含有效载荷的包原样输出
+	pes, ok := w.pesMap[PID]
+	if !ok || pKgBuf[0] != 0x47 || pKgBuf[3]&0x10 == 0 {
+		return
+	}
+
+	// 遇到下一个pes起始包时加密上一个pes
+	if payloadUnitStart {
+		w.flush(PID)
+		pes.started = true
+	} else if len(pes.packets) == 0 {
+		pes.started = false
+	}
+	pes.indexes = append(pes.indexes, index)
+	pes.packets = append(pes.packets, pKgBuf)
+}
+
+// flush 加密pes，重新打包后的包依次替换原有的包，多出的包在最后一个包之后输出
+func (w *SampleAesWriter) flush(PID uint16) {
+
+	pes := w.pesMap[PID]
+	if len(pes.packets) == 0 {
+		return
+	}
+
+	var packets [][]byte = pes.packets
+	if pes.started {
+		packets = encryptPesPackets(pes.packets, w.block, w.sampleAes.IV, w.isVideo[PID])
+	}
+
+	for i, index := range pes.indexes {
+		w.outputs[index-w.base] = nil
+		if i < len(packets) {
+			w.outputs[index-w.base] = packets[i : i+1]
+		}
+	}
+	if len(packets) > len(pes.indexes) {
+		lastIndex := pes.indexes[len(pes.indexes)-1] - w.base
+		w.outputs[lastIndex] = append(w.outputs[lastIndex], packets[len(pes.indexes):]...)
+	}
+
+	pes.indexes = nil
+	pes.packets = nil
+}
+
+// writeOutputs 写出第一个正在收集的pes之前的包
+// 重新打包的PID的连续计数器从分片中的第一个包开始顺延，不含有效载荷的包不增加
+func (w *SampleAesWriter) writeOutputs() error {
+
+	var end int = w.base + len(w.outputs)
+	for _, pes := range w.pesMap {
+		if len(pes.indexes) > 0 && pes.indexes[0] < end {
+			end = pes.indexes[0]
+		}
+	}
+
+	var result []byte
+	var i int
+	for i = 0; i < end-w.base; i++ {
+		for _, pKgBuf := range w.outputs[i] {
+
+			PID := uint16(pKgBuf[1]&0x1f)<<8 | uint16(pKgBuf[2])
+			if _, ok := w.pesMap[PID]; !ok && (PID != w.sampleAes.PmtPID || w.pmtSection == nil) {
+				result = append(result, pKgBuf...)
+				continue
+			}
+
+			cc, ok := w.ccMap[PID]
+			if !ok {
+				cc = pKgBuf[3] & 0x0F
+			}
+			if pKgBuf[3]&0x10 == 0 {
+				cc = (cc - 1) & 0x0F
+			}
+
+			result = append(result, pKgBuf[0], pKgBuf[1], pKgBuf[2], pKgBuf[3]&0xF0|cc)
+			result = append(result, pKgBuf[4:]...)
+			w.ccMap[PID] = (cc + 1) & 0x0F
+		}
+	}
+
+	w.outputs = w.outputs[end-w.base:]
+	w.base = end
+
+	if len(result) == 0 {
+		return nil
+	}
+	_, err := w.writer.Write(result)
+	return err
+}
+
+// encryptPesPackets 加密pes的有效载荷，并按原有的包重新打包
+func encryptPesPackets(packets [][]byte, block cipher.Block, iv []byte, isVideo bool) [][]byte {
+
+	var pesBuffer []byte
+	for _, pKgBuf := range packets {
+		pesBuffer = append(pesBuffer, pKgBuf[getPayloadOffset(pKgBuf):]...)
+	}
+
+	// packet_start_code_prefix(24),stream_id(8),PES_packet_length(16),flags(16),PES_header_data_length(8)
+	if len(pesBuffer) < 9 || pesBuffer[0] != 0x00 || pesBuffer[1] != 0x00 || pesBuffer[2] != 0x01 {
+		return packets
+	}
+	headerLength := 9 + int(pesBuffer[8])
+	if headerLength > len(pesBuffer) {
+		return packets
+	}
+
+	var es []byte
+	if isVideo {
+		es = encryptH264SampleAes(pesBuffer[headerLength:], block, iv)
+	} else {
+		es = encryptAacSampleAes(pesBuffer[headerLength:], block, iv)
+	}
+	pesBuffer = append(pesBuffer[0:headerLength:headerLength], es...)
+
+	// 长度为0表示不限长度，保持为0
+	if pesBuffer[4] != 0 || pesBuffer[5] != 0 {
+		pesLength := len(pesBuffer) - 6
+		if pesLength > 0xFFFF {
+			pesLength = 0
+		}
+		pesBuffer[4] = byte(pesLength >> 8)
+		pesBuffer[5] = byte(pesLength)
+	}
+
+	return repacketizePes(packets, pesBuffer)
+}
+
+// repacketizePes 按原有的包头及适配域重新打包pes，数据变少时丢弃多余的包，数据变多时追加包
+// 最后一个包的空余部分以适配域填充
+func repacketizePes(packets [][]byte, pesBuffer []byte) [][]byte {
+
+	var result [][]byte
+	var i int
+	for i = 0; len(pesBuffer) > 0; i++ {
+
+		// 原有的包用完后以第一个包的包头追加包
+		var header []byte
+		var adaptation []byte
+		if i < len(packets) {
+			header = packets[i][0:4]
+			payloadOffset := getPayloadOffset(packets[i])
+			if payloadOffset > 5 {
+				adaptation = packets[i][5:payloadOffset]
+			}
+		} else {
+			header = []byte{packets[0][0], packets[0][1] &^ 0x40, packets[0][2], packets[0][3] & 0x0F}
+		}
+
+		// 适配域长度(8)+适配域
+		var adaptationSize int = 0
+		if i < len(packets) && packets[i][3]&0x20 != 0 {
+			adaptationSize = 1 + len(adaptation)
+		}
+
+		payloadSize := TsPkgSize - 4 - adaptationSize
+		if payloadSize > len(pesBuffer) {
+			payloadSize = len(pesBuffer)
+		}
+
+		var pKgBuf []byte = make([]byte, 0, TsPkgSize)
+		pKgBuf = append(pKgBuf, header[0], header[1], header[2], header[3]&0xCF|0x10)
+
+		// 适配域填充到包的剩余长度
+		stuffingSize := TsPkgSize - 4 - payloadSize
+		if stuffingSize > 0 {
+			pKgBuf[3] |= 0x20
+			pKgBuf = append(pKgBuf, byte(stuffingSize-1))
+			if stuffingSize > 1 {
+				if len(adaptation) == 0 {
+					adaptation = []byte{0x00}
+				}
+				pKgBuf = append(pKgBuf, adaptation...)
+				for len(pKgBuf) < TsPkgSize-payloadSize {
+					pKgBuf = append(pKgBuf, 0xFF)
+				}
+			}
+		}
+
+		pKgBuf = append(pKgBuf, pesBuffer[0:payloadSize]...)
+		pesBuffer = pesBuffer[payloadSize:]
+		result = append(result, pKgBuf)
+	}
+
+	return result
+}
+
+// getPayloadOffset 有效载荷在ts包中的位置
+func getPayloadOffset(pKgBuf []byte) int {
+
+	if pKgBuf[3]&0x20 == 0 {
+		return 4
+	}
+
+	offset := 5 + int(pKgBuf[4])
+	if offset > TsPkgSize {
+		return TsPkgSize
+	}
+	return offset
+}
+
+// encryptH264SampleAes 加密 H.264 帧中的 slice
+// 长度超过48字节的 slice 保留开始的32字节，之后每160字节加密开始的16字节，不足16字节的结尾保持明文
+// 加密在去除防竞争字节后进行，加密后重新插入防竞争字节
+func encryptH264SampleAes(es []byte, block cipher.Block, iv []byte) []byte {
+
+	var result []byte = make([]byte, 0, len(es)+64)
+
+	// 上一个NAL单元的起始位置，-1 表示尚未找到起始码
+	var start int = -1
+	var copied int = 0
+	encryptNal := func(end int) {
+
+		// 四字节起始码的前导0不属于上一个NAL单元
+		for end > start && es[end-1] == 0x00 {
+			end--
+		}
+
+		nal := es[start:end]
+		if len(nal) <= sampleAesNalMinSize || (h264NalType(nal) != h264NalTypeSlice && h264NalType(nal) != h264NalTypeIDR) {
+			return
+		}
+
+		rbsp := removeEmulationPrevention(nal)
+		var data []byte = rbsp[sampleAesNalClearSize:]
+		mode := cipher.NewCBCEncrypter(block, iv)
+		for len(data) > aes.BlockSize {
+			mode.CryptBlocks(data[0:aes.BlockSize], data[0:aes.BlockSize])
+			data = data[aes.BlockSize:]
+			if len(data) > sampleAesNalSkipSize {
+				data = data[sampleAesNalSkipSize:]
+			} else {
+				data = nil
+			}
+		}
+
+		result = append(result, es[copied:start]...)
+		result = append(result, addEmulationPrevention(rbsp)...)
+		copied = end
+	}
+
+	var i int
+	for i = 0; i+2 < len(es); i++ {
+		if es[i] == 0x00 && es[i+1] == 0x00 && es[i+2] == 0x01 {
+			if start >= 0 {
+				encryptNal(i)
+			}
+			start = i + 3
+			i += 2
+		}
+	}
+
+	// 最后一个NAL单元
+	if start >= 0 && start < len(es) {
+		encryptNal(len(es))
+	}
+
+	return append(result, es[copied:]...)
+}
+
+// addEmulationPrevention 在NAL单元中插入防竞争字节，连续两个0之后为0~3时插入0x03，以0结尾时追加0x03
+func addEmulationPrevention(rbsp []byte) []byte {
+
+	var nal []byte = make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	var zeroCount int = 0
+
+	for _, b := range rbsp {
+		if zeroCount >= 2 && b <= 0x03 {
+			nal = append(nal, 0x03)
+			zeroCount = 0
+		}
+
+		if b == 0x00 {
+			zeroCount++
+		} else {
+			zeroCount = 0
+		}
+		nal = append(nal, b)
+	}
+
+	if len(nal) > 0 && nal[len(nal)-1] == 0x00 {
+		nal = append(nal, 0x03)
+	}
+
+	return nal
+}
+
+// encryptAacSampleAes 加密 ADTS 帧，帧头及之后的16字节保持明文，之后的完整块加密，不足16字节的结尾保持明文
+// 长度不变，不完整的帧保持明文
+func encryptAacSampleAes(es []byte, block cipher.Block, iv []byte) []byte {
+
+	var pos int = 0
+	for pos+7 <= len(es) {
+
+		if es[pos] != 0xFF || es[pos+1]&0xF0 != 0xF0 {
+			pos++
+			continue
+		}
+
+		// protection_absent 为 0 时帧头之后有2字节的CRC
+		headerLength := 7
+		if es[pos+1]&0x1 == 0 {
+			headerLength = 9
+		}
+
+		// aac_frame_length(13) 包含帧头
+		frameLength := int(es[pos+3]&0x3)<<11 | int(es[pos+4])<<3 | int(es[pos+5])>>5
+		if frameLength <= headerLength || pos+frameLength > len(es) {
+			break
+		}
+
+		data := es[pos+headerLength : pos+frameLength]
+		if len(data) > sampleAesAudioLeadSize {
+			data = data[sampleAesAudioLeadSize:]
+			data = data[0 : len(data)/aes.BlockSize*aes.BlockSize]
+			cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+		}
+
+		pos += frameLength
+	}
+
+	return es
+}
+
+// createSampleAesPmtSection 生成加密后的pmt段，替换视频及音频的流类型并追加描述符
+// 视频：private_data_indicator_descriptor('zavc')
+// 音频：private_data_indicator_descriptor('aacd')，registration_descriptor('apad')，
+// audio_setup_information: audio_type(32),priming(16),version(8),setup_data_length(8),setup_data()
+func createSampleAesPmtSection(sampleAes *SampleAes) []byte {
+
+	section := sampleAes.PmtSection
+	if len(section) < 16 {
+		return nil
+	}
+
+	// 段头之后为 PCR_PID(16),program_info_length(16),节目描述信息
+	programInfoLength := int(section[10]&0x0F)<<8 | int(section[11])
+	if 12+programInfoLength > len(section)-4 {
+		return nil
+	}
+
+	var isAudio map[uint16]bool = make(map[uint16]bool)
+	for _, PID := range sampleAes.AudioPIDs {
+		isAudio[PID] = true
+	}
+
+	var audioDescriptors []byte = []byte{descriptorTagPrivateDataIndicator, 4, 'a', 'a', 'c', 'd'}
+	var audioSetup []byte = append([]byte{'z', 'a', 'a', 'c', 0x00, 0x00, 0x01, byte(len(sampleAes.AudioSetup))}, sampleAes.AudioSetup...)
+	audioDescriptors = append(audioDescriptors, descriptorTagRegistration, byte(4+len(audioSetup)), 'a', 'p', 'a', 'd')
+	audioDescriptors = append(audioDescriptors, audioSetup...)
+
+	var loopData []byte = append([]byte(nil), section[8:12+programInfoLength]...)
+
+	var pos int = 12 + programInfoLength
+	for pos+5 <= len(section)-4 {
+
+		streamType := section[pos]
+		elementaryPID := uint16(section[pos+1]&0x1F)<<8 | uint16(section[pos+2])
+		esInfoLength := int(section[pos+3]&0x0F)<<8 | int(section[pos+4])
+		if pos+5+esInfoLength > len(section)-4 {
+			return nil
+		}
+		descriptors := append([]byte(nil), section[pos+5:pos+5+esInfoLength]...)
+
+		if elementaryPID == sampleAes.VideoPID && streamType == StreamTypeH264 {
+			streamType = StreamTypeH264SampleAes
+			descriptors = append(descriptors, descriptorTagPrivateDataIndicator, 4, 'z', 'a', 'v', 'c')
+		} else if isAudio[elementaryPID] && streamType == StreamTypeAAC {
+			streamType = StreamTypeAACSampleAes
+			descriptors = append(descriptors, audioDescriptors...)
+		}
+
+		loopData = append(loopData, streamType, 0xE0|byte(elementaryPID>>8&0x1F), byte(elementaryPID),
+			0xF0|byte(len(descriptors)>>8&0x0F), byte(len(descriptors)))
+		loopData = append(loopData, descriptors...)
+		pos += 5 + esInfoLength
+	}
+
+	tableIDExtension := uint16(section[3])<<8 | uint16(section[4])
+	versionNumber := section[5] >> 1 & 0x1F
+	return muxSection(0x02, tableIDExtension, versionNumber, loopData)
+}
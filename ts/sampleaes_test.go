@@ -0,0 +1,221 @@
+package ts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// sampleAesTestKey 测试使用的密钥及初始向量
+var sampleAesTestKey []byte = []byte("0123456789abcdef")
+var sampleAesTestIV []byte = []byte("fedcba9876543210")
+
+// encryptBlocksAt 按 CBC 方式依次加密 offsets 处的16字节块，每个NAL单元或音频帧重新开始
+func encryptBlocksAt(t *testing.T, data []byte, offsets []int) []byte {
+
+	block, err := aes.NewCipher(sampleAesTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []byte = append([]byte(nil), data...)
+	var chain []byte
+	for _, offset := range offsets {
+		chain = append(chain, result[offset:offset+aes.BlockSize]...)
+	}
+	cipher.NewCBCEncrypter(block, sampleAesTestIV).CryptBlocks(chain, chain)
+
+	var i int
+	for i = range offsets {
+		copy(result[offsets[i]:offsets[i]+aes.BlockSize], chain[i*aes.BlockSize:])
+	}
+	return result
+}
+
+// TestEncryptH264SampleAes 校验 slice 的加密范围：开始的32字节明文，之后每160字节加密开始的16字节，不超过16字节的结尾保持明文
+func TestEncryptH264SampleAes(t *testing.T) {
+
+	var cases = []struct {
+		name    string
+		nalType uint8
+		size    int   // NAL单元长度，包含NAL头
+		blocks  []int // 加密块在NAL单元中的位置
+	}{
+		{"sps", h264NalTypeSPS, 400, nil},
+		{"short slice", h264NalTypeSlice, 48, nil},
+		{"one partial block", h264NalTypeSlice, 49, []int{32}},
+		{"exact trailing block", h264NalTypeSlice, 208, []int{32}},
+		{"two blocks", h264NalTypeSlice, 209, []int{32, 192}},
+		{"idr", h264NalTypeIDR, 400, []int{32, 192, 352}},
+	}
+
+	block, _ := aes.NewCipher(sampleAesTestKey)
+	for _, c := range cases {
+
+		// 数据中不含0，不需要防竞争字节
+		var nal []byte = make([]byte, c.size)
+		nal[0] = 0x60 | c.nalType
+		var i int
+		for i = 1; i < c.size; i++ {
+			nal[i] = byte(i%255 + 1)
+		}
+
+		// 在前后各放一个不加密的访问单元分隔符
+		var es []byte = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0}
+		es = append(es, 0x00, 0x00, 0x00, 0x01)
+		es = append(es, nal...)
+		es = append(es, 0x00, 0x00, 0x01, 0x09, 0xF0)
+
+		var want []byte = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0, 0x00, 0x00, 0x00, 0x01}
+		want = append(want, encryptBlocksAt(t, nal, c.blocks)...)
+		want = append(want, 0x00, 0x00, 0x01, 0x09, 0xF0)
+
+		got := encryptH264SampleAes(es, block, sampleAesTestIV)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: encrypted slice layout mismatch\ngot  % X\nwant % X", c.name, got, want)
+		}
+	}
+}
+
+// adtsFrame 生成指定长度的 ADTS 帧，crc 为 true 时帧头包含2字节的CRC
+func adtsFrame(length int, crc bool, fill byte) []byte {
+
+	var frame []byte = []byte{0xFF, 0xF1, 0x50, 0x80 | byte(length>>11&0x3), byte(length >> 3), byte(length&0x7)<<5 | 0x1F, 0xFC}
+	if crc {
+		frame[1] = 0xF0
+		frame = append(frame, 0x00, 0x00)
+	}
+	for len(frame) < length {
+		frame = append(frame, fill)
+		fill++
+	}
+	return frame
+}
+
+// TestEncryptAacSampleAes 校验 ADTS 帧的加密范围：帧头及之后的16字节明文，之后的完整块加密，不足16字节的结尾保持明文
+func TestEncryptAacSampleAes(t *testing.T) {
+
+	var cases = []struct {
+		name   string
+		frames [][]byte
+		blocks [][]int // 每个帧中加密块的位置
+	}{
+		{"lead only", [][]byte{adtsFrame(23, false, 1)}, [][]int{nil}},
+		{"partial block", [][]byte{adtsFrame(38, false, 1)}, [][]int{nil}},
+		{"two blocks", [][]byte{adtsFrame(63, false, 1)}, [][]int{{23, 39}}},
+		{"with crc", [][]byte{adtsFrame(57, true, 1)}, [][]int{{25, 41}}},
+		{"two frames", [][]byte{adtsFrame(40, false, 1), adtsFrame(40, false, 1)}, [][]int{{23}, {23}}},
+	}
+
+	block, _ := aes.NewCipher(sampleAesTestKey)
+	for _, c := range cases {
+
+		var es []byte
+		var want []byte
+		var i int
+		for i = range c.frames {
+			es = append(es, c.frames[i]...)
+			want = append(want, encryptBlocksAt(t, c.frames[i], c.blocks[i])...)
+		}
+
+		// 不完整的帧保持明文
+		es = append(es, adtsFrame(100, false, 1)[0:60]...)
+		want = append(want, adtsFrame(100, false, 1)[0:60]...)
+
+		got := encryptAacSampleAes(es, block, sampleAesTestIV)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: encrypted frame layout mismatch\ngot  % X\nwant % X", c.name, got, want)
+		}
+	}
+}
+
+// testPesPackets 将 pes 打包为有效载荷长度为184的ts包，pes 长度为184的整数倍
+func testPesPackets(PID uint16, cc uint8, pes []byte) []byte {
+
+	var result []byte
+	var i int
+	for i = 0; i*184 < len(pes); i++ {
+		var header []byte = []byte{0x47, byte(PID >> 8 & 0x1F), byte(PID), 0x10 | (cc+uint8(i))&0x0F}
+		if i == 0 {
+			header[1] |= 0x40
+		}
+		result = append(result, header...)
+		result = append(result, pes[i*184:(i+1)*184]...)
+	}
+	return result
+}
+
+// TestSampleAesWriter 校验流式加密与一次加密整个分片的结果相同，且结束的pes在 Close 之前写出
+func TestSampleAesWriter(t *testing.T) {
+
+	pmtSection := muxPmtSection(&Pmt{ProgramNumber: 1, PcrPID: 0x100, Streams: []Stream{
+		{StreamType: StreamTypeH264, ElementaryPID: 0x100},
+		{StreamType: StreamTypeAAC, ElementaryPID: 0x101},
+	}})
+	sampleAes := &SampleAes{Key: sampleAesTestKey, IV: sampleAesTestIV, PmtPID: 0x1000, PmtSection: pmtSection,
+		VideoPID: 0x100, AudioPIDs: []uint16{0x101}, AudioSetup: []byte{0x12, 0x10}}
+
+	// 视频 pes：pes头、起始码及 IDR slice，共3个包
+	var videoPes []byte = []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65}
+	for len(videoPes) < 184*3 {
+		videoPes = append(videoPes, byte(len(videoPes)%255+1))
+	}
+
+	// 音频 pes：pes头及一个 ADTS 帧，共1个包
+	var audioPes []byte = []byte{0x00, 0x00, 0x01, 0xC0, 0x00, 178, 0x80, 0x00, 0x00}
+	audioPes = append(audioPes, adtsFrame(175, false, 1)...)
+
+	var segment []byte = append([]byte(nil), packetizeSection(0x1000, pmtSection, 1)[0]...)
+	var i uint8
+	for i = 0; i < 3; i++ {
+		segment = append(segment, testPesPackets(0x100, i*3, videoPes)...)
+		segment = append(segment, testPesPackets(0x101, i, audioPes)...)
+	}
+
+	whole, err := EncryptSampleAes(segment, sampleAes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name      string
+		chunkSize int
+	}{
+		{"packet", TsPkgSize},
+		{"partial packet", 100},
+		{"large", 1000},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		writer, err := NewSampleAesWriter(&buf, sampleAes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var pos int
+		for pos = 0; pos < len(segment); pos += c.chunkSize {
+			end := pos + c.chunkSize
+			if end > len(segment) {
+				end = len(segment)
+			}
+			writer.Write(segment[pos:end])
+		}
+
+		// 第一个视频 pes 在第二个视频 pes 开始时结束，Close 之前已写出
+		if buf.Len() < TsPkgSize*5 {
+			t.Errorf("%s: %d bytes written before Close, want at least %d", c.name, buf.Len(), TsPkgSize*5)
+		}
+
+		writer.Close()
+		if !bytes.Equal(buf.Bytes(), whole) {
+			t.Errorf("%s: streamed output differs from EncryptSampleAes", c.name)
+		}
+	}
+
+	// pmt 替换为加密后的流类型，视频 pes 被加密
+	if len(whole) < len(segment) || bytes.Equal(whole[TsPkgSize:], segment[TsPkgSize:]) {
+		t.Errorf("segment was not encrypted")
+	}
+}
@@ -0,0 +1,383 @@
+package ts
+
+import (
+	"fmt"
+)
+
+// StreamTypeSCTE35 SCTE-35 切点信息流类型
+const StreamTypeSCTE35 uint8 = 0x86
+
+// spliceInfoTableID splice_info_section 的表ID
+const spliceInfoTableID uint8 = 0xFC
+
+// 切点命令类型
+const (
+	SpliceCommandInsert     uint8 = 0x05 // splice_insert
+	SpliceCommandTimeSignal uint8 = 0x06 // time_signal
+)
+
+// 切点类型
+const (
+	SpliceTypeOut uint8 = 1 // 离开节目(广告开始)
+	SpliceTypeIn  uint8 = 2 // 返回节目(广告结束)
+)
+
+// 切点描述符
+const (
+	spliceDescriptorTagSegmentation uint8  = 0x02       // segmentation_descriptor
+	spliceDescriptorIdentifier      uint32 = 0x43554549 // "CUEI"
+)
+
+// SpliceEvent SCTE-35 切点事件
+type SpliceEvent struct {
+	PID         uint16 // 切点信息流PID
+	CommandType uint8  // 切点命令类型
+	EventID     uint32 // splice_insert 为 splice_event_id，time_signal 为 segmentation_event_id
+	Type        uint8  // 切点类型
+	Cancel      bool   // 是否取消之前的同一事件
+	PTS         int64  // 切点时间戳，已加上 pts_adjustment，-1 表示立即执行
+	Duration    int64  // 时长，单位为 TimeScale，0 表示未知
+	Data        []byte // 完整的 splice_info_section
+}
+
+// segmentationTypesOut 表示广告开始的 segmentation_type_id，对应的结束类型为其值加1
+var segmentationTypesOut = []uint8{
+	0x22, // Break Start
+	0x30, // Provider Advertisement Start
+	0x32, // Distributor Advertisement Start
+	0x34, // Provider Placement Opportunity Start
+	0x36, // Distributor Placement Opportunity Start
+	0x38, // Provider Overlay Placement Opportunity Start
+	0x3A, // Distributor Overlay Placement Opportunity Start
+	0x3C, // Provider Promo Start
+	0x3E, // Distributor Promo Start
+	0x44, // Provider Ad Block Start
+	0x46, // Distributor Ad Block Start
+}
+
+// isSpliceInfoPID 是否为选择的节目中的SCTE-35切点信息流
+func (d *Demuxer) isSpliceInfoPID(PID uint16) bool {
+	for _, s := range d.globalpmt.Streams {
+		if s.ElementaryPID == PID && s.StreamType == StreamTypeSCTE35 {
+			return true
+		}
+	}
+	return false
+}
+
+// readSpliceInfo 解析 splice_info_section(SCTE 35)，只支持 splice_insert 及 time_signal，加密的段将被忽略
+// table_id(8),section_syntax_indicator(1),private_indicator(1),sap_type(2),section_length(12),
+// protocol_version(8),encrypted_packet(1),encryption_algorithm(6),pts_adjustment(33),cw_index(8),tier(12),
+// splice_command_length(12),splice_command_type(8),splice_command,descriptor_loop_length(16),descriptors,CRC_32(32)
+func (d *Demuxer) readSpliceInfo(payload []byte, pHeader *Header) {
+
+	// 校验段长度及CRC
+	if !d.verifySection(payload, 20) {
+		return
+	}
+
+	if payload[0] != spliceInfoTableID {
+		return
+	}
+
+	// 有效负载总长度，去除CRC
+	var plen int = 3 + getSectionLength(payload) - 4
+	section := payload[0 : plen+4]
+
+	encrypted := payload[4]&0x80 != 0
+	if encrypted {
+		Log.Debug("Encrypted splice info is not supported, PID: " + fmt.Sprint(pHeader.PID))
+		return
+	}
+
+	ptsAdjustment := int64(payload[4]&0x1)<<32 | int64(payload[5])<<24 | int64(payload[6])<<16 | int64(payload[7])<<8 | int64(payload[8])
+	spliceCommandLength := int(payload[11]&0x0f)<<8 | int(payload[12])
+	spliceCommandType := payload[13]
+
+	// splice_command_length 为 0xFFF 时未指定长度，只能按命令解析
+	var pos int = 14
+	command := payload[pos:plen]
+	if spliceCommandLength != 0xFFF {
+		if pos+spliceCommandLength > plen {
+			Log.Debug("Splice command length error, PID: " + fmt.Sprint(pHeader.PID))
+			return
+		}
+		command = payload[pos : pos+spliceCommandLength]
+	}
+
+	var events []SpliceEvent
+	switch spliceCommandType {
+	case SpliceCommandInsert:
+
+		event, _, ok := readSpliceInsert(command)
+		if !ok {
+			Log.Debug("Splice insert error, PID: " + fmt.Sprint(pHeader.PID))
+			return
+		}
+		events = append(events, event)
+
+	case SpliceCommandTimeSignal:
+
+		pts, length, ok := readSpliceTime(command)
+		if !ok {
+			Log.Debug("Time signal error, PID: " + fmt.Sprint(pHeader.PID))
+			return
+		}
+		pos += length
+
+		// 切点类型由 segmentation_descriptor 决定
+		if pos+2 > plen {
+			return
+		}
+		descriptorLoopLength := int(payload[pos])<<8 | int(payload[pos+1])
+		pos += 2
+		if pos+descriptorLoopLength > plen {
+			Log.Debug("Splice descriptor length error, PID: " + fmt.Sprint(pHeader.PID))
+			return
+		}
+
+		for _, event := range readSegmentationDescriptors(payload[pos : pos+descriptorLoopLength]) {
+			event.PTS = pts
+			events = append(events, event)
+		}
+
+	default:
+
+		// splice_null、splice_schedule、bandwidth_reservation 等命令不包含切点
+		return
+	}
+
+	for _, event := range events {
+		event.PID = pHeader.PID
+		event.CommandType = spliceCommandType
+		event.Data = append([]byte(nil), section...)
+		if event.PTS >= 0 {
+			event.PTS = (event.PTS + ptsAdjustment) % ptsWrap
+		}
+
+		Log.Debug("识别到SCTE-35切点，PID：" + fmt.Sprint(event.PID) + "，事件ID：" + fmt.Sprint(event.EventID) +
+			"，类型：" + fmt.Sprint(event.Type) + "，PTS：" + fmt.Sprint(event.PTS))
+
+		if d.OnSplice != nil {
+			d.OnSplice(&event)
+		}
+	}
+}
+
+// readSpliceInsert 解析 splice_insert，返回切点事件及命令长度
+// splice_event_id(32),splice_event_cancel_indicator(1),reserved(7),
+// out_of_network_indicator(1),program_splice_flag(1),duration_flag(1),splice_immediate_flag(1),reserved(4),
+// [splice_time],[component_count(8),component_tag(8),[splice_time]...],[break_duration(40)],
+// unique_program_id(16),avail_num(8),avails_expected(8)
+func readSpliceInsert(command []byte) (SpliceEvent, int, bool) {
+
+	var event SpliceEvent
+	event.PTS = -1
+
+	if len(command) < 5 {
+		return event, 0, false
+	}
+
+	event.EventID = uint32(command[0])<<24 | uint32(command[1])<<16 | uint32(command[2])<<8 | uint32(command[3])
+	event.Cancel = command[4]&0x80 != 0
+	if event.Cancel {
+		return event, 5, true
+	}
+
+	if len(command) < 6 {
+		return event, 0, false
+	}
+
+	outOfNetwork := command[5]&0x80 != 0
+	programSplice := command[5]&0x40 != 0
+	durationFlag := command[5]&0x20 != 0
+	spliceImmediate := command[5]&0x10 != 0
+
+	event.Type = SpliceTypeIn
+	if outOfNetwork {
+		event.Type = SpliceTypeOut
+	}
+
+	var pos int = 6
+	if programSplice {
+
+		if !spliceImmediate {
+			pts, length, ok := readSpliceTime(command[pos:])
+			if !ok {
+				return event, 0, false
+			}
+			event.PTS = pts
+			pos += length
+		}
+
+	} else {
+
+		// 按分量切换时，使用第一个分量的切点时间
+		if pos+1 > len(command) {
+			return event, 0, false
+		}
+		componentCount := int(command[pos])
+		pos++
+
+		var i int
+		for i = 0; i < componentCount; i++ {
+			if pos+1 > len(command) {
+				return event, 0, false
+			}
+			pos++
+
+			if !spliceImmediate {
+				pts, length, ok := readSpliceTime(command[pos:])
+				if !ok {
+					return event, 0, false
+				}
+				if i == 0 {
+					event.PTS = pts
+				}
+				pos += length
+			}
+		}
+	}
+
+	// auto_return(1),reserved(6),duration(33)
+	if durationFlag {
+		if pos+5 > len(command) {
+			return event, 0, false
+		}
+		event.Duration = int64(command[pos]&0x1)<<32 | int64(command[pos+1])<<24 | int64(command[pos+2])<<16 | int64(command[pos+3])<<8 | int64(command[pos+4])
+		pos += 5
+	}
+
+	// unique_program_id(16),avail_num(8),avails_expected(8)
+	pos += 4
+	if pos > len(command) {
+		return event, 0, false
+	}
+
+	return event, pos, true
+}
+
+// readSpliceTime 解析 splice_time，返回时间戳及长度，未指定时间时时间戳为-1
+// time_specified_flag(1),[reserved(6),pts_time(33)]|[reserved(7)]
+func readSpliceTime(data []byte) (int64, int, bool) {
+
+	if len(data) < 1 {
+		return -1, 0, false
+	}
+
+	timeSpecified := data[0]&0x80 != 0
+	if !timeSpecified {
+		return -1, 1, true
+	}
+
+	if len(data) < 5 {
+		return -1, 0, false
+	}
+
+	pts := int64(data[0]&0x1)<<32 | int64(data[1])<<24 | int64(data[2])<<16 | int64(data[3])<<8 | int64(data[4])
+	return pts, 5, true
+}
+
+// readSegmentationDescriptors 解析 segmentation_descriptor，只返回广告相关的切点事件
+// splice_descriptor_tag(8),descriptor_length(8),identifier(32),segmentation_event_id(32),
+// segmentation_event_cancel_indicator(1),reserved(7),program_segmentation_flag(1),segmentation_duration_flag(1),
+// delivery_not_restricted_flag(1),reserved(5),[component_count(8),components(48)...],[segmentation_duration(40)],
+// segmentation_upid_type(8),segmentation_upid_length(8),segmentation_upid,segmentation_type_id(8)...
+func readSegmentationDescriptors(descriptors []byte) []SpliceEvent {
+
+	var events []SpliceEvent
+	var pos int
+	for pos+2 <= len(descriptors) {
+
+		tag := descriptors[pos]
+		length := int(descriptors[pos+1])
+		pos += 2
+
+		if pos+length > len(descriptors) {
+			break
+		}
+		descriptor := descriptors[pos : pos+length]
+		pos += length
+
+		if tag != spliceDescriptorTagSegmentation || len(descriptor) < 9 {
+			continue
+		}
+
+		identifier := uint32(descriptor[0])<<24 | uint32(descriptor[1])<<16 | uint32(descriptor[2])<<8 | uint32(descriptor[3])
+		if identifier != spliceDescriptorIdentifier {
+			continue
+		}
+
+		var event SpliceEvent
+		event.EventID = uint32(descriptor[4])<<24 | uint32(descriptor[5])<<16 | uint32(descriptor[6])<<8 | uint32(descriptor[7])
+		event.Cancel = descriptor[8]&0x80 != 0
+		if event.Cancel {
+			events = append(events, event)
+			continue
+		}
+
+		if len(descriptor) < 10 {
+			continue
+		}
+		programSegmentation := descriptor[9]&0x80 != 0
+		durationFlag := descriptor[9]&0x40 != 0
+
+		var p int = 10
+		if !programSegmentation {
+			if p+1 > len(descriptor) {
+				continue
+			}
+			p += 1 + int(descriptor[p])*6
+		}
+
+		if durationFlag {
+			if p+5 > len(descriptor) {
+				continue
+			}
+			event.Duration = int64(descriptor[p])<<32 | int64(descriptor[p+1])<<24 | int64(descriptor[p+2])<<16 | int64(descriptor[p+3])<<8 | int64(descriptor[p+4])
+			p += 5
+		}
+
+		// segmentation_upid_type(8),segmentation_upid_length(8),segmentation_upid
+		if p+2 > len(descriptor) {
+			continue
+		}
+		p += 2 + int(descriptor[p+1])
+		if p+1 > len(descriptor) {
+			continue
+		}
+
+		event.Type = getSegmentationSpliceType(descriptor[p])
+		if event.Type == 0 {
+			Log.Debug("Segmentation type is not an advertisement, type: " + fmt.Sprint(descriptor[p]))
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// getSegmentationSpliceType 根据 segmentation_type_id 判断切点类型，非广告相关时返回0
+func getSegmentationSpliceType(segmentationTypeID uint8) uint8 {
+	for _, typeOut := range segmentationTypesOut {
+		if segmentationTypeID == typeOut {
+			return SpliceTypeOut
+		}
+		if segmentationTypeID == typeOut+1 {
+			return SpliceTypeIn
+		}
+	}
+	return 0
+}
+
+// SplicePoint 索引中的切点，时间单位为 TimeScale，从媒体开始时计时
+type SplicePoint struct {
+	Time        int64  // 切点时间
+	Duration    int64  // 时长，0 表示未知
+	EventID     uint32 // 事件ID
+	Type        uint8  // 切点类型
+	CommandType uint8  // 切点命令类型
+	Data        []byte // 完整的 splice_info_section
+}
@@ -0,0 +1,375 @@
+package ts
+
+import (
+	"fmt"
+	"unicode/utf16"
+)
+
+// sdtTableIDActual 描述当前TS流的SDT表ID
+const sdtTableIDActual uint8 = 0x42
+
+// descriptorTagService 业务描述符标签
+const descriptorTagService uint8 = 0x48
+
+// ServiceInfo SDT中的业务描述信息
+type ServiceInfo struct {
+	ServiceID    uint16 //16 业务ID，与pmt中的节目号一致
+	ServiceType  uint8  //8 业务类型，0x01 数字电视，0x02 数字广播，0x19 高清电视等
+	ProviderName string // 业务提供者名称
+	ServiceName  string // 业务名称
+}
+
+// GetServiceInfo 获取节目的业务描述信息，未识别到SDT时返回false
+func (d *Demuxer) GetServiceInfo(programNumber uint16) (ServiceInfo, bool) {
+	info, ok := d.services[programNumber]
+	return info, ok
+}
+
+// readsdt 解析SDT表，损坏或不支持的段将被忽略
+func (d *Demuxer) readsdt(payload []byte) {
+
+	// 校验段长度及CRC
+	if !d.verifySection(payload, 15) {
+		return
+	}
+
+	// 0x42 为当前TS流的SDT，0x46 为其他TS流的SDT，0x4A 为BAT，均忽略
+	tableID := payload[0]
+	if tableID != sdtTableIDActual {
+		return
+	}
+
+	sectionLength := uint16(payload[1]&0x0f)<<8 | uint16(payload[2])
+	currentNextIndicator := payload[5] & 0x1
+	if currentNextIndicator != 0x1 {
+		return
+	}
+
+	// 有效负载总长度，去除CRC
+	var plen int = 3 + int(sectionLength) - 4
+
+	// 跳过 transport_stream_id(16),reserved(2),version_number(5),current_next_indicator(1),
+	// section_number(8),last_section_number(8),original_network_id(16),reserved_future_use(8)
+	var pos int = 11
+	for pos+5 <= plen {
+
+		serviceID := uint16(payload[pos])<<8 | uint16(payload[pos+1])
+		descriptorsLoopLength := int(payload[pos+3]&0x0f)<<8 | int(payload[pos+4])
+		pos += 5
+
+		if pos+descriptorsLoopLength > plen {
+			Log.Debug("SDT descriptors length error, serviceID: " + fmt.Sprint(serviceID))
+			return
+		}
+
+		descriptor := findDescriptor(payload[pos:pos+descriptorsLoopLength], descriptorTagService)
+		pos += descriptorsLoopLength
+
+		// service_type(8),service_provider_name_length(8),service_provider_name,service_name_length(8),service_name
+		if len(descriptor) < 2 {
+			continue
+		}
+
+		var info ServiceInfo
+		info.ServiceID = serviceID
+		info.ServiceType = descriptor[0]
+
+		providerNameLength := int(descriptor[1])
+		if 2+providerNameLength+1 > len(descriptor) {
+			continue
+		}
+		info.ProviderName = decodeDvbText(descriptor[2 : 2+providerNameLength])
+
+		serviceNameStart := 2 + providerNameLength + 1
+		serviceNameLength := int(descriptor[serviceNameStart-1])
+		if serviceNameStart+serviceNameLength > len(descriptor) {
+			continue
+		}
+		info.ServiceName = decodeDvbText(descriptor[serviceNameStart : serviceNameStart+serviceNameLength])
+
+		// 业务信息未变化
+		if oldInfo, ok := d.services[serviceID]; ok && oldInfo == info {
+			continue
+		}
+
+		Log.Debug("识别到业务，ID：" + fmt.Sprint(serviceID) + "，名称：" + info.ServiceName)
+		d.services[serviceID] = info
+
+		if d.OnService != nil {
+			d.OnService(&info)
+		}
+	}
+}
+
+// decodeDvbText 解码DVB文本字符串(EN 300 468 附录A)
+// 支持默认字符集、UTF-8、UTF-16 及常用的 ISO/IEC 8859 字符集，不支持的字符集只保留 ASCII 部分
+func decodeDvbText(data []byte) string {
+
+	if len(data) == 0 {
+		return ""
+	}
+
+	// 首字节为字符集选择
+	switch {
+	case data[0] == 0x15:
+
+		// UTF-8
+		return removeDvbControlCodes([]rune(string(data[1:])))
+
+	case data[0] == 0x11:
+
+		// ISO/IEC 10646 基本多文种平面，UTF-16 大端
+		var units []uint16 = make([]uint16, 0, len(data)/2)
+		var i int
+		for i = 1; i+1 < len(data); i += 2 {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+		return removeDvbControlCodes(utf16.Decode(units))
+
+	case data[0] == 0x10:
+
+		// ISO/IEC 8859，后两字节为字符集编号
+		if len(data) < 3 {
+			return ""
+		}
+		return removeDvbControlCodes(decodeIso8859(int(data[1])<<8|int(data[2]), data[3:]))
+
+	case data[0] >= 0x01 && data[0] <= 0x0B:
+
+		// 单字节字符集选择，对应 ISO/IEC 8859 的部分编号
+		return removeDvbControlCodes(decodeIso8859(dvbCharsetParts[data[0]], data[1:]))
+
+	case data[0] == 0x1F:
+
+		// 编码方式ID
+		if len(data) < 2 {
+			return ""
+		}
+		return removeDvbControlCodes(decodeUnknownCharset(data[2:]))
+
+	case data[0] < 0x20:
+
+		// 其他多字节字符集(KS X 1001、GB2312、Big5 等)
+		return removeDvbControlCodes(decodeUnknownCharset(data[1:]))
+	}
+
+	// 默认字符集，以 ISO/IEC 6937 为基础
+	return removeDvbControlCodes(decodeIso6937(data))
+}
+
+// dvbCharsetParts 单字节字符集选择对应的 ISO/IEC 8859 部分编号(EN 300 468 表A.3)，0x08 为保留值
+var dvbCharsetParts = map[byte]int{
+	0x01: 5, 0x02: 6, 0x03: 7, 0x04: 8, 0x05: 9, 0x06: 10,
+	0x07: 11, 0x09: 13, 0x0A: 14, 0x0B: 15,
+}
+
+// iso8859Exceptions ISO/IEC 8859 各部分中与 Latin-1 不同的字符，0 表示未定义
+var iso8859Exceptions = map[int]map[byte]rune{
+	9: {
+		0xD0: 0x011E, 0xDD: 0x0130, 0xDE: 0x015E,
+		0xF0: 0x011F, 0xFD: 0x0131, 0xFE: 0x015F,
+	},
+	15: {
+		0xA4: 0x20AC, 0xA6: 0x0160, 0xA8: 0x0161, 0xB4: 0x017D,
+		0xB8: 0x017E, 0xBC: 0x0152, 0xBD: 0x0153, 0xBE: 0x0178,
+	},
+}
+
+// decodeIso8859 按 ISO/IEC 8859 的指定部分解码，0x00-0x9F 与 Latin-1 相同
+// 支持第1(西欧)、5(西里尔)、6(阿拉伯)、7(希腊)、8(希伯来)、9(土耳其)、11(泰文)、15(西欧)部分
+func decodeIso8859(part int, data []byte) []rune {
+
+	var runes []rune = make([]rune, 0, len(data))
+	for _, b := range data {
+
+		if b < 0xA0 {
+			runes = append(runes, rune(b))
+			continue
+		}
+
+		var r rune
+		switch part {
+		case 1:
+			r = rune(b)
+		case 5:
+			r = decodeIso8859Cyrillic(b)
+		case 6:
+			r = decodeIso8859Arabic(b)
+		case 7:
+			r = decodeIso8859Greek(b)
+		case 8:
+			r = decodeIso8859Hebrew(b)
+		case 9, 15:
+			r = rune(b)
+			if exception, ok := iso8859Exceptions[part][b]; ok {
+				r = exception
+			}
+		case 11:
+
+			// 泰文 0xA1-0xDA、0xDF-0xFB 对应 U+0E01-U+0E5B
+			if b == 0xA0 {
+				r = rune(b)
+			} else if b <= 0xDA || (b >= 0xDF && b <= 0xFB) {
+				r = rune(b) + 0x0D60
+			}
+		default:
+			return decodeUnknownCharset(data)
+		}
+
+		// 字符集中未定义的字符
+		if r == 0 {
+			r = 0xFFFD
+		}
+		runes = append(runes, r)
+	}
+
+	return runes
+}
+
+// decodeIso8859Cyrillic ISO/IEC 8859-5，0xA1-0xFF 对应 U+0401-U+045F
+func decodeIso8859Cyrillic(b byte) rune {
+	switch b {
+	case 0xA0, 0xAD:
+		return rune(b)
+	case 0xF0:
+		return 0x2116
+	case 0xFD:
+		return 0x00A7
+	}
+	return rune(b) + 0x0360
+}
+
+// decodeIso8859Arabic ISO/IEC 8859-6，阿拉伯字母对应 U+060C-U+0652
+func decodeIso8859Arabic(b byte) rune {
+	switch {
+	case b == 0xA0 || b == 0xA4 || b == 0xAD:
+		return rune(b)
+	case b == 0xAC || b == 0xBB || b == 0xBF || (b >= 0xC1 && b <= 0xDA) || (b >= 0xE0 && b <= 0xF2):
+		return rune(b) + 0x0560
+	}
+	return 0
+}
+
+// decodeIso8859Greek ISO/IEC 8859-7，希腊字母对应 U+0384-U+03CE
+func decodeIso8859Greek(b byte) rune {
+	switch b {
+	case 0xA1:
+		return 0x2018
+	case 0xA2:
+		return 0x2019
+	case 0xA4:
+		return 0x20AC
+	case 0xA5:
+		return 0x20AF
+	case 0xAA:
+		return 0x037A
+	case 0xAF:
+		return 0x2015
+	case 0xAE, 0xD2, 0xFF:
+		return 0
+	case 0xB7, 0xBB, 0xBD:
+		return rune(b)
+	}
+	if b >= 0xB4 {
+		return rune(b) + 0x02D0
+	}
+	return rune(b)
+}
+
+// decodeIso8859Hebrew ISO/IEC 8859-8，希伯来字母对应 U+05D0-U+05EA
+func decodeIso8859Hebrew(b byte) rune {
+	switch {
+	case b == 0xA0 || (b >= 0xA2 && b <= 0xBE):
+		if b == 0xAA {
+			return 0x00D7
+		}
+		if b == 0xBA {
+			return 0x00F7
+		}
+		return rune(b)
+	case b == 0xDF:
+		return 0x2017
+	case b >= 0xE0 && b <= 0xFA:
+		return rune(b) + 0x04F0
+	case b == 0xFD:
+		return 0x200E
+	case b == 0xFE:
+		return 0x200F
+	}
+	return 0
+}
+
+// decodeUnknownCharset 不支持的字符集，保留 ASCII 及控制字符，其他字节替换为 U+FFFD
+func decodeUnknownCharset(data []byte) []rune {
+
+	var runes []rune = make([]rune, 0, len(data))
+	for _, b := range data {
+		if b <= 0x9F {
+			runes = append(runes, rune(b))
+		} else {
+			runes = append(runes, 0xFFFD)
+		}
+	}
+
+	return runes
+}
+
+// removeDvbControlCodes 去除DVB文本中的控制字符(0x80-0x9F)，0x8A 为换行
+func removeDvbControlCodes(runes []rune) string {
+
+	var result []rune = make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if r == 0x8A {
+			result = append(result, ' ')
+			continue
+		}
+		if r < 0x20 || (r >= 0x80 && r <= 0x9F) {
+			continue
+		}
+		result = append(result, r)
+	}
+
+	return string(result)
+}
+
+// iso6937Table 默认字符集 0xA0-0xFF 对应的字符(EN 300 468 图A.1)，0 表示未定义
+// 0xC1-0xCF 为附加符号，对应组合用附加符号
+var iso6937Table = [96]rune{
+	0x00A0, 0x00A1, 0x00A2, 0x00A3, 0x20AC, 0x00A5, 0, 0x00A7, 0x00A4, 0x2018, 0x201C, 0x00AB, 0x2190, 0x2191, 0x2192, 0x2193,
+	0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x00D7, 0x00B5, 0x00B6, 0x00B7, 0x00F7, 0x2019, 0x201D, 0x00BB, 0x00BC, 0x00BD, 0x00BE, 0x00BF,
+	0, 0x0300, 0x0301, 0x0302, 0x0303, 0x0304, 0x0306, 0x0307, 0x0308, 0, 0x030A, 0x0327, 0, 0x030B, 0x0328, 0x030C,
+	0x2015, 0x00B9, 0x00AE, 0x00A9, 0x2122, 0x266A, 0x00AC, 0x00A6, 0, 0, 0, 0, 0x215B, 0x215C, 0x215D, 0x215E,
+	0x2126, 0x00C6, 0x0110, 0x00AA, 0x0126, 0, 0x0132, 0x013F, 0x0141, 0x00D8, 0x0152, 0x00BA, 0x00DE, 0x0166, 0x014A, 0x0149,
+	0x0138, 0x00E6, 0x0111, 0x00F0, 0x0127, 0x0131, 0x0133, 0x0140, 0x0142, 0x00F8, 0x0153, 0x00DF, 0x00FE, 0x0167, 0x014B, 0x00AD,
+}
+
+// decodeIso6937 按默认字符集解码，附加符号位于字母之前，解码后放在字母之后
+func decodeIso6937(data []byte) []rune {
+
+	var runes []rune = make([]rune, 0, len(data))
+	var diacritic rune = 0
+	for _, b := range data {
+
+		r := rune(b)
+		if b >= 0xA0 {
+			r = iso6937Table[b-0xA0]
+			if r == 0 {
+				r = 0xFFFD
+			}
+		}
+
+		// 附加符号，等待后一个字母
+		if b >= 0xC1 && b <= 0xCF && r != 0xFFFD {
+			diacritic = r
+			continue
+		}
+
+		runes = append(runes, r)
+		if diacritic != 0 {
+			runes = append(runes, diacritic)
+			diacritic = 0
+		}
+	}
+
+	return runes
+}
@@ -0,0 +1,111 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+
+	errors "../errors"
+)
+
+// Packet 解析后的ts包
+type Packet struct {
+	Header         // 包头
+	Offset  uint64 // 包在输入数据中的偏移量
+	Payload []byte // 有效载荷，不存在时为nil
+	Data    []byte // 188字节的ts包数据
+}
+
+// NewDemuxer 创建从 reader 读取媒体数据的解封装器
+// 自动识别包大小(188/192/204)并跳过损坏的数据，调用 Run 后通过回调输出ts包、PSI表及完整的pes
+func NewDemuxer(reader io.Reader) *Demuxer {
+
+	var d Demuxer
+	d.Init()
+	d.reader = newPacketReader(reader, TsPkgSize*TsReloadNum/100)
+	return &d
+}
+
+// Run 读取并解封装全部媒体数据，输入结束时输出各基本流最后一个pes
+// 单个包解析失败时跳过该包并计入统计信息，读取失败时返回错误
+func (d *Demuxer) Run() error {
+
+	if d.reader == nil {
+		err := errors.NewError(errors.ErrorCodeDemuxFailed, "Demuxer has no reader, create it with NewDemuxer!")
+		return err
+	}
+
+	for {
+		pKgBuf, pKgOffset, err := d.reader.ReadPacket()
+
+		// 读取失败
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+
+		// 包大小在读取第一个包时识别
+		d.SetPkgSize(d.reader.PkgSize())
+
+		// 跳过了损坏的数据
+		if pKgOffset != d.curOffset {
+			d.Resync(pKgOffset)
+		}
+
+		// 解封装，单个包解析失败时跳过该包
+		pes, err := d.DemuxPkg(pKgBuf)
+		if err != nil {
+			Log.Warn("Demux ts package failed: " + err.Error() + ", offset: " + fmt.Sprint(pKgOffset))
+			continue
+		}
+		if pes != nil && d.OnPes != nil {
+			d.OnPes(pes)
+		}
+	}
+
+	// 输入结束时各基本流最后一个pes
+	for _, pes := range d.Flush() {
+		if d.OnPes != nil {
+			d.OnPes(pes)
+		}
+	}
+
+	return nil
+}
+
+// PkgSize 源数据中每个包的字节数
+func (d *Demuxer) PkgSize() int {
+	return d.pkgSize
+}
+
+// SkippedRanges 跳过的损坏数据区间，仅通过 NewDemuxer 创建时有效
+func (d *Demuxer) SkippedRanges() []ByteRange {
+	if d.reader == nil {
+		return nil
+	}
+	return d.reader.SkippedRanges()
+}
+
+// SkippedSize 跳过的损坏数据总字节数，仅通过 NewDemuxer 创建时有效
+func (d *Demuxer) SkippedSize() uint64 {
+	if d.reader == nil {
+		return 0
+	}
+	return d.reader.SkippedSize()
+}
+
+// IndexPID 用于建立索引的流PID，有视频时为视频流，否则为音频流，尚未识别时为-1
+func (d *Demuxer) IndexPID() int {
+	return d.curIndexPID
+}
+
+// GetPat 获取当前的pat表，尚未识别时返回false
+func (d *Demuxer) GetPat() (Pat, bool) {
+	return d.globalpat, d.globalpat.pLoopData != nil
+}
+
+// GetPmt 获取选择的节目的pmt表，尚未识别时返回false
+func (d *Demuxer) GetPmt() (Pmt, bool) {
+	return d.globalpmt, d.globalpmt.pLoopData != nil
+}
@@ -0,0 +1,115 @@
+package ts
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 字幕类型
+const (
+	SubtitleTypeTeletext uint8 = 1 // EBU teletext 字幕页
+)
+
+// 字幕相关的描述符标签
+const (
+	descriptorTagVBITeletext uint8 = 0x46 // VBI teletext 描述符
+	descriptorTagTeletext    uint8 = 0x56 // teletext 描述符
+	descriptorTagSubtitling  uint8 = 0x59 // DVB 字幕描述符(位图字幕)
+)
+
+// teletext 描述符中的页类型
+const (
+	teletextTypeSubtitle        uint8 = 0x02 // 字幕页
+	teletextTypeHearingImpaired uint8 = 0x05 // 听障字幕页
+)
+
+// SubtitleTrack 字幕轨道
+type SubtitleTrack struct {
+	PID             uint16 // 字幕流PID
+	Type            uint8  // 字幕类型
+	Language        string // ISO 639 语言代码，未知时为空
+	Page            uint16 // teletext 页号，magazine(4bit)<<8|页号(8bit)，例如 0x888
+	HearingImpaired bool   // 是否为听障字幕
+}
+
+// PageNumber teletext 页号的显示形式，例如 888
+func (track *SubtitleTrack) PageNumber() string {
+	return fmt.Sprintf("%03x", track.Page)
+}
+
+// SubtitleCue 字幕条目，时间单位为 TimeScale，从媒体开始时计时
+type SubtitleCue struct {
+	Track     int    // 字幕轨道序号
+	StartTime int64  // 开始显示时间
+	EndTime   int64  // 结束显示时间
+	Text      string // 字幕文本，多行以换行分隔
+}
+
+// getSubtitleTracks 查找pmt中的字幕流，按PID及页号排序
+// DVB 位图字幕无法转换为文本，只支持 teletext 字幕页
+func getSubtitleTracks(pmt *Pmt) []SubtitleTrack {
+
+	var tracks []SubtitleTrack = make([]SubtitleTrack, 0)
+	for _, s := range pmt.Streams {
+
+		if s.StreamType != streamTypePES {
+			continue
+		}
+
+		if findDescriptor(s.Descriptors, descriptorTagSubtitling) != nil {
+			Log.Debug("DVB bitmap subtitle is not supported, PID: " + fmt.Sprint(s.ElementaryPID))
+		}
+
+		descriptor := findDescriptor(s.Descriptors, descriptorTagTeletext)
+		if descriptor == nil {
+			descriptor = findDescriptor(s.Descriptors, descriptorTagVBITeletext)
+		}
+
+		// ISO_639_language_code(24),teletext_type(5),teletext_magazine_number(3),teletext_page_number(8)
+		var pos int
+		for pos = 0; pos+5 <= len(descriptor); pos += 5 {
+
+			teletextType := descriptor[pos+3] >> 3
+			if teletextType != teletextTypeSubtitle && teletextType != teletextTypeHearingImpaired {
+				continue
+			}
+
+			// magazine 0 表示第8个magazine
+			magazine := uint16(descriptor[pos+3] & 0x7)
+			if magazine == 0 {
+				magazine = 8
+			}
+
+			var track SubtitleTrack
+			track.PID = s.ElementaryPID
+			track.Type = SubtitleTypeTeletext
+			track.Language = getLanguageCode(descriptor[pos : pos+3])
+			track.Page = magazine<<8 | uint16(descriptor[pos+4])
+			track.HearingImpaired = teletextType == teletextTypeHearingImpaired
+			tracks = append(tracks, track)
+
+			Log.Debug("识别到teletext字幕，PID：" + fmt.Sprint(track.PID) + "，页号：" + track.PageNumber() + "，语言：" + track.Language)
+		}
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].PID != tracks[j].PID {
+			return tracks[i].PID < tracks[j].PID
+		}
+		return tracks[i].Page < tracks[j].Page
+	})
+
+	return tracks
+}
+
+// getLanguageCode 解析 ISO 639 语言代码，非字母时返回空
+func getLanguageCode(data []byte) string {
+
+	for _, c := range data {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return ""
+		}
+	}
+
+	return string(data)
+}
@@ -0,0 +1,304 @@
+package ts
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// teletext 数据单元
+const (
+	dataUnitTeletext         uint8 = 0x02 // EBU teletext 非字幕数据
+	dataUnitTeletextSubtitle uint8 = 0x03 // EBU teletext 字幕数据
+	teletextUnitLength       int   = 44   // teletext 数据单元长度
+)
+
+// teletextRowCount 页中可显示的行数，第0行为页头
+const teletextRowCount int = 24
+
+// teletextNationalPositions 国家字符集替换的字符位置
+var teletextNationalPositions = [13]byte{0x23, 0x24, 0x40, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x7b, 0x7c, 0x7d, 0x7e}
+
+// teletextNationalSubsets 拉丁字符集的国家字符集(ETS 300 706 表36)，按页头 C12-C14 控制位排列
+var teletextNationalSubsets = [7][13]rune{
+	{'£', '$', '@', '←', '½', '→', '↑', '#', '―', '¼', '‖', '¾', '÷'}, // 英语
+	{'#', '$', '§', 'Ä', 'Ö', 'Ü', '^', '_', '°', 'ä', 'ö', 'ü', 'ß'}, // 德语
+	{'#', '¤', 'É', 'Ä', 'Ö', 'Å', 'Ü', '_', 'é', 'ä', 'ö', 'å', 'ü'}, // 瑞典语/芬兰语/匈牙利语
+	{'£', '$', 'é', '°', 'ç', '→', '↑', '#', 'ù', 'à', 'ò', 'è', 'ì'}, // 意大利语
+	{'é', 'ï', 'à', 'ë', 'ê', 'ù', 'î', '#', 'è', 'â', 'ô', 'û', 'ç'}, // 法语
+	{'ç', '$', '¡', 'á', 'é', 'í', 'ó', 'ú', '¿', 'ü', 'ñ', 'è', 'à'}, // 葡萄牙语/西班牙语
+	{'#', 'ů', 'č', 'ť', 'ž', 'ý', 'í', 'ř', 'é', 'á', 'ě', 'ú', 'š'}, // 捷克语/斯洛伐克语
+}
+
+// hamming84Table hamming 8/4 解码表，可纠正单个比特错误，-1 表示无法纠正
+var hamming84Table = newHamming84Table()
+
+// teletextPage 需要解析的字幕页
+type teletextPage struct {
+	track     int                      // 字幕轨道序号
+	page      uint16                   // 页号，magazine<<8|页号
+	receiving bool                     // 是否正在接收该页的行数据
+	charset   int                      // 国家字符集
+	startTime int64                    // 当前内容开始显示的时间
+	rows      [teletextRowCount][]byte // 行数据，已去除奇偶校验位
+}
+
+// teletextDecoder 解析EBU teletext 字幕页(ETS 300 706、EN 300 472)，输出字幕条目
+//
+// 页头到达时开始显示该页内容，直到同一页的下一个页头到达
+type teletextDecoder struct {
+	pages []*teletextPage // 需要解析的字幕页
+	cues  []SubtitleCue   // 已完成的字幕条目
+}
+
+// addPage 添加需要解析的字幕页
+//
+//	track 字幕轨道序号
+//	page 页号，magazine<<8|页号
+func (decoder *teletextDecoder) addPage(track int, page uint16) {
+	decoder.pages = append(decoder.pages, &teletextPage{track: track, page: page})
+}
+
+// feed 输入 teletext pes 的有效载荷
+//
+//	time pes的显示时间
+func (decoder *teletextDecoder) feed(payload []byte, time int64) {
+
+	// data_identifier 0x10-0x1F 为 EBU 数据
+	if len(payload) < 1 || payload[0] < 0x10 || payload[0] > 0x1F {
+		return
+	}
+
+	// data_unit_id(8),data_unit_length(8),data_field
+	var pos int = 1
+	for pos+2 <= len(payload) {
+		dataUnitID := payload[pos]
+		dataUnitLength := int(payload[pos+1])
+		pos += 2
+
+		if pos+dataUnitLength > len(payload) {
+			break
+		}
+
+		if (dataUnitID == dataUnitTeletext || dataUnitID == dataUnitTeletextSubtitle) && dataUnitLength == teletextUnitLength {
+			decoder.readPacket(payload[pos:pos+dataUnitLength], time)
+		}
+		pos += dataUnitLength
+	}
+}
+
+// flush 输入结束，正在显示的内容在 time 结束
+func (decoder *teletextDecoder) flush(time int64) {
+	for _, page := range decoder.pages {
+		decoder.finishPage(page, time)
+		page.receiving = false
+	}
+}
+
+// readPacket 解析 teletext 包
+// field_parity/line_offset(8),framing_code(8),magazine_and_packet_address(16),data_block(320)
+// 数据按先低位的顺序传输，需要先反转每个字节的位序
+func (decoder *teletextDecoder) readPacket(unit []byte, time int64) {
+
+	var packet [teletextUnitLength]byte
+	for i, b := range unit {
+		packet[i] = bits.Reverse8(b)
+	}
+
+	address0 := hamming84Table[packet[2]]
+	address1 := hamming84Table[packet[3]]
+	if address0 < 0 || address1 < 0 {
+		return
+	}
+
+	// magazine(3),packet_number(5)，magazine 0 表示第8个magazine
+	address := int(address1)<<4 | int(address0)
+	magazine := uint16(address & 0x7)
+	if magazine == 0 {
+		magazine = 8
+	}
+	row := address >> 3
+	data := packet[4:]
+
+	// 页头
+	if row == 0 {
+		decoder.readHeader(magazine, data, time)
+		return
+	}
+
+	// 增强数据包不包含显示的文本
+	if row >= teletextRowCount {
+		return
+	}
+
+	for _, page := range decoder.pages {
+		if page.receiving && page.page>>8 == magazine {
+			page.rows[row] = readTeletextRow(data)
+		}
+	}
+}
+
+// readHeader 解析页头
+// page_units(8),page_tens(8),subcode(32，包含 C4-C6),control(16，C7-C14)，均为 hamming 8/4 编码
+func (decoder *teletextDecoder) readHeader(magazine uint16, data []byte, time int64) {
+
+	pageUnits := hamming84Table[data[0]]
+	pageTens := hamming84Table[data[1]]
+	subcode2 := hamming84Table[data[3]]
+	control := hamming84Table[data[7]]
+	if pageUnits < 0 || pageTens < 0 || subcode2 < 0 || control < 0 {
+		return
+	}
+
+	pageNumber := magazine<<8 | uint16(pageTens)<<4 | uint16(pageUnits)
+
+	// C4 清除页内容，C11 串行传输模式，C12-C14 国家字符集
+	erasePage := subcode2&0x8 != 0
+	serialMode := control&0x1 != 0
+	charset := int(control>>1&0x1)<<2 | int(control>>2&0x1)<<1 | int(control>>3&0x1)
+
+	for _, page := range decoder.pages {
+
+		// 同一页的新页头，之前的内容结束显示
+		if page.page == pageNumber {
+			decoder.finishPage(page, time)
+			if erasePage {
+				page.rows = [teletextRowCount][]byte{}
+			}
+			page.receiving = true
+			page.charset = charset
+			page.startTime = time
+			continue
+		}
+
+		// 开始传输其他页，当前页的行数据接收结束，并行模式下只影响同一magazine
+		if serialMode || page.page>>8 == magazine {
+			page.receiving = false
+		}
+	}
+}
+
+// finishPage 页内容在 time 结束显示，生成字幕条目，与前一条内容相同且时间连续时合并
+func (decoder *teletextDecoder) finishPage(page *teletextPage, time int64) {
+
+	text := page.text()
+	if text == "" || time <= page.startTime {
+		return
+	}
+
+	var i int
+	for i = len(decoder.cues) - 1; i >= 0; i-- {
+		if decoder.cues[i].Track != page.track {
+			continue
+		}
+		if decoder.cues[i].Text == text && decoder.cues[i].EndTime == page.startTime {
+			decoder.cues[i].EndTime = time
+			return
+		}
+		break
+	}
+
+	decoder.cues = append(decoder.cues, SubtitleCue{Track: page.track, StartTime: page.startTime, EndTime: time, Text: text})
+}
+
+// text 页中显示的文本，去除空行，多行以换行分隔
+func (page *teletextPage) text() string {
+
+	var lines []string
+	var row int
+	for row = 1; row < teletextRowCount; row++ {
+		if page.rows[row] == nil {
+			continue
+		}
+		if line := teletextRowText(page.rows[row], page.charset); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// readTeletextRow 读取行数据，字符为奇校验，校验失败的字符按空格处理
+func readTeletextRow(data []byte) []byte {
+
+	var row []byte = make([]byte, len(data))
+	for i, b := range data {
+		if bits.OnesCount8(b)%2 == 1 {
+			row[i] = b & 0x7f
+		} else {
+			row[i] = 0x20
+		}
+	}
+
+	return row
+}
+
+// teletextRowText 转换行数据为文本
+// 字幕页中只显示 start box(0x0B) 与 end box(0x0A) 之间的字符，控制字符按空格处理，连续空格合并
+func teletextRowText(row []byte, charset int) string {
+
+	boxed := strings.IndexByte(string(row), 0x0B) >= 0
+	inBox := !boxed
+
+	var runes []rune = make([]rune, 0, len(row))
+	for _, c := range row {
+		switch {
+		case c == 0x0B:
+			inBox = true
+			runes = append(runes, ' ')
+		case c == 0x0A && boxed:
+			inBox = false
+			runes = append(runes, ' ')
+		case c < 0x20 || c == 0x7f || !inBox:
+			runes = append(runes, ' ')
+		default:
+			runes = append(runes, teletextChar(c, charset))
+		}
+	}
+
+	return strings.Join(strings.Fields(string(runes)), " ")
+}
+
+// teletextChar 按国家字符集转换字符，未知的字符集按英语处理
+func teletextChar(c byte, charset int) rune {
+
+	if charset >= len(teletextNationalSubsets) {
+		charset = 0
+	}
+
+	for i, position := range teletextNationalPositions {
+		if c == position {
+			return teletextNationalSubsets[charset][i]
+		}
+	}
+
+	return rune(c)
+}
+
+// newHamming84Table 生成 hamming 8/4 解码表
+// 传输顺序为 P1,D1,P2,D2,P3,D3,P4,D4，P4 为整个字节的奇校验
+func newHamming84Table() [256]int8 {
+
+	var table [256]int8
+	for i := range table {
+		table[i] = -1
+	}
+
+	var d uint8
+	for d = 0; d < 16; d++ {
+		d1, d2, d3, d4 := d&0x1, d>>1&0x1, d>>2&0x1, d>>3&0x1
+		p1 := 1 ^ d1 ^ d3 ^ d4
+		p2 := 1 ^ d1 ^ d2 ^ d4
+		p3 := 1 ^ d1 ^ d2 ^ d3
+		p4 := 1 ^ p1 ^ d1 ^ p2 ^ d2 ^ p3 ^ d3 ^ d4
+		code := p1 | d1<<1 | p2<<2 | d2<<3 | p3<<4 | d3<<5 | p4<<6 | d4<<7
+
+		// 码距为4，单个比特错误可纠正
+		table[code] = int8(d)
+		var bit uint
+		for bit = 0; bit < 8; bit++ {
+			table[code^(1<<bit)] = int8(d)
+		}
+	}
+
+	return table
+}